@@ -0,0 +1,110 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"worker/internal/config"
+	"worker/internal/store"
+)
+
+const usage = `Usage:
+  worker                      run the vote worker
+  worker migrate up           apply all pending migrations
+  worker migrate down [N]     roll back the last N migrations (default: 1)
+  worker migrate status       list migrations and when they were applied
+  worker config print         print the effective configuration with secrets redacted
+
+Options:
+  -config FILE                YAML or TOML config file (default: $WORKER_CONFIG);
+                              environment variables override its settings
+`
+
+// runConfig handles the config subcommand. The configuration is printed even
+// when it is invalid, followed by every problem found.
+func runConfig(cfg *config.Config, loadErr error, args []string) error {
+	if len(args) != 1 || args[0] != "print" {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	if cfg == nil {
+		// The config file could not be read
+		return loadErr
+	}
+	if err := cfg.Print(os.Stdout); err != nil {
+		return err
+	}
+
+	var invalid *config.ValidationError
+	if errors.As(loadErr, &invalid) {
+		for _, fieldErr := range invalid.Errors {
+			fmt.Fprintf(os.Stderr, "invalid: %v\n", fieldErr)
+		}
+		return fmt.Errorf("configuration has %d problem(s)", len(invalid.Errors))
+	}
+	return loadErr
+}
+
+// runMigrate handles the migrate subcommand
+func runMigrate(cfg *config.Config, logger *logrus.Logger, args []string) error {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	sink, err := store.New(cfg, logger)
+	if err != nil {
+		return err
+	}
+	defer sink.Close()
+
+	migrator, err := sink.Migrator()
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "up":
+		return migrator.Up()
+
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			steps, err = strconv.Atoi(args[1])
+			if err != nil || steps < 1 {
+				return fmt.Errorf("invalid number of steps %q", args[1])
+			}
+		}
+		return migrator.Down(steps)
+
+	case "status":
+		statuses, err := migrator.Status()
+		if err != nil {
+			return err
+		}
+
+		table := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(table, "VERSION\tNAME\tAPPLIED AT")
+		for _, status := range statuses {
+			appliedAt := "pending"
+			if status.Baseline {
+				appliedAt = "pending (baseline)"
+			}
+			if status.AppliedAt != nil {
+				appliedAt = status.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Fprintf(table, "%04d\t%s\t%s\n", status.Version, status.Name, appliedAt)
+		}
+		return table.Flush()
+
+	default:
+		return fmt.Errorf("unknown migrate command %q", args[0])
+	}
+}
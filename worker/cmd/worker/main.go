@@ -0,0 +1,121 @@
+// Command worker consumes votes from Redis and stores them in the database.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"worker/internal/config"
+	"worker/internal/httpserver"
+	"worker/internal/processor"
+	"worker/internal/tracing"
+)
+
+func main() {
+	configPath := flag.String("config", "", "YAML or TOML config file (default: $"+config.PathEnv+")")
+	flag.Usage = func() { fmt.Fprint(os.Stderr, usage) }
+	flag.Parse()
+	args := flag.Args()
+
+	cfg, err := config.Load(*configPath)
+	logger := processor.NewLogger()
+
+	if len(args) > 0 && args[0] == "config" {
+		if err := runConfig(cfg, err, args[1:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if err != nil {
+		logger.WithError(err).Fatal("Invalid configuration")
+	}
+
+	if len(args) > 0 {
+		switch args[0] {
+		case "migrate":
+			if err := runMigrate(cfg, logger, args[1:]); err != nil {
+				logger.WithError(err).Fatal("Migration failed")
+			}
+			return
+		default:
+			fmt.Fprintf(os.Stderr, "unknown command %q\n%s", args[0], usage)
+			os.Exit(2)
+		}
+	}
+
+	tracerProvider, err := tracing.New(context.Background(), cfg)
+	if err != nil {
+		logger.WithError(err).Fatal("Failed to set up tracing")
+	}
+
+	worker := processor.New(cfg, logger, processor.Dependencies{
+		LoadConfig:     func() (*config.Config, error) { return config.Load(*configPath) },
+		TracerProvider: tracerProvider,
+	})
+	// Serve the probes while the worker starts so that /startupz can report it
+	server := httpserver.New(cfg, worker, logger)
+	server.Start()
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+
+	// Start waits for Redis and the database; a shutdown signal meanwhile
+	// makes it give up
+	startErr := make(chan error, 1)
+	go func() { startErr <- worker.Start() }()
+	started, err := waitForStart(startErr, sigChan, logger)
+	if err != nil {
+		logger.WithError(err).Fatal("Worker failed to start")
+	}
+
+	if started {
+		// Reload the configuration on SIGHUP until an interrupt signal arrives
+		for sig := <-sigChan; sig == syscall.SIGHUP; sig = <-sigChan {
+			logger.Info("Reload signal received")
+			// Reload logs the outcome itself
+			worker.Reload()
+		}
+		logger.Info("Shutdown signal received")
+	}
+
+	// Drain the worker before the HTTP server goes away, so that the probes
+	// report it stopping meanwhile. Stop also makes a Start still waiting
+	// for dependencies give up.
+	worker.Stop()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	server.Shutdown(shutdownCtx)
+
+	// Export the spans of the last votes
+	if err := tracerProvider.Shutdown(shutdownCtx); err != nil {
+		logger.WithError(err).Warn("Failed to export remaining spans")
+	}
+}
+
+// waitForStart waits for the worker to start, ignoring SIGHUP meanwhile. It
+// reports false when an interrupt signal arrives first.
+func waitForStart(startErr <-chan error, sigChan <-chan os.Signal, logger *logrus.Logger) (bool, error) {
+	for {
+		select {
+		case err := <-startErr:
+			return err == nil, err
+		case sig := <-sigChan:
+			if sig == syscall.SIGHUP {
+				logger.Info("Reload signal ignored while starting")
+				continue
+			}
+			logger.Info("Shutdown signal received while starting")
+			return false, nil
+		}
+	}
+}
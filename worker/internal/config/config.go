@@ -0,0 +1,158 @@
+// Package config loads the worker configuration from environment variables
+// and an optional YAML or TOML file.
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// PathEnv names the environment variable holding the config file path
+const PathEnv = "WORKER_CONFIG"
+
+// Config is the worker configuration. The env tag names the environment
+// variable of each field; secret fields are redacted when printed and reload
+// fields may change while the worker runs.
+type Config struct {
+	RedisHost              string        `env:"REDIS_HOST"`
+	RedisPort              int           `env:"REDIS_PORT"`
+	RedisDB                int           `env:"REDIS_DB"`
+	RedisPassword          string        `env:"REDIS_PASSWORD,secret"`
+	VoteQueue              string        `env:"VOTE_QUEUE"`
+	QueueBackend           string        `env:"QUEUE_BACKEND"`
+	StreamGroup            string        `env:"STREAM_GROUP"`
+	StreamClaimIdle        time.Duration `env:"STREAM_CLAIM_IDLE"`
+	DeadLetterQueue        string        `env:"VOTE_DLQ"`
+	MaxRetries             int           `env:"MAX_RETRIES,reload"`
+	BatchSize              int           `env:"BATCH_SIZE,reload"`
+	BatchFlushInterval     time.Duration `env:"BATCH_FLUSH_INTERVAL,reload"`
+	Concurrency            int           `env:"WORKER_CONCURRENCY"`
+	VotePolicy             string        `env:"VOTE_POLICY"`
+	BallotSource           string        `env:"BALLOT_SOURCE,reload"`
+	BallotFile             string        `env:"BALLOT_FILE,reload"`
+	DefaultPoll            string        `env:"DEFAULT_POLL,reload"`
+	TallyRedisHash         string        `env:"TALLY_REDIS_HASH"`
+	TallyReconcileInterval time.Duration `env:"TALLY_RECONCILE_INTERVAL"`
+	EventsChannel          string        `env:"VOTE_EVENTS_CHANNEL"`
+	EventsInterval         time.Duration `env:"VOTE_EVENTS_INTERVAL"`
+	WorkerID               string        `env:"WORKER_ID"`
+	DBDriver               string        `env:"DB_DRIVER"`
+	MySQLHost              string        `env:"MYSQL_HOST"`
+	MySQLPort              int           `env:"MYSQL_PORT"`
+	MySQLUser              string        `env:"MYSQL_USER"`
+	MySQLPassword          string        `env:"MYSQL_PASSWORD,secret"`
+	MySQLDatabase          string        `env:"MYSQL_DATABASE"`
+	PostgresHost           string        `env:"POSTGRES_HOST"`
+	PostgresPort           int           `env:"POSTGRES_PORT"`
+	PostgresUser           string        `env:"POSTGRES_USER"`
+	PostgresPassword       string        `env:"POSTGRES_PASSWORD,secret"`
+	PostgresDatabase       string        `env:"POSTGRES_DATABASE"`
+	PostgresSSLMode        string        `env:"POSTGRES_SSLMODE"`
+	SQLitePath             string        `env:"SQLITE_PATH"`
+	Port                   int           `env:"PORT"`
+	Host                   string        `env:"HOST"`
+	AdminToken             string        `env:"ADMIN_TOKEN,secret"`
+	HealthCheckTimeout     time.Duration `env:"HEALTH_CHECK_TIMEOUT"`
+	HealthCheckInterval    time.Duration `env:"HEALTH_CHECK_INTERVAL"`
+	StallTimeout           time.Duration `env:"STALL_TIMEOUT"`
+	RetryInitialDelay      time.Duration `env:"RETRY_INITIAL_DELAY"`
+	RetryMaxDelay          time.Duration `env:"RETRY_MAX_DELAY"`
+	BreakerThreshold       int           `env:"BREAKER_FAILURE_THRESHOLD"`
+	BreakerCooldown        time.Duration `env:"BREAKER_COOLDOWN"`
+	StartupTimeout         time.Duration `env:"STARTUP_TIMEOUT"`
+	ShutdownTimeout        time.Duration `env:"SHUTDOWN_TIMEOUT"`
+	TracingExporter        string        `env:"TRACING_EXPORTER"`
+	TracingFile            string        `env:"TRACING_FILE"`
+	LogLevel               string        `env:"LOG_LEVEL,reload"`
+}
+
+// Load loads configuration and validates it. Settings come from environment
+// variables, then the optional YAML or TOML file at path (WORKER_CONFIG when
+// path is empty), then defaults. All problems are reported together in a
+// *ValidationError; the returned Config is usable for printing even when err
+// is not nil.
+func Load(path string) (*Config, error) {
+	if path == "" {
+		path = os.Getenv(PathEnv)
+	}
+
+	l, err := newLoader(path)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := &Config{
+		RedisHost:              l.string("REDIS_HOST", "localhost"),
+		RedisPort:              l.int("REDIS_PORT", 6379),
+		RedisDB:                l.int("REDIS_DB", 0),
+		RedisPassword:          l.secret("REDIS_PASSWORD", ""),
+		VoteQueue:              l.string("VOTE_QUEUE", "votes"),
+		QueueBackend:           l.string("QUEUE_BACKEND", "list"),
+		StreamGroup:            l.string("STREAM_GROUP", "workers"),
+		StreamClaimIdle:        l.duration("STREAM_CLAIM_IDLE", time.Minute),
+		DeadLetterQueue:        l.string("VOTE_DLQ", "votes:dlq"),
+		MaxRetries:             l.int("MAX_RETRIES", 5),
+		BatchSize:              l.int("BATCH_SIZE", 100),
+		BatchFlushInterval:     l.duration("BATCH_FLUSH_INTERVAL", time.Second),
+		Concurrency:            l.int("WORKER_CONCURRENCY", 1),
+		VotePolicy:             l.string("VOTE_POLICY", "append"),
+		BallotSource:           l.string("BALLOT_SOURCE", "none"),
+		BallotFile:             l.string("BALLOT_FILE", ""),
+		DefaultPoll:            l.string("DEFAULT_POLL", "default"),
+		TallyRedisHash:         l.string("TALLY_REDIS_HASH", ""),
+		TallyReconcileInterval: l.duration("TALLY_RECONCILE_INTERVAL", 5*time.Minute),
+		EventsChannel:          l.string("VOTE_EVENTS_CHANNEL", ""),
+		EventsInterval:         l.duration("VOTE_EVENTS_INTERVAL", 250*time.Millisecond),
+		WorkerID:               l.string("WORKER_ID", defaultWorkerID()),
+		DBDriver:               l.string("DB_DRIVER", "mysql"),
+		MySQLHost:              l.string("MYSQL_HOST", "localhost"),
+		MySQLPort:              l.int("MYSQL_PORT", 3306),
+		MySQLUser:              l.string("MYSQL_USER", "root"),
+		MySQLPassword:          l.secret("MYSQL_PASSWORD", ""),
+		MySQLDatabase:          l.string("MYSQL_DATABASE", "voting"),
+		PostgresHost:           l.string("POSTGRES_HOST", "localhost"),
+		PostgresPort:           l.int("POSTGRES_PORT", 5432),
+		PostgresUser:           l.string("POSTGRES_USER", "postgres"),
+		PostgresPassword:       l.secret("POSTGRES_PASSWORD", ""),
+		PostgresDatabase:       l.string("POSTGRES_DATABASE", "voting"),
+		PostgresSSLMode:        l.string("POSTGRES_SSLMODE", "disable"),
+		SQLitePath:             l.string("SQLITE_PATH", "voting.db"),
+		Port:                   l.int("PORT", 8080),
+		Host:                   l.string("HOST", "0.0.0.0"),
+		AdminToken:             l.secret("ADMIN_TOKEN", ""),
+		HealthCheckTimeout:     l.duration("HEALTH_CHECK_TIMEOUT", 2*time.Second),
+		HealthCheckInterval:    l.duration("HEALTH_CHECK_INTERVAL", 10*time.Second),
+		StallTimeout:           l.duration("STALL_TIMEOUT", time.Minute),
+		RetryInitialDelay:      l.duration("RETRY_INITIAL_DELAY", 100*time.Millisecond),
+		RetryMaxDelay:          l.duration("RETRY_MAX_DELAY", 30*time.Second),
+		BreakerThreshold:       l.int("BREAKER_FAILURE_THRESHOLD", 5),
+		BreakerCooldown:        l.duration("BREAKER_COOLDOWN", 30*time.Second),
+		StartupTimeout:         l.duration("STARTUP_TIMEOUT", 2*time.Minute),
+		ShutdownTimeout:        l.duration("SHUTDOWN_TIMEOUT", 20*time.Second),
+		TracingExporter:        l.string("TRACING_EXPORTER", "none"),
+		TracingFile:            l.string("TRACING_FILE", "traces.json"),
+		LogLevel:               l.string("LOG_LEVEL", "info"),
+	}
+
+	// Values that failed to parse keep their default, so validation only
+	// reports problems with values that were read
+	errs := l.errs
+	var invalid *ValidationError
+	if err := cfg.Validate(); errors.As(err, &invalid) {
+		errs = append(errs, invalid.Errors...)
+	}
+	if len(errs) > 0 {
+		return cfg, &ValidationError{Errors: errs}
+	}
+	return cfg, nil
+}
+
+// defaultWorkerID identifies the worker by hostname, which is the pod name in Kubernetes
+func defaultWorkerID() string {
+	if hostname, err := os.Hostname(); err == nil && hostname != "" {
+		return hostname
+	}
+	return fmt.Sprintf("worker-%d", os.Getpid())
+}
@@ -0,0 +1,149 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadDefaultsAreValid(t *testing.T) {
+	cfg, err := Load("")
+	require.NoError(t, err)
+	assert.Equal(t, 6379, cfg.RedisPort)
+	assert.Equal(t, "list", cfg.QueueBackend)
+}
+
+func TestLoadReportsEveryProblem(t *testing.T) {
+	t.Setenv("REDIS_PORT", "abc")
+	t.Setenv("PORT", "70000")
+	t.Setenv("BATCH_FLUSH_INTERVAL", "soon")
+	t.Setenv("VOTE_QUEUE", " ")
+	t.Setenv("QUEUE_BACKEND", "kafka")
+	t.Setenv("MYSQL_DATABASE", "voting; DROP TABLE votes")
+
+	cfg, err := Load("")
+	require.NotNil(t, cfg)
+
+	var invalid *ValidationError
+	require.True(t, errors.As(err, &invalid), "expected a *ValidationError, got %v", err)
+
+	keys := make([]string, 0, len(invalid.Errors))
+	for _, fieldErr := range invalid.Errors {
+		keys = append(keys, fieldErr.Key)
+	}
+	assert.ElementsMatch(t, []string{
+		"REDIS_PORT", "BATCH_FLUSH_INTERVAL", "VOTE_QUEUE", "QUEUE_BACKEND", "MYSQL_DATABASE", "PORT",
+	}, keys)
+
+	var fieldErr *FieldError
+	require.True(t, errors.As(err, &fieldErr))
+	assert.Equal(t, "REDIS_PORT", fieldErr.Key)
+	assert.Equal(t, "abc", fieldErr.Value)
+}
+
+func TestValidateChecksSelectedDriverOnly(t *testing.T) {
+	t.Setenv("DB_DRIVER", "sqlite")
+	t.Setenv("MYSQL_DATABASE", "not valid")
+	t.Setenv("POSTGRES_SSLMODE", "sometimes")
+
+	_, err := Load("")
+	assert.NoError(t, err)
+
+	t.Setenv("DB_DRIVER", "postgres")
+	_, err = Load("")
+	assert.ErrorContains(t, err, "POSTGRES_SSLMODE")
+	assert.NotContains(t, err.Error(), "MYSQL_DATABASE")
+}
+
+func TestPrintRedactsSecrets(t *testing.T) {
+	t.Setenv("MYSQL_PASSWORD", "hunter2")
+	t.Setenv("REDIS_HOST", "redis.internal")
+
+	cfg, err := Load("")
+	require.NoError(t, err)
+
+	var out bytes.Buffer
+	require.NoError(t, cfg.Print(&out))
+
+	assert.Contains(t, out.String(), "REDIS_HOST=redis.internal\n")
+	assert.Contains(t, out.String(), "MYSQL_PASSWORD=[REDACTED]\n")
+	assert.Contains(t, out.String(), "POSTGRES_PASSWORD=\n")
+	assert.NotContains(t, out.String(), "hunter2")
+	assert.Equal(t, "hunter2", cfg.MySQLPassword, "printing must not modify the configuration")
+}
+
+// writeFile writes a file in a temporary directory and returns its path
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func TestLoadConfigFileWithEnvironmentOverrides(t *testing.T) {
+	files := map[string]string{
+		"worker.yaml": "redis_host: redis\nredis_port: 6380\nbatch_flush_interval: 250ms\nvote_queue: ballots\n",
+		"worker.toml": "redis_host = \"redis\"\nredis_port = 6380\nbatch_flush_interval = \"250ms\"\nvote_queue = \"ballots\"\n",
+	}
+	for name, content := range files {
+		t.Run(name, func(t *testing.T) {
+			t.Setenv("VOTE_QUEUE", "votes:eu")
+
+			cfg, err := Load(writeFile(t, name, content))
+			require.NoError(t, err)
+			assert.Equal(t, "redis", cfg.RedisHost)
+			assert.Equal(t, 6380, cfg.RedisPort)
+			assert.Equal(t, 250*time.Millisecond, cfg.BatchFlushInterval)
+			assert.Equal(t, "votes:eu", cfg.VoteQueue, "environment variables override the file")
+			assert.Equal(t, "votes:dlq", cfg.DeadLetterQueue, "unset settings keep their default")
+		})
+	}
+}
+
+func TestLoadConfigFileFromEnvironment(t *testing.T) {
+	t.Setenv(PathEnv, writeFile(t, "worker.yml", "mysql_database: polls\n"))
+
+	cfg, err := Load("")
+	require.NoError(t, err)
+	assert.Equal(t, "polls", cfg.MySQLDatabase)
+}
+
+func TestLoadConfigFileReportsInvalidSettings(t *testing.T) {
+	path := writeFile(t, "worker.yaml", "redis_prot: 6380\nmysql_port: mysql\nredis: {host: redis}\n")
+
+	_, err := Load(path)
+
+	var invalid *ValidationError
+	require.True(t, errors.As(err, &invalid), "expected a *ValidationError, got %v", err)
+	require.Len(t, invalid.Errors, 3)
+	assert.ErrorContains(t, err, `redis_prot="" (from `+path+`): unknown setting`)
+	assert.ErrorContains(t, err, `MYSQL_PORT="mysql" (from `+path+`): must be an integer`)
+	assert.ErrorContains(t, err, `redis="" (from `+path+`): must be a single value`)
+
+	_, err = Load(writeFile(t, "worker.json", "{}"))
+	assert.ErrorContains(t, err, "must have a .yaml, .yml or .toml extension")
+}
+
+func TestLoadSecretFromFile(t *testing.T) {
+	t.Setenv("MYSQL_PASSWORD_FILE", writeFile(t, "password", "hunter2\n"))
+
+	cfg, err := Load("")
+	require.NoError(t, err)
+	assert.Equal(t, "hunter2", cfg.MySQLPassword)
+
+	t.Setenv("MYSQL_PASSWORD", "other")
+	_, err = Load("")
+	assert.ErrorContains(t, err, "MYSQL_PASSWORD_FILE")
+	assert.ErrorContains(t, err, "must not be set together with MYSQL_PASSWORD")
+
+	t.Setenv("MYSQL_PASSWORD", "")
+	t.Setenv("MYSQL_PASSWORD_FILE", filepath.Join(t.TempDir(), "missing"))
+	_, err = Load("")
+	assert.ErrorContains(t, err, "cannot be read")
+}
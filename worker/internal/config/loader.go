@@ -0,0 +1,153 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// sourceEnvironment is the source of settings read from environment variables
+const sourceEnvironment = "environment"
+
+// secretFileSuffix marks an environment variable holding the path of a file
+// with the value of a secret, such as MYSQL_PASSWORD_FILE
+const secretFileSuffix = "_FILE"
+
+// loader resolves each setting from the environment, then the config file,
+// then its default, recording values that fail to parse
+type loader struct {
+	path string
+	// file holds the config file settings keyed by environment variable
+	file map[string]string
+	errs []*FieldError
+}
+
+// newLoader reads the config file at path, if any
+func newLoader(path string) (*loader, error) {
+	l := &loader{path: path, file: map[string]string{}}
+	if path == "" {
+		return l, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	var raw map[string]interface{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &raw)
+	case ".toml":
+		err = toml.Unmarshal(data, &raw)
+	default:
+		return nil, fmt.Errorf("config file %s must have a .yaml, .yml or .toml extension", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+
+	known := make(map[string]bool)
+	for _, s := range (&Config{}).settings() {
+		known[s.Key] = true
+	}
+
+	// File keys are the environment variable names in lower case, e.g. redis_port
+	for name, value := range raw {
+		key := strings.ToUpper(name)
+		switch value.(type) {
+		case nil:
+			continue
+		case map[string]interface{}, []interface{}:
+			l.errs = append(l.errs, &FieldError{Key: name, Source: path, Reason: "must be a single value"})
+			continue
+		}
+		if !known[key] {
+			l.errs = append(l.errs, &FieldError{Key: name, Source: path, Reason: "unknown setting"})
+			continue
+		}
+		if text := fmt.Sprint(value); text != "" {
+			l.file[key] = text
+		}
+	}
+	return l, nil
+}
+
+// lookup returns the value of a setting and where it came from
+func (l *loader) lookup(key string) (value, source string, ok bool) {
+	if value := os.Getenv(key); value != "" {
+		return value, sourceEnvironment, true
+	}
+	if value, ok := l.file[key]; ok {
+		return value, l.path, true
+	}
+	return "", "", false
+}
+
+// string reads a text setting
+func (l *loader) string(key, defaultValue string) string {
+	if value, _, ok := l.lookup(key); ok {
+		return value
+	}
+	return defaultValue
+}
+
+// secret reads a text setting that may instead be given as the path of a
+// file in <key>_FILE, as mounted from a Kubernetes secret. Trailing newlines
+// of the file are ignored.
+func (l *loader) secret(key, defaultValue string) string {
+	fileKey := key + secretFileSuffix
+	path := os.Getenv(fileKey)
+	if path == "" {
+		return l.string(key, defaultValue)
+	}
+
+	if os.Getenv(key) != "" {
+		l.errs = append(l.errs, &FieldError{Key: fileKey, Value: path, Source: sourceEnvironment,
+			Reason: "must not be set together with " + key})
+		return defaultValue
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		l.errs = append(l.errs, &FieldError{Key: fileKey, Value: path, Source: sourceEnvironment,
+			Reason: "cannot be read: " + err.Error()})
+		return defaultValue
+	}
+	return strings.TrimRight(string(data), "\r\n")
+}
+
+// int reads an integer setting
+func (l *loader) int(key string, defaultValue int) int {
+	value, source, ok := l.lookup(key)
+	if !ok {
+		return defaultValue
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		l.errs = append(l.errs, &FieldError{Key: key, Value: value, Source: source, Reason: "must be an integer"})
+		return defaultValue
+	}
+	return parsed
+}
+
+// duration reads a duration setting such as 500ms or 1m
+func (l *loader) duration(key string, defaultValue time.Duration) time.Duration {
+	value, source, ok := l.lookup(key)
+	if !ok {
+		return defaultValue
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		l.errs = append(l.errs, &FieldError{Key: key, Value: value, Source: source,
+			Reason: "must be a duration such as 500ms or 1m"})
+		return defaultValue
+	}
+	return parsed
+}
@@ -0,0 +1,94 @@
+package config
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+)
+
+// redacted replaces the value of secret settings when printed
+const redacted = "[REDACTED]"
+
+// setting is one field of Config with its environment variable
+type setting struct {
+	Key        string
+	Secret     bool
+	Reloadable bool
+	Value      reflect.Value
+}
+
+// settings lists the fields of a Config in declaration order
+func (c *Config) settings() []setting {
+	value := reflect.ValueOf(c).Elem()
+	fields := value.Type()
+
+	settings := make([]setting, 0, fields.NumField())
+	for i := 0; i < fields.NumField(); i++ {
+		key, options, _ := strings.Cut(fields.Field(i).Tag.Get("env"), ",")
+		s := setting{Key: key, Value: value.Field(i)}
+		for _, option := range strings.Split(options, ",") {
+			switch option {
+			case "secret":
+				s.Secret = true
+			case "reload":
+				s.Reloadable = true
+			}
+		}
+		settings = append(settings, s)
+	}
+	return settings
+}
+
+// Redacted returns a copy of the configuration with secrets replaced, safe
+// to print or log. Empty secrets stay empty so that unset ones are visible.
+func (c *Config) Redacted() *Config {
+	copied := *c
+	for _, s := range copied.settings() {
+		if s.Secret && s.Value.String() != "" {
+			s.Value.SetString(redacted)
+		}
+	}
+	return &copied
+}
+
+// Print writes the configuration as KEY=value lines, one per environment
+// variable, with secrets redacted
+func (c *Config) Print(w io.Writer) error {
+	for _, s := range c.Redacted().settings() {
+		if _, err := fmt.Fprintf(w, "%s=%v\n", s.Key, s.Value.Interface()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Change is a setting that differs between two configurations
+type Change struct {
+	Key string `json:"key"`
+	Old string `json:"old"`
+	New string `json:"new"`
+	// Reloadable reports whether the setting may change while the worker runs
+	Reloadable bool `json:"reloadable"`
+}
+
+// Diff lists the settings that differ from c in next, in declaration order.
+// Secret values are redacted.
+func (c *Config) Diff(next *Config) []Change {
+	old, updated := c.settings(), next.settings()
+	oldRedacted, updatedRedacted := c.Redacted().settings(), next.Redacted().settings()
+
+	var changes []Change
+	for i, s := range old {
+		if s.Value.Interface() == updated[i].Value.Interface() {
+			continue
+		}
+		changes = append(changes, Change{
+			Key:        s.Key,
+			Old:        fmt.Sprint(oldRedacted[i].Value.Interface()),
+			New:        fmt.Sprint(updatedRedacted[i].Value.Interface()),
+			Reloadable: s.Reloadable,
+		})
+	}
+	return changes
+}
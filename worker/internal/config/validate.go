@@ -0,0 +1,203 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Values accepted for the enumerated settings. They mirror the constants of
+// the packages using them, which import this package.
+var (
+	queueBackends    = []string{"list", "stream"}
+	votePolicies     = []string{"append", "first-wins", "last-wins"}
+	ballotSources    = []string{"none", "file", "database"}
+	dbDrivers        = []string{"mysql", "postgres", "sqlite"}
+	postgresSSLModes = []string{"disable", "allow", "prefer", "require", "verify-ca", "verify-full"}
+	logLevels        = []string{"trace", "debug", "info", "warn", "error"}
+	tracingExporters = []string{"none", "otlp", "stdout", "file"}
+)
+
+var (
+	// mysqlDatabaseName matches unquoted MySQL identifiers
+	mysqlDatabaseName = regexp.MustCompile(`^[A-Za-z0-9_$]{1,64}$`)
+	// postgresDatabaseName matches unquoted PostgreSQL identifiers
+	postgresDatabaseName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_$]{0,62}$`)
+)
+
+// maxPollIDLength is the longest poll_id a vote may carry
+const maxPollIDLength = 64
+
+// FieldError describes one invalid setting
+type FieldError struct {
+	// Key is the environment variable holding the setting, or the config
+	// file key when the key itself is invalid
+	Key string
+	// Value is the rejected value, empty for secrets
+	Value string
+	// Source is "environment" or the config file path the value was read
+	// from, empty for problems found in the effective configuration
+	Source string
+	Reason string
+}
+
+func (e *FieldError) Error() string {
+	if e.Source != "" {
+		return fmt.Sprintf("%s=%q (from %s): %s", e.Key, e.Value, e.Source, e.Reason)
+	}
+	return fmt.Sprintf("%s=%q: %s", e.Key, e.Value, e.Reason)
+}
+
+// ValidationError aggregates every invalid setting of a configuration
+type ValidationError struct {
+	Errors []*FieldError
+}
+
+func (e *ValidationError) Error() string {
+	problems := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		problems[i] = err.Error()
+	}
+	return "invalid configuration: " + strings.Join(problems, "; ")
+}
+
+// Unwrap exposes the individual field errors to errors.Is and errors.As
+func (e *ValidationError) Unwrap() []error {
+	errs := make([]error, len(e.Errors))
+	for i, err := range e.Errors {
+		errs[i] = err
+	}
+	return errs
+}
+
+// Validate checks every setting, returning a *ValidationError listing all
+// problems. Database settings are only checked for the selected driver.
+func (c *Config) Validate() error {
+	v := &validator{}
+
+	v.notEmpty("REDIS_HOST", c.RedisHost)
+	v.port("REDIS_PORT", c.RedisPort)
+	v.atLeast("REDIS_DB", c.RedisDB, 0)
+
+	v.notEmpty("VOTE_QUEUE", c.VoteQueue)
+	v.oneOf("QUEUE_BACKEND", c.QueueBackend, queueBackends)
+	if c.QueueBackend == "stream" {
+		v.notEmpty("STREAM_GROUP", c.StreamGroup)
+		v.positive("STREAM_CLAIM_IDLE", c.StreamClaimIdle.String(), c.StreamClaimIdle > 0)
+	}
+	v.notEmpty("VOTE_DLQ", c.DeadLetterQueue)
+	if c.DeadLetterQueue != "" && c.DeadLetterQueue == c.VoteQueue {
+		v.add("VOTE_DLQ", c.DeadLetterQueue, "must differ from VOTE_QUEUE")
+	}
+	v.atLeast("MAX_RETRIES", c.MaxRetries, 0)
+
+	v.atLeast("BATCH_SIZE", c.BatchSize, 1)
+	v.positive("BATCH_FLUSH_INTERVAL", c.BatchFlushInterval.String(), c.BatchFlushInterval > 0)
+	v.atLeast("WORKER_CONCURRENCY", c.Concurrency, 1)
+	v.notEmpty("WORKER_ID", c.WorkerID)
+
+	v.oneOf("VOTE_POLICY", c.VotePolicy, votePolicies)
+	v.oneOf("BALLOT_SOURCE", c.BallotSource, ballotSources)
+	if c.BallotSource == "file" {
+		v.notEmpty("BALLOT_FILE", c.BallotFile)
+	}
+	v.notEmpty("DEFAULT_POLL", c.DefaultPoll)
+	if len(c.DefaultPoll) > maxPollIDLength {
+		v.add("DEFAULT_POLL", c.DefaultPoll, fmt.Sprintf("must be at most %d characters", maxPollIDLength))
+	}
+
+	if c.TallyReconcileInterval < 0 {
+		v.add("TALLY_RECONCILE_INTERVAL", c.TallyReconcileInterval.String(), "must not be negative")
+	}
+	v.positive("VOTE_EVENTS_INTERVAL", c.EventsInterval.String(), c.EventsInterval > 0)
+
+	v.oneOf("DB_DRIVER", c.DBDriver, dbDrivers)
+	switch c.DBDriver {
+	case "mysql":
+		v.notEmpty("MYSQL_HOST", c.MySQLHost)
+		v.port("MYSQL_PORT", c.MySQLPort)
+		v.notEmpty("MYSQL_USER", c.MySQLUser)
+		v.matches("MYSQL_DATABASE", c.MySQLDatabase, mysqlDatabaseName,
+			"must be 1-64 letters, digits, '_' or '$'")
+	case "postgres":
+		v.notEmpty("POSTGRES_HOST", c.PostgresHost)
+		v.port("POSTGRES_PORT", c.PostgresPort)
+		v.notEmpty("POSTGRES_USER", c.PostgresUser)
+		v.matches("POSTGRES_DATABASE", c.PostgresDatabase, postgresDatabaseName,
+			"must be 1-63 letters, digits, '_' or '$', starting with a letter or '_'")
+		v.oneOf("POSTGRES_SSLMODE", c.PostgresSSLMode, postgresSSLModes)
+	case "sqlite":
+		v.notEmpty("SQLITE_PATH", c.SQLitePath)
+	}
+
+	v.port("PORT", c.Port)
+	v.positive("HEALTH_CHECK_TIMEOUT", c.HealthCheckTimeout.String(), c.HealthCheckTimeout > 0)
+	v.positive("HEALTH_CHECK_INTERVAL", c.HealthCheckInterval.String(), c.HealthCheckInterval > 0)
+	v.positive("STALL_TIMEOUT", c.StallTimeout.String(), c.StallTimeout > 0)
+	v.positive("RETRY_INITIAL_DELAY", c.RetryInitialDelay.String(), c.RetryInitialDelay > 0)
+	if c.RetryMaxDelay < c.RetryInitialDelay {
+		v.add("RETRY_MAX_DELAY", c.RetryMaxDelay.String(), "must not be less than RETRY_INITIAL_DELAY")
+	}
+	v.atLeast("BREAKER_FAILURE_THRESHOLD", c.BreakerThreshold, 1)
+	v.positive("BREAKER_COOLDOWN", c.BreakerCooldown.String(), c.BreakerCooldown > 0)
+	v.positive("STARTUP_TIMEOUT", c.StartupTimeout.String(), c.StartupTimeout > 0)
+	v.positive("SHUTDOWN_TIMEOUT", c.ShutdownTimeout.String(), c.ShutdownTimeout > 0)
+	v.oneOf("TRACING_EXPORTER", c.TracingExporter, tracingExporters)
+	if c.TracingExporter == "file" {
+		v.notEmpty("TRACING_FILE", c.TracingFile)
+	}
+	v.oneOf("LOG_LEVEL", c.LogLevel, logLevels)
+
+	if len(v.errs) > 0 {
+		return &ValidationError{Errors: v.errs}
+	}
+	return nil
+}
+
+// validator collects field errors
+type validator struct {
+	errs []*FieldError
+}
+
+func (v *validator) add(key, value, reason string) {
+	v.errs = append(v.errs, &FieldError{Key: key, Value: value, Reason: reason})
+}
+
+func (v *validator) notEmpty(key, value string) {
+	if strings.TrimSpace(value) == "" {
+		v.add(key, value, "must not be empty")
+	}
+}
+
+func (v *validator) port(key string, value int) {
+	if value < 1 || value > 65535 {
+		v.add(key, fmt.Sprint(value), "must be a port between 1 and 65535")
+	}
+}
+
+func (v *validator) atLeast(key string, value, minimum int) {
+	if value < minimum {
+		v.add(key, fmt.Sprint(value), fmt.Sprintf("must be at least %d", minimum))
+	}
+}
+
+func (v *validator) positive(key, value string, ok bool) {
+	if !ok {
+		v.add(key, value, "must be positive")
+	}
+}
+
+func (v *validator) oneOf(key, value string, allowed []string) {
+	for _, candidate := range allowed {
+		if value == candidate {
+			return
+		}
+	}
+	v.add(key, value, "must be one of "+strings.Join(allowed, ", "))
+}
+
+func (v *validator) matches(key, value string, pattern *regexp.Regexp, reason string) {
+	if !pattern.MatchString(value) {
+		v.add(key, value, reason)
+	}
+}
@@ -0,0 +1,154 @@
+package httpserver
+
+import (
+	"errors"
+	"net/http"
+
+	"worker/internal/config"
+	"worker/internal/processor"
+	"worker/internal/store"
+)
+
+// dlqList handles GET /admin/dlq, returning the newest dead letters
+func (s *Server) dlqList(writer http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodGet {
+		writeJSON(writer, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+
+	limit, err := queryInt(request, "limit", 100)
+	if err != nil || limit < 1 {
+		writeJSON(writer, http.StatusBadRequest, map[string]string{"error": "invalid limit"})
+		return
+	}
+
+	entries, size, err := s.worker.DeadLetters(request.Context(), limit)
+	if err != nil {
+		writeJSON(writer, http.StatusServiceUnavailable, map[string]string{"error": err.Error()})
+		return
+	}
+
+	writeJSON(writer, http.StatusOK, map[string]interface{}{
+		"size":    size,
+		"entries": entries,
+	})
+}
+
+// dlqReplay handles POST /admin/dlq/replay, moving the oldest dead letters back onto the queue
+func (s *Server) dlqReplay(writer http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodPost {
+		writeJSON(writer, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+
+	count, err := queryInt(request, "count", 0)
+	if err != nil || count < 0 {
+		writeJSON(writer, http.StatusBadRequest, map[string]string{"error": "invalid count"})
+		return
+	}
+
+	replayed, err := s.worker.ReplayDeadLetters(request.Context(), count)
+	if err != nil {
+		writeJSON(writer, http.StatusServiceUnavailable, map[string]interface{}{
+			"error":    err.Error(),
+			"replayed": replayed,
+		})
+		return
+	}
+
+	writeJSON(writer, http.StatusOK, map[string]int{"replayed": replayed})
+}
+
+// dlqPurge handles POST /admin/dlq/purge, discarding all dead letters
+func (s *Server) dlqPurge(writer http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodPost && request.Method != http.MethodDelete {
+		writeJSON(writer, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+
+	purged, err := s.worker.PurgeDeadLetters(request.Context())
+	if err != nil {
+		writeJSON(writer, http.StatusServiceUnavailable, map[string]string{"error": err.Error()})
+		return
+	}
+
+	writeJSON(writer, http.StatusOK, map[string]int64{"purged": purged})
+}
+
+// pollsList handles GET /admin/polls, listing known polls and their state
+func (s *Server) pollsList(writer http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodGet {
+		writeJSON(writer, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+
+	states, err := s.worker.Polls()
+	if err != nil {
+		writeJSON(writer, http.StatusServiceUnavailable, map[string]string{"error": err.Error()})
+		return
+	}
+
+	writeJSON(writer, http.StatusOK, map[string]interface{}{"polls": states})
+}
+
+// pollsOpen handles POST /admin/polls/open?poll_id=ID
+func (s *Server) pollsOpen(writer http.ResponseWriter, request *http.Request) {
+	s.changePollStatus(writer, request, store.PollOpen)
+}
+
+// pollsClose handles POST /admin/polls/close?poll_id=ID
+func (s *Server) pollsClose(writer http.ResponseWriter, request *http.Request) {
+	s.changePollStatus(writer, request, store.PollClosed)
+}
+
+func (s *Server) changePollStatus(writer http.ResponseWriter, request *http.Request, status string) {
+	if request.Method != http.MethodPost {
+		writeJSON(writer, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+
+	pollID := request.URL.Query().Get("poll_id")
+	if pollID == "" || len(pollID) > 64 {
+		writeJSON(writer, http.StatusBadRequest, map[string]string{"error": "invalid poll_id"})
+		return
+	}
+
+	err := s.worker.SetPollStatus(pollID, status)
+	if errors.Is(err, processor.ErrUnknownPoll) {
+		writeJSON(writer, http.StatusNotFound, map[string]string{"error": err.Error()})
+		return
+	} else if err != nil {
+		writeJSON(writer, http.StatusServiceUnavailable, map[string]string{"error": err.Error()})
+		return
+	}
+
+	writeJSON(writer, http.StatusOK, store.PollState{PollID: pollID, Status: status})
+}
+
+// reload handles POST /admin/reload, re-reading the configuration like SIGHUP
+func (s *Server) reload(writer http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodPost {
+		writeJSON(writer, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+
+	changes, err := s.worker.Reload()
+	if changes == nil {
+		changes = []config.Change{}
+	}
+
+	var invalid *config.ValidationError
+	switch {
+	case err == nil:
+		writeJSON(writer, http.StatusOK, map[string]interface{}{"changes": changes})
+	case errors.Is(err, processor.ErrReloadRejected):
+		writeJSON(writer, http.StatusConflict, map[string]interface{}{
+			"error":   err.Error(),
+			"changes": changes,
+		})
+	case errors.As(err, &invalid):
+		writeJSON(writer, http.StatusBadRequest, map[string]string{"error": err.Error()})
+	default:
+		writeJSON(writer, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+}
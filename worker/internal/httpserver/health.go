@@ -0,0 +1,93 @@
+package httpserver
+
+import (
+	"net/http"
+	"time"
+
+	"worker/internal/processor"
+)
+
+// healthResponse is the detailed report served on /health
+type healthResponse struct {
+	Status    string `json:"status"`
+	Service   string `json:"service"`
+	Timestamp string `json:"timestamp"`
+	processor.HealthReport
+}
+
+// healthCheck handles /health with the detailed report of the health
+// monitor, including connection pool statistics
+func (s *Server) healthCheck(writer http.ResponseWriter, request *http.Request) {
+	report := s.worker.Health()
+	response := healthResponse{
+		Status:       s.healthStatus(report.Healthy),
+		Service:      "worker",
+		Timestamp:    time.Now().Format(time.RFC3339),
+		HealthReport: report,
+	}
+	writeJSON(writer, healthCode(report.Healthy), response)
+}
+
+// readyz handles /readyz: the worker is running and every dependency passed
+// its last check. Results come from the health monitor, so the probe never
+// waits on a dependency.
+func (s *Server) readyz(writer http.ResponseWriter, request *http.Request) {
+	report := s.worker.Health()
+	health := map[string]interface{}{
+		"service":   "worker",
+		"state":     report.State,
+		"status":    s.healthStatus(report.Healthy),
+		"timestamp": time.Now().Format(time.RFC3339),
+	}
+
+	for name, status := range report.Dependencies {
+		if status.Healthy {
+			health[name] = "connected"
+		} else {
+			health[name] = "disconnected"
+			health[name+"_error"] = status.Error
+		}
+	}
+	writeJSON(writer, healthCode(report.Healthy), health)
+}
+
+// healthStatus names the overall status and counts the check
+func (s *Server) healthStatus(healthy bool) string {
+	status := "unhealthy"
+	if healthy {
+		status = "healthy"
+	}
+	s.worker.Metrics().HealthChecks.WithLabelValues(status).Inc()
+	return status
+}
+
+// healthCode is the HTTP status of a health response
+func healthCode(healthy bool) int {
+	if healthy {
+		return http.StatusOK
+	}
+	return http.StatusServiceUnavailable
+}
+
+// livez handles /livez: the process is up and no consumer is stalled. It
+// does not depend on Redis or the database, since restarting the worker
+// does not help while they are down.
+func (s *Server) livez(writer http.ResponseWriter, request *http.Request) {
+	if err := s.worker.Liveness(); err != nil {
+		writeJSON(writer, http.StatusServiceUnavailable, map[string]string{
+			"status": "stalled",
+			"error":  err.Error(),
+		})
+		return
+	}
+	writeJSON(writer, http.StatusOK, map[string]string{"status": "alive"})
+}
+
+// startupz handles /startupz: the worker has finished starting
+func (s *Server) startupz(writer http.ResponseWriter, request *http.Request) {
+	if state := s.worker.State(); state == processor.StateStarting {
+		writeJSON(writer, http.StatusServiceUnavailable, map[string]string{"status": state})
+		return
+	}
+	writeJSON(writer, http.StatusOK, map[string]string{"status": "started"})
+}
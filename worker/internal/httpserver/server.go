@@ -0,0 +1,118 @@
+// Package httpserver serves the worker's health check, metrics and admin endpoints.
+package httpserver
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+
+	"worker/internal/config"
+	"worker/internal/processor"
+)
+
+// Server is the HTTP server of a worker
+type Server struct {
+	worker     *processor.Worker
+	logger     *logrus.Logger
+	server     *http.Server
+	adminToken string
+}
+
+// New creates the HTTP server listening on HOST:PORT
+func New(cfg *config.Config, worker *processor.Worker, logger *logrus.Logger) *Server {
+	s := &Server{worker: worker, logger: logger, adminToken: cfg.AdminToken}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/health", s.healthCheck)
+	mux.HandleFunc("/livez", s.livez)
+	mux.HandleFunc("/readyz", s.readyz)
+	mux.HandleFunc("/startupz", s.startupz)
+	mux.Handle("/metrics", worker.Metrics().Handler())
+	mux.HandleFunc("/admin/dlq", s.admin(s.dlqList))
+	mux.HandleFunc("/admin/dlq/replay", s.admin(s.dlqReplay))
+	mux.HandleFunc("/admin/dlq/purge", s.admin(s.dlqPurge))
+	mux.HandleFunc("/admin/polls", s.admin(s.pollsList))
+	mux.HandleFunc("/admin/polls/open", s.admin(s.pollsOpen))
+	mux.HandleFunc("/admin/polls/close", s.admin(s.pollsClose))
+	mux.HandleFunc("/admin/reload", s.admin(s.reload))
+
+	s.server = &http.Server{
+		Addr:    fmt.Sprintf("%s:%d", cfg.Host, cfg.Port),
+		Handler: mux,
+	}
+	return s
+}
+
+// Handler returns the handler serving every endpoint
+func (s *Server) Handler() http.Handler {
+	return s.server.Handler
+}
+
+// Start serves HTTP in the background
+func (s *Server) Start() {
+	go func() {
+		s.logger.Infof("Starting HTTP server on %s", s.server.Addr)
+		if err := s.server.ListenAndServe(); err != http.ErrServerClosed {
+			s.logger.WithError(err).Fatal("HTTP server failed")
+		}
+	}()
+}
+
+// Shutdown stops the server, waiting for active requests until ctx is done
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.server.Shutdown(ctx)
+}
+
+// admin guards an admin endpoint: the request must carry ADMIN_TOKEN as a
+// bearer token and the worker must be running. Admin endpoints are disabled
+// when no token is configured.
+func (s *Server) admin(handler http.HandlerFunc) http.HandlerFunc {
+	running := s.whenRunning(handler)
+	return func(writer http.ResponseWriter, request *http.Request) {
+		if s.adminToken == "" {
+			writeJSON(writer, http.StatusForbidden, map[string]string{"error": "admin endpoints are disabled; set ADMIN_TOKEN to enable them"})
+			return
+		}
+		token, ok := strings.CutPrefix(request.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(s.adminToken)) != 1 {
+			writer.Header().Set("WWW-Authenticate", "Bearer")
+			writeJSON(writer, http.StatusUnauthorized, map[string]string{"error": "invalid admin token"})
+			return
+		}
+		running(writer, request)
+	}
+}
+
+// whenRunning rejects admin requests until the worker has started, since
+// its queue and database are not connected before then
+func (s *Server) whenRunning(handler http.HandlerFunc) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		if state := s.worker.State(); state != processor.StateRunning {
+			writeJSON(writer, http.StatusServiceUnavailable, map[string]string{"error": "worker is " + state})
+			return
+		}
+		handler(writer, request)
+	}
+}
+
+// queryInt reads an integer query parameter with a default
+func queryInt(request *http.Request, name string, defaultValue int) (int, error) {
+	value := request.URL.Query().Get(name)
+	if value == "" {
+		return defaultValue, nil
+	}
+	return strconv.Atoi(value)
+}
+
+// writeJSON writes a JSON response with the given status code
+func writeJSON(writer http.ResponseWriter, status int, body interface{}) {
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(status)
+	json.NewEncoder(writer).Encode(body)
+}
@@ -0,0 +1,270 @@
+package httpserver
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"worker/internal/config"
+	"worker/internal/processor"
+	"worker/internal/queue"
+	"worker/internal/store"
+)
+
+// testAdminToken is the admin token of test servers; serve sends it with every request
+const testAdminToken = "test-token"
+
+// newTestServer starts a worker on an in-memory queue and a SQLite database
+// and returns the handler serving its endpoints
+func newTestServer(t *testing.T) (http.Handler, *queue.Memory) {
+	t.Helper()
+
+	cfg := &config.Config{
+		VoteQueue:           "votes",
+		DeadLetterQueue:     "votes:dlq",
+		MaxRetries:          3,
+		BatchSize:           10,
+		BatchFlushInterval:  10 * time.Millisecond,
+		Concurrency:         1,
+		VotePolicy:          store.PolicyAppend,
+		DefaultPoll:         "default",
+		DBDriver:            store.DriverSQLite,
+		SQLitePath:          filepath.Join(t.TempDir(), "voting.db"),
+		HealthCheckTimeout:  time.Second,
+		HealthCheckInterval: time.Second,
+		StallTimeout:        time.Minute,
+		RetryInitialDelay:   10 * time.Millisecond,
+		RetryMaxDelay:       100 * time.Millisecond,
+		BreakerThreshold:    2,
+		BreakerCooldown:     50 * time.Millisecond,
+		StartupTimeout:      time.Second,
+		ShutdownTimeout:     time.Second,
+		AdminToken:          testAdminToken,
+	}
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	sink, err := store.NewSQLiteSink(cfg, logger)
+	require.NoError(t, err)
+	source := queue.NewMemory()
+
+	// Reloads re-read the same configuration unless a test changes it
+	loadConfig := func() (*config.Config, error) {
+		next := *cfg
+		return &next, nil
+	}
+
+	worker := processor.New(cfg, logger, processor.Dependencies{Queue: source, Sink: sink, LoadConfig: loadConfig})
+	require.NoError(t, worker.Start())
+	t.Cleanup(worker.Stop)
+
+	return New(cfg, worker, logger).Handler(), source
+}
+
+// serve sends a request with the test admin token to the handler
+func serve(handler http.Handler, method, target string) *httptest.ResponseRecorder {
+	return serveWithToken(handler, method, target, testAdminToken)
+}
+
+// serveWithToken sends a request with the given bearer token, or none when
+// token is empty, to the handler
+func serveWithToken(handler http.Handler, method, target, token string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(method, target, nil)
+	if token != "" {
+		request.Header.Set("Authorization", "Bearer "+token)
+	}
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+	return recorder
+}
+
+func TestHealthCheck(t *testing.T) {
+	handler, _ := newTestServer(t)
+
+	recorder := serve(handler, http.MethodGet, "/health")
+	require.Equal(t, http.StatusOK, recorder.Code)
+
+	var health healthResponse
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &health))
+	assert.Equal(t, "healthy", health.Status)
+	assert.Equal(t, processor.StateRunning, health.State)
+	require.Contains(t, health.Dependencies, "database")
+	assert.True(t, health.Dependencies["database"].Healthy)
+	assert.Zero(t, health.Dependencies["database"].ConsecutiveFailures)
+	assert.NotNil(t, health.Dependencies["database"].LastSuccess)
+	assert.NotContains(t, health.Dependencies, "redis", "Redis is not used with an in-memory queue")
+	require.NotNil(t, health.DatabasePool)
+	assert.Positive(t, health.DatabasePool.MaxOpenConnections)
+	assert.Nil(t, health.RedisPool)
+
+	recorder = serve(handler, http.MethodGet, "/readyz")
+	require.Equal(t, http.StatusOK, recorder.Code)
+
+	var ready map[string]interface{}
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &ready))
+	assert.Equal(t, "healthy", ready["status"])
+	assert.Equal(t, "connected", ready["database"])
+}
+
+func TestMetricsEndpoint(t *testing.T) {
+	handler, _ := newTestServer(t)
+
+	recorder := serve(handler, http.MethodGet, "/metrics")
+	require.Equal(t, http.StatusOK, recorder.Code)
+	for _, name := range []string{"votes_in_flight", "db_pool_max_open_connections", "go_goroutines", "process_cpu_seconds_total"} {
+		assert.Contains(t, recorder.Body.String(), name)
+	}
+}
+
+func TestDeadLetterAdminEndpoints(t *testing.T) {
+	handler, source := newTestServer(t)
+
+	source.Push(`not json`, `{"vote": "cats"}`)
+	require.Eventually(t, func() bool {
+		count, err := source.DeadLetterCount(context.Background())
+		return err == nil && count == 2
+	}, 5*time.Second, 10*time.Millisecond)
+
+	recorder := serve(handler, http.MethodGet, "/admin/dlq?limit=1")
+	require.Equal(t, http.StatusOK, recorder.Code)
+
+	var list struct {
+		Size    int64              `json:"size"`
+		Entries []queue.DeadLetter `json:"entries"`
+	}
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &list))
+	assert.Equal(t, int64(2), list.Size)
+	require.Len(t, list.Entries, 1)
+	assert.Equal(t, `{"vote": "cats"}`, list.Entries[0].Payload)
+
+	// Replay moves the oldest entry back onto the queue, where the running
+	// worker dead-letters it again
+	recorder = serve(handler, http.MethodPost, "/admin/dlq/replay?count=1")
+	require.Equal(t, http.StatusOK, recorder.Code)
+	assert.JSONEq(t, `{"replayed": 1}`, recorder.Body.String())
+	require.Eventually(t, func() bool {
+		count, err := source.DeadLetterCount(context.Background())
+		return err == nil && count == 2 && source.Len() == 0 && source.InFlight() == 0
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// Replaying everything stops after the entries present when it started,
+	// even though the worker keeps dead-lettering them again
+	recorder = serve(handler, http.MethodPost, "/admin/dlq/replay")
+	require.Equal(t, http.StatusOK, recorder.Code)
+	assert.JSONEq(t, `{"replayed": 2}`, recorder.Body.String())
+	require.Eventually(t, func() bool {
+		count, err := source.DeadLetterCount(context.Background())
+		return err == nil && count == 2 && source.Len() == 0 && source.InFlight() == 0
+	}, 5*time.Second, 10*time.Millisecond)
+
+	recorder = serve(handler, http.MethodPost, "/admin/dlq/purge")
+	require.Equal(t, http.StatusOK, recorder.Code)
+	assert.JSONEq(t, `{"purged": 2}`, recorder.Body.String())
+
+	count, err := source.DeadLetterCount(context.Background())
+	require.NoError(t, err)
+	assert.Zero(t, count)
+}
+
+func TestPollAdminEndpoints(t *testing.T) {
+	handler, _ := newTestServer(t)
+
+	recorder := serve(handler, http.MethodPost, "/admin/polls/close?poll_id=pets")
+	require.Equal(t, http.StatusOK, recorder.Code)
+	assert.JSONEq(t, `{"poll_id": "pets", "status": "closed"}`, recorder.Body.String())
+
+	recorder = serve(handler, http.MethodGet, "/admin/polls")
+	require.Equal(t, http.StatusOK, recorder.Code)
+
+	var list struct {
+		Polls []store.PollState `json:"polls"`
+	}
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &list))
+	require.Len(t, list.Polls, 1)
+	assert.Equal(t, "pets", list.Polls[0].PollID)
+	assert.Equal(t, store.PollClosed, list.Polls[0].Status)
+	assert.NotNil(t, list.Polls[0].ClosedAt)
+
+	recorder = serve(handler, http.MethodGet, "/admin/polls/open?poll_id=pets")
+	assert.Equal(t, http.StatusMethodNotAllowed, recorder.Code)
+}
+
+func TestReloadEndpoint(t *testing.T) {
+	handler, _ := newTestServer(t)
+
+	recorder := serve(handler, http.MethodGet, "/admin/reload")
+	assert.Equal(t, http.StatusMethodNotAllowed, recorder.Code)
+
+	recorder = serve(handler, http.MethodPost, "/admin/reload")
+	require.Equal(t, http.StatusOK, recorder.Code)
+
+	var body struct {
+		Changes []config.Change `json:"changes"`
+	}
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &body))
+	assert.NotNil(t, body.Changes)
+	assert.Empty(t, body.Changes)
+}
+
+func TestAdminEndpointsRequireToken(t *testing.T) {
+	handler, _ := newTestServer(t)
+
+	for _, token := range []string{"", "wrong-token"} {
+		recorder := serveWithToken(handler, http.MethodPost, "/admin/dlq/purge", token)
+		assert.Equal(t, http.StatusUnauthorized, recorder.Code)
+		assert.Equal(t, "Bearer", recorder.Header().Get("WWW-Authenticate"))
+	}
+
+	// Probes and metrics stay open to the kubelet and Prometheus
+	assert.Equal(t, http.StatusOK, serveWithToken(handler, http.MethodGet, "/livez", "").Code)
+	assert.Equal(t, http.StatusOK, serveWithToken(handler, http.MethodGet, "/metrics", "").Code)
+}
+
+func TestAdminEndpointsDisabledWithoutToken(t *testing.T) {
+	cfg := &config.Config{HealthCheckTimeout: time.Second, StallTimeout: time.Minute}
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	worker := processor.New(cfg, logger, processor.Dependencies{})
+	handler := New(cfg, worker, logger).Handler()
+
+	recorder := serveWithToken(handler, http.MethodPost, "/admin/reload", "")
+	assert.Equal(t, http.StatusForbidden, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), "ADMIN_TOKEN")
+}
+
+func TestProbeEndpoints(t *testing.T) {
+	handler, _ := newTestServer(t)
+
+	for _, path := range []string{"/livez", "/readyz", "/startupz"} {
+		recorder := serve(handler, http.MethodGet, path)
+		assert.Equal(t, http.StatusOK, recorder.Code, path)
+	}
+}
+
+func TestProbeEndpointsWhileStarting(t *testing.T) {
+	cfg := &config.Config{HealthCheckTimeout: time.Second, StallTimeout: time.Minute, AdminToken: testAdminToken}
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	worker := processor.New(cfg, logger, processor.Dependencies{})
+	handler := New(cfg, worker, logger).Handler()
+
+	assert.Equal(t, http.StatusOK, serve(handler, http.MethodGet, "/livez").Code)
+	assert.Equal(t, http.StatusServiceUnavailable, serve(handler, http.MethodGet, "/startupz").Code)
+	assert.Equal(t, http.StatusServiceUnavailable, serve(handler, http.MethodGet, "/readyz").Code)
+
+	recorder := serve(handler, http.MethodGet, "/admin/dlq")
+	assert.Equal(t, http.StatusServiceUnavailable, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), "worker is starting")
+}
@@ -0,0 +1,61 @@
+package metrics
+
+import (
+	"database/sql"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// dbStatsCollector exports the statistics of a database connection pool,
+// read from sql.DBStats on every scrape
+type dbStatsCollector struct {
+	stats func() sql.DBStats
+
+	maxOpen      *prometheus.Desc
+	connections  *prometheus.Desc
+	waitCount    *prometheus.Desc
+	waitDuration *prometheus.Desc
+	closed       *prometheus.Desc
+}
+
+// NewDBStatsCollector creates a collector for the pool whose statistics
+// stats returns
+func NewDBStatsCollector(stats func() sql.DBStats) prometheus.Collector {
+	return &dbStatsCollector{
+		stats: stats,
+		maxOpen: prometheus.NewDesc("db_pool_max_open_connections",
+			"Maximum number of open database connections", nil, nil),
+		connections: prometheus.NewDesc("db_pool_connections",
+			"Database pool connections by state", []string{"state"}, nil),
+		waitCount: prometheus.NewDesc("db_pool_wait_count_total",
+			"Total number of database connections waited for", nil, nil),
+		waitDuration: prometheus.NewDesc("db_pool_wait_duration_seconds_total",
+			"Total time spent waiting for a database connection", nil, nil),
+		closed: prometheus.NewDesc("db_pool_closed_connections_total",
+			"Total number of database connections closed by the pool limits", []string{"reason"}, nil),
+	}
+}
+
+// Describe implements prometheus.Collector
+func (c *dbStatsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.maxOpen
+	ch <- c.connections
+	ch <- c.waitCount
+	ch <- c.waitDuration
+	ch <- c.closed
+}
+
+// Collect implements prometheus.Collector
+func (c *dbStatsCollector) Collect(ch chan<- prometheus.Metric) {
+	stats := c.stats()
+
+	ch <- prometheus.MustNewConstMetric(c.maxOpen, prometheus.GaugeValue, float64(stats.MaxOpenConnections))
+	ch <- prometheus.MustNewConstMetric(c.connections, prometheus.GaugeValue, float64(stats.OpenConnections), "open")
+	ch <- prometheus.MustNewConstMetric(c.connections, prometheus.GaugeValue, float64(stats.InUse), "in_use")
+	ch <- prometheus.MustNewConstMetric(c.connections, prometheus.GaugeValue, float64(stats.Idle), "idle")
+	ch <- prometheus.MustNewConstMetric(c.waitCount, prometheus.CounterValue, float64(stats.WaitCount))
+	ch <- prometheus.MustNewConstMetric(c.waitDuration, prometheus.CounterValue, stats.WaitDuration.Seconds())
+	ch <- prometheus.MustNewConstMetric(c.closed, prometheus.CounterValue, float64(stats.MaxIdleClosed), "max_idle")
+	ch <- prometheus.MustNewConstMetric(c.closed, prometheus.CounterValue, float64(stats.MaxIdleTimeClosed), "max_idle_time")
+	ch <- prometheus.MustNewConstMetric(c.closed, prometheus.CounterValue, float64(stats.MaxLifetimeClosed), "max_lifetime")
+}
@@ -0,0 +1,291 @@
+// Package metrics holds the Prometheus metrics exported by the worker.
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Metrics are the Prometheus metrics of one worker, registered in a registry
+// of their own so that several workers can run in one process
+type Metrics struct {
+	registry *prometheus.Registry
+
+	VotesProcessed          *prometheus.CounterVec
+	RedisErrors             prometheus.Counter
+	DBErrors                prometheus.Counter
+	HealthChecks            *prometheus.CounterVec
+	ProcessTime             prometheus.Histogram
+	BatchSize               prometheus.Histogram
+	VotesDeduplicated       prometheus.Counter
+	VotesRejected           *prometheus.CounterVec
+	VotesReplaced           prometheus.Counter
+	PollOpen                *prometheus.GaugeVec
+	TallyDrift              *prometheus.GaugeVec
+	EventPublishFailures    prometheus.Counter
+	BatchFlushTime          prometheus.Histogram
+	VotesInFlight           prometheus.Gauge
+	VotesRecovered          prometheus.Counter
+	VotesDeadLettered       *prometheus.CounterVec
+	DLQSize                 prometheus.Gauge
+	DependencyUp            *prometheus.GaugeVec
+	DependencyCheckDuration *prometheus.GaugeVec
+	DependencyFailures      *prometheus.GaugeVec
+	RedisPoolConnections    *prometheus.GaugeVec
+	CircuitBreakerState     *prometheus.GaugeVec
+	ConfigReloads           *prometheus.CounterVec
+	QueueLength             prometheus.Gauge
+	VoteAge                 prometheus.Histogram
+}
+
+// New creates the worker metrics along with the Go runtime and process
+// collectors
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+
+		VotesProcessed: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "votes_processed_total",
+				Help: "Total number of votes processed",
+			},
+			[]string{"poll", "choice"},
+		),
+
+		RedisErrors: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Name: "redis_errors_total",
+				Help: "Total number of Redis errors",
+			},
+		),
+
+		DBErrors: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Name: "database_errors_total",
+				Help: "Total number of database errors",
+			},
+		),
+
+		HealthChecks: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "health_checks_total",
+				Help: "Total number of health checks",
+			},
+			[]string{"status"},
+		),
+
+		ProcessTime: prometheus.NewHistogram(
+			prometheus.HistogramOpts{
+				Name: "vote_process_duration_seconds",
+				Help: "Time taken to process a vote",
+			},
+		),
+
+		BatchSize: prometheus.NewHistogram(
+			prometheus.HistogramOpts{
+				Name:    "vote_batch_size",
+				Help:    "Number of votes in each committed batch, including duplicates and rejected votes",
+				Buckets: []float64{1, 5, 10, 25, 50, 100, 250, 500, 1000},
+			},
+		),
+
+		VotesDeduplicated: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Name: "votes_deduplicated_total",
+				Help: "Total number of votes skipped because their vote_id was already stored",
+			},
+		),
+
+		VotesRejected: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "votes_rejected_total",
+				Help: "Total number of valid votes that were not stored",
+			},
+			[]string{"reason"},
+		),
+
+		VotesReplaced: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Name: "votes_replaced_total",
+				Help: "Total number of votes that replaced a voter's earlier vote",
+			},
+		),
+
+		PollOpen: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "poll_open",
+				Help: "Whether a poll accepts votes (1) or is closed (0)",
+			},
+			[]string{"poll"},
+		),
+
+		TallyDrift: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "vote_tally_drift",
+				Help: "Absolute difference between vote_tallies and the votes table found by the last reconciliation",
+			},
+			[]string{"poll"},
+		),
+
+		EventPublishFailures: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Name: "vote_event_publish_failures_total",
+				Help: "Total number of vote events that could not be published",
+			},
+		),
+
+		BatchFlushTime: prometheus.NewHistogram(
+			prometheus.HistogramOpts{
+				Name: "vote_batch_flush_duration_seconds",
+				Help: "Time taken to write a batch of votes",
+			},
+		),
+
+		VotesInFlight: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "votes_in_flight",
+				Help: "Number of votes received but not yet acknowledged",
+			},
+		),
+
+		VotesRecovered: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Name: "votes_recovered_total",
+				Help: "Total number of votes recovered from dead or restarted consumers",
+			},
+		),
+
+		VotesDeadLettered: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "votes_dead_lettered_total",
+				Help: "Total number of votes moved to the dead-letter queue",
+			},
+			[]string{"reason"},
+		),
+
+		DLQSize: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "dead_letter_queue_size",
+				Help: "Number of entries in the dead-letter queue",
+			},
+		),
+
+		DependencyUp: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "dependency_up",
+				Help: "Whether the last health check of a dependency succeeded",
+			},
+			[]string{"dependency"},
+		),
+
+		DependencyCheckDuration: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "dependency_check_duration_seconds",
+				Help: "Duration of the last health check of a dependency",
+			},
+			[]string{"dependency"},
+		),
+
+		DependencyFailures: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "dependency_consecutive_failures",
+				Help: "Number of consecutive failed health checks of a dependency",
+			},
+			[]string{"dependency"},
+		),
+
+		RedisPoolConnections: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "redis_pool_connections",
+				Help: "Redis pool connections by state",
+			},
+			[]string{"state"},
+		),
+
+		CircuitBreakerState: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "circuit_breaker_state",
+				Help: "Circuit breaker state of a dependency (0 closed, 1 half-open, 2 open)",
+			},
+			[]string{"dependency"},
+		),
+
+		ConfigReloads: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "config_reloads_total",
+				Help: "Total number of configuration reloads by result",
+			},
+			[]string{"result"},
+		),
+
+		QueueLength: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "vote_queue_length",
+				Help: "Number of votes waiting in the queue, sampled every health check interval",
+			},
+		),
+
+		VoteAge: prometheus.NewHistogram(
+			prometheus.HistogramOpts{
+				Name:    "vote_age_seconds",
+				Help:    "Time between a vote's payload timestamp and its processing",
+				Buckets: []float64{0.01, 0.05, 0.1, 0.5, 1, 5, 10, 30, 60, 300, 900},
+			},
+		),
+	}
+
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.VotesProcessed,
+		m.RedisErrors,
+		m.DBErrors,
+		m.HealthChecks,
+		m.ProcessTime,
+		m.BatchSize,
+		m.VotesDeduplicated,
+		m.VotesRejected,
+		m.VotesReplaced,
+		m.PollOpen,
+		m.TallyDrift,
+		m.EventPublishFailures,
+		m.BatchFlushTime,
+		m.VotesInFlight,
+		m.VotesRecovered,
+		m.VotesDeadLettered,
+		m.DLQSize,
+		m.DependencyUp,
+		m.DependencyCheckDuration,
+		m.DependencyFailures,
+		m.RedisPoolConnections,
+		m.CircuitBreakerState,
+		m.ConfigReloads,
+		m.QueueLength,
+		m.VoteAge,
+	)
+	return m
+}
+
+// Register adds collectors to the worker's registry
+func (m *Metrics) Register(collectors ...prometheus.Collector) error {
+	for _, collector := range collectors {
+		if err := m.registry.Register(collector); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Gatherer returns the registry the metrics are collected from
+func (m *Metrics) Gatherer() prometheus.Gatherer {
+	return m.registry
+}
+
+// Handler serves the metrics in the Prometheus exposition format, or in the
+// OpenMetrics format, which carries exemplars, to scrapers that ask for it
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{EnableOpenMetrics: true})
+}
@@ -0,0 +1,62 @@
+package metrics
+
+import (
+	"database/sql"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistriesAreIndependent(t *testing.T) {
+	first, second := New(), New()
+
+	first.VotesProcessed.WithLabelValues("default", "cats").Inc()
+	assert.Equal(t, 1.0, testutil.ToFloat64(first.VotesProcessed.WithLabelValues("default", "cats")))
+	assert.Zero(t, testutil.ToFloat64(second.VotesProcessed.WithLabelValues("default", "cats")))
+
+	families, err := first.Gatherer().Gather()
+	require.NoError(t, err)
+	names := make(map[string]bool)
+	for _, family := range families {
+		names[family.GetName()] = true
+	}
+	assert.True(t, names["go_goroutines"], "Go runtime collector")
+	assert.True(t, names["process_start_time_seconds"], "process collector")
+	assert.True(t, names["votes_processed_total"])
+}
+
+func TestDBStatsCollector(t *testing.T) {
+	stats := sql.DBStats{
+		MaxOpenConnections: 10,
+		OpenConnections:    3,
+		InUse:              2,
+		Idle:               1,
+		WaitCount:          4,
+		WaitDuration:       1500 * time.Millisecond,
+		MaxLifetimeClosed:  5,
+	}
+	m := New()
+	require.NoError(t, m.Register(NewDBStatsCollector(func() sql.DBStats { return stats })))
+
+	expected := `
+# HELP db_pool_connections Database pool connections by state
+# TYPE db_pool_connections gauge
+db_pool_connections{state="idle"} 1
+db_pool_connections{state="in_use"} 2
+db_pool_connections{state="open"} 3
+# HELP db_pool_wait_duration_seconds_total Total time spent waiting for a database connection
+# TYPE db_pool_wait_duration_seconds_total counter
+db_pool_wait_duration_seconds_total 1.5
+# HELP db_pool_closed_connections_total Total number of database connections closed by the pool limits
+# TYPE db_pool_closed_connections_total counter
+db_pool_closed_connections_total{reason="max_idle"} 0
+db_pool_closed_connections_total{reason="max_idle_time"} 0
+db_pool_closed_connections_total{reason="max_lifetime"} 5
+`
+	assert.NoError(t, testutil.GatherAndCompare(m.Gatherer(), strings.NewReader(expected),
+		"db_pool_connections", "db_pool_wait_duration_seconds_total", "db_pool_closed_connections_total"))
+}
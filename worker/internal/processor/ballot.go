@@ -0,0 +1,180 @@
+package processor
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"worker/internal/config"
+	"worker/internal/store"
+)
+
+// Ballot sources
+const (
+	// ballotSourceNone accepts any poll and option
+	ballotSourceNone = "none"
+	// ballotSourceFile loads polls from BALLOT_FILE
+	ballotSourceFile = "file"
+	// ballotSourceDatabase loads polls from the ballot_options table
+	ballotSourceDatabase = "database"
+)
+
+// Ballot rejection reasons
+const (
+	reasonUnknownPoll   = "unknown_poll"
+	reasonInvalidOption = "invalid_option"
+)
+
+// PollDefinition lists the valid options of one poll
+type PollDefinition struct {
+	ID      string   `json:"id" yaml:"id"`
+	Options []string `json:"options" yaml:"options"`
+}
+
+// BallotDefinition is the on-disk format of BALLOT_FILE
+type BallotDefinition struct {
+	Polls []PollDefinition `json:"polls" yaml:"polls"`
+}
+
+// Ballot holds the valid options of every poll. A nil Ballot accepts everything.
+type Ballot struct {
+	polls map[string]map[string]bool
+}
+
+// rejectionError is a vote rejected by the ballot or poll state, carrying the rejection reason
+type rejectionError struct {
+	reason string
+	err    error
+}
+
+func (e *rejectionError) Error() string { return e.err.Error() }
+
+// NewBallot builds a ballot from poll definitions
+func NewBallot(definitions []PollDefinition) (*Ballot, error) {
+	ballot := &Ballot{polls: make(map[string]map[string]bool)}
+
+	for _, poll := range definitions {
+		if poll.ID == "" {
+			return nil, fmt.Errorf("poll without id")
+		}
+		if _, ok := ballot.polls[poll.ID]; ok {
+			return nil, fmt.Errorf("poll %q defined more than once", poll.ID)
+		}
+		if len(poll.Options) == 0 {
+			return nil, fmt.Errorf("poll %q has no options", poll.ID)
+		}
+
+		options := make(map[string]bool, len(poll.Options))
+		for _, option := range poll.Options {
+			if option == "" || len(option) > 10 {
+				return nil, fmt.Errorf("poll %q has invalid option %q", poll.ID, option)
+			}
+			options[option] = true
+		}
+		ballot.polls[poll.ID] = options
+	}
+
+	return ballot, nil
+}
+
+// Validate checks that a choice is an option of the given poll
+func (b *Ballot) Validate(pollID, choice string) error {
+	if b == nil {
+		return nil
+	}
+
+	options, ok := b.polls[pollID]
+	if !ok {
+		return &rejectionError{reason: reasonUnknownPoll, err: fmt.Errorf("unknown poll %q", pollID)}
+	}
+	if !options[choice] {
+		return &rejectionError{reason: reasonInvalidOption, err: fmt.Errorf("%q is not an option of poll %q", choice, pollID)}
+	}
+	return nil
+}
+
+// HasPoll reports whether a poll is defined
+func (b *Ballot) HasPoll(pollID string) bool {
+	if b == nil {
+		return true
+	}
+	_, ok := b.polls[pollID]
+	return ok
+}
+
+// PollIDs returns the defined polls in order
+func (b *Ballot) PollIDs() []string {
+	if b == nil {
+		return nil
+	}
+
+	ids := make([]string, 0, len(b.polls))
+	for id := range b.polls {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// loadBallot loads the ballot from the source configured in cfg
+func (w *Worker) loadBallot(cfg *config.Config) (*Ballot, error) {
+	switch cfg.BallotSource {
+	case ballotSourceNone, "":
+		return nil, nil
+	case ballotSourceFile:
+		return loadBallotFile(cfg.BallotFile)
+	case ballotSourceDatabase:
+		return loadBallotTable(w.sink)
+	default:
+		return nil, fmt.Errorf("unknown ballot source %q", cfg.BallotSource)
+	}
+}
+
+// loadBallotFile reads a YAML or JSON ballot definition
+func loadBallotFile(path string) (*Ballot, error) {
+	if path == "" {
+		return nil, fmt.Errorf("BALLOT_FILE is required when BALLOT_SOURCE is %q", ballotSourceFile)
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read ballot file: %w", err)
+	}
+
+	var definition BallotDefinition
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		err = json.Unmarshal(content, &definition)
+	} else {
+		err = yaml.Unmarshal(content, &definition)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse ballot file %s: %w", path, err)
+	}
+
+	ballot, err := NewBallot(definition.Polls)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ballot file %s: %w", path, err)
+	}
+	return ballot, nil
+}
+
+// loadBallotTable reads poll options from the ballot_options table
+func loadBallotTable(sink store.VoteSink) (*Ballot, error) {
+	options, err := sink.BallotOptions()
+	if err != nil {
+		return nil, err
+	}
+
+	definitions := make([]PollDefinition, 0, len(options))
+	for pollID, values := range options {
+		definitions = append(definitions, PollDefinition{ID: pollID, Options: values})
+	}
+	sort.Slice(definitions, func(i, j int) bool { return definitions[i].ID < definitions[j].ID })
+
+	return NewBallot(definitions)
+}
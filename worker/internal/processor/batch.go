@@ -0,0 +1,232 @@
+package processor
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"worker/internal/queue"
+	"worker/internal/store"
+)
+
+// pendingVote is a received vote awaiting a batch flush
+type pendingVote struct {
+	queue.Delivery
+	vote  store.Vote
+	trace voteTrace
+}
+
+// processBatch decodes and validates a batch taken off the queue at
+// dequeued, stores the valid votes in one transaction and acknowledges them
+// only once it commits. It returns the insert error after the failed votes
+// have been requeued or dead-lettered; the votes stored alongside them are
+// acknowledged. Each vote is traced from dequeue to insert.
+func (w *Worker) processBatch(batch []queue.Delivery, dequeued time.Time) error {
+	received := time.Now()
+	votes := make([]pendingVote, 0, len(batch))
+	settings, ballot := w.live.get()
+
+	for _, d := range batch {
+		decodeStart := time.Now()
+		var vote Vote
+		err := json.Unmarshal([]byte(d.Data), &vote)
+
+		vt := w.startVoteTrace(vote.Traceparent, dequeued)
+		vt.step(spanDequeue, dequeued, received, nil,
+			attribute.String("messaging.destination.name", settings.VoteQueue),
+			attribute.Int("messaging.batch.message_count", len(batch)),
+		)
+		vt.step(spanDecode, decodeStart, time.Now(), err)
+		if err != nil {
+			w.logger.WithError(err).Error("Failed to unmarshal vote data")
+			w.deadLetter(d, reasonDecode, err, queue.FailureRecord{})
+			vt.end(err)
+			continue
+		}
+
+		validating := vt.begin(spanValidate)
+		if err := validateVote(vote); err != nil {
+			w.logger.WithError(err).Error("Invalid vote data")
+			w.deadLetter(d, reasonValidation, err, queue.FailureRecord{})
+			vt.fail(validating, err)
+			continue
+		}
+
+		if vote.PollID == "" {
+			vote.PollID = settings.DefaultPoll
+		}
+
+		if err := ballot.Validate(vote.PollID, vote.Vote); err != nil {
+			w.rejectInvalidVote(d, err)
+			vt.fail(validating, err)
+			continue
+		}
+
+		if err := w.checkPoll(vote.PollID); err != nil {
+			w.rejectInvalidVote(d, err)
+			vt.fail(validating, err)
+			continue
+		}
+		validating.End()
+
+		if vote.VoteID == "" {
+			vote.VoteID = deriveVoteID(vote)
+		}
+		vt.span.SetAttributes(
+			attribute.String("vote.id", vote.VoteID),
+			attribute.String("vote.poll_id", vote.PollID),
+		)
+
+		// Parse timestamp with multiple format attempts
+		timestamp, err := parseTimestamp(vote.Timestamp)
+		if err != nil {
+			w.logger.WithError(err).Error("Failed to parse timestamp")
+			timestamp = time.Now()
+		} else {
+			// Clocks of the vote service and the worker may disagree slightly
+			w.metrics.VoteAge.Observe(max(0, time.Since(timestamp).Seconds()))
+		}
+		// Not every database keeps the offset, so timestamps are stored in UTC
+		timestamp = timestamp.UTC()
+
+		votes = append(votes, pendingVote{
+			Delivery: d,
+			trace:    vt,
+			vote: store.Vote{
+				VoteID:    vote.VoteID,
+				PollID:    vote.PollID,
+				Choice:    vote.Vote,
+				VoterID:   vote.VoterID,
+				Timestamp: timestamp,
+			},
+		})
+	}
+
+	if len(votes) == 0 {
+		return nil
+	}
+
+	return w.storeVotes(votes, received)
+}
+
+// storeVotes inserts votes in one transaction and acknowledges them once it
+// commits. When the insert fails while the database is reachable, a vote in
+// the batch is at fault, so the batch is split in halves and retried until the
+// failing votes are alone; only they are requeued or dead-lettered.
+func (w *Worker) storeVotes(votes []pendingVote, received time.Time) error {
+	records := make([]store.Vote, 0, len(votes))
+	for _, pending := range votes {
+		records = append(records, pending.vote)
+	}
+
+	start := time.Now()
+	result, err := w.sink.InsertBatch(w.batchCtx, records)
+	inserted := time.Now()
+	for _, pending := range votes {
+		pending.trace.step(spanInsert, start, inserted, err, attribute.Int("db.batch_size", len(votes)))
+	}
+	if err != nil && w.batchCtx.Err() != nil {
+		// The shutdown timeout aborted the insert; the drain puts the votes back
+		for _, pending := range votes {
+			pending.trace.end(err)
+		}
+		return err
+	}
+	if err != nil {
+		w.metrics.DBErrors.Inc()
+		reachable := w.sink.Ping(w.batchCtx) == nil
+		if reachable && len(votes) > 1 {
+			w.logger.WithError(err).WithField("batch_size", len(votes)).Warn("Failed to insert votes, retrying in halves to isolate the failing vote")
+			half := len(votes) / 2
+			return errors.Join(w.storeVotes(votes[:half], received), w.storeVotes(votes[half:], received))
+		}
+		if !reachable {
+			w.breakers[dependencyDatabase].Failure()
+		}
+		w.logger.WithError(err).WithField("batch_size", len(votes)).Error("Failed to insert votes into database")
+		// Put the votes back to the queue for retry, or dead-letter them
+		for _, pending := range votes {
+			w.handleFailure(pending.Delivery, err)
+			pending.trace.end(err)
+		}
+		return err
+	}
+	w.breakers[dependencyDatabase].Success()
+	w.metrics.BatchSize.Observe(float64(len(votes)))
+	w.metrics.BatchFlushTime.Observe(time.Since(start).Seconds())
+
+	w.ackVotes(votes)
+	defer func() {
+		for _, pending := range votes {
+			pending.trace.end(nil)
+		}
+	}()
+
+	traces := make(map[string]trace.SpanContext, len(votes))
+	for _, pending := range votes {
+		traces[pending.vote.VoteID] = pending.trace.span.SpanContext()
+	}
+	w.updateRedisTallies(result.Counts)
+	w.publishVoteEvents(result.Counts)
+	w.metrics.VotesDeduplicated.Add(float64(result.Deduplicated))
+	w.metrics.VotesReplaced.Add(float64(result.Replaced))
+	for reason, count := range result.Rejected {
+		w.metrics.VotesRejected.WithLabelValues(reason).Add(float64(count))
+	}
+	settings, ballot := w.live.get()
+	for _, vote := range result.Stored {
+		w.metrics.VotesProcessed.WithLabelValues(w.pollLabel(settings, ballot, vote.PollID), vote.Choice).Inc()
+		observeWithTrace(w.metrics.ProcessTime, time.Since(received).Seconds(), traces[vote.VoteID])
+		w.logger.WithFields(logrus.Fields{
+			"poll_id":   vote.PollID,
+			"vote":      vote.Choice,
+			"voter_id":  vote.VoterID,
+			"timestamp": vote.Timestamp,
+		}).Info("Vote processed successfully")
+	}
+	return nil
+}
+
+// rejectInvalidVote routes a vote that fails ballot or poll checks to the dead-letter queue
+func (w *Worker) rejectInvalidVote(d queue.Delivery, err error) {
+	reason := reasonValidation
+	var rejection *rejectionError
+	if errors.As(err, &rejection) {
+		reason = rejection.reason
+	}
+
+	w.metrics.VotesRejected.WithLabelValues(reason).Inc()
+	w.logger.WithError(err).WithField("reason", reason).Warn("Vote rejected")
+	w.deadLetter(d, reason, err, queue.FailureRecord{})
+}
+
+// deriveVoteID builds a deterministic vote_id for payloads that do not carry one,
+// so that redelivery of the same payload maps to the same row. The poll is part
+// of it, with the default applied, since polls may share option names.
+func deriveVoteID(vote Vote) string {
+	sum := sha256.Sum256([]byte(vote.VoterID + "\x00" + vote.PollID + "\x00" + vote.Vote + "\x00" + vote.Timestamp))
+	return hex.EncodeToString(sum[:])
+}
+
+// ackVotes removes a committed batch from the queue
+func (w *Worker) ackVotes(votes []pendingVote) {
+	deliveries := make([]queue.Delivery, 0, len(votes))
+	for _, pending := range votes {
+		deliveries = append(deliveries, pending.Delivery)
+	}
+
+	// Votes that stay unacknowledged are delivered again once recovered, and
+	// then skipped because their vote_id is stored
+	if err := w.queue.Ack(w.batchCtx, deliveries); err != nil {
+		w.logger.WithError(err).Error("Failed to acknowledge votes")
+		w.inFlight.settle(settledPending, deliveries...)
+		return
+	}
+	w.inFlight.settle(settledDone, deliveries...)
+}
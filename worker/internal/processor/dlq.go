@@ -0,0 +1,170 @@
+package processor
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"worker/internal/queue"
+)
+
+// Dead-letter reasons
+const (
+	reasonDecode           = "decode"
+	reasonValidation       = "validation"
+	reasonRetriesExhausted = "retries_exhausted"
+)
+
+// validateVote checks that a decoded vote can be stored
+func validateVote(vote Vote) error {
+	if vote.Vote == "" {
+		return fmt.Errorf("vote is required")
+	}
+	if len(vote.Vote) > 10 {
+		return fmt.Errorf("vote exceeds 10 characters: %q", vote.Vote)
+	}
+	if len(vote.PollID) > 64 {
+		return fmt.Errorf("poll_id exceeds 64 characters")
+	}
+	if len(vote.VoteID) > 64 {
+		return fmt.Errorf("vote_id exceeds 64 characters")
+	}
+	if vote.VoterID == "" {
+		return fmt.Errorf("voter_id is required")
+	}
+	if len(vote.VoterID) > 255 {
+		return fmt.Errorf("voter_id exceeds 255 characters")
+	}
+	return nil
+}
+
+// handleFailure retries a vote that failed to insert, or dead-letters it once
+// the retry budget is exhausted
+func (w *Worker) handleFailure(d queue.Delivery, cause error) {
+	record, err := w.queue.RecordFailure(w.ctx, d.Data)
+	if err != nil {
+		w.logger.WithError(err).Warn("Failed to record vote retry")
+	}
+	if record.Attempts >= w.settings().MaxRetries {
+		w.deadLetter(d, reasonRetriesExhausted, cause, record)
+		return
+	}
+
+	if err := w.queue.Requeue(w.ctx, d); err != nil {
+		w.logger.WithError(err).Error("Failed to requeue vote")
+		w.inFlight.settle(settledPending, d)
+		return
+	}
+	w.inFlight.settle(settledRequeued, d)
+}
+
+// deadLetter moves a vote from the queue to the dead-letter queue
+func (w *Worker) deadLetter(d queue.Delivery, reason string, cause error, record queue.FailureRecord) {
+	now := time.Now().UTC()
+	if record.Attempts == 0 {
+		record = queue.FailureRecord{Attempts: 1, FirstFailure: now}
+	}
+
+	entry, _ := json.Marshal(queue.DeadLetter{
+		Payload:      d.Data,
+		Reason:       reason,
+		Error:        cause.Error(),
+		Attempts:     record.Attempts,
+		FirstFailure: record.FirstFailure,
+		LastFailure:  now,
+	})
+
+	size, err := w.queue.DeadLetter(w.ctx, d, entry)
+	if err != nil {
+		w.logger.WithError(err).Error("Failed to dead-letter vote")
+		w.inFlight.settle(settledPending, d)
+		return
+	}
+	w.inFlight.settle(settledDone, d)
+
+	w.metrics.VotesDeadLettered.WithLabelValues(reason).Inc()
+	w.metrics.DLQSize.Set(float64(size))
+	w.logger.WithFields(logrus.Fields{
+		"reason":   reason,
+		"attempts": record.Attempts,
+		"error":    cause.Error(),
+	}).Warn("Vote moved to dead-letter queue")
+}
+
+// refreshDLQSize samples the dead-letter queue length into its gauge
+func (w *Worker) refreshDLQSize(ctx context.Context) (int64, error) {
+	size, err := w.queue.DeadLetterCount(ctx)
+	if err != nil {
+		return 0, err
+	}
+	w.metrics.DLQSize.Set(float64(size))
+	return size, nil
+}
+
+// DeadLetters returns up to limit dead letters, newest first, and the size
+// of the dead-letter queue
+func (w *Worker) DeadLetters(ctx context.Context, limit int) ([]queue.DeadLetter, int64, error) {
+	raw, err := w.queue.DeadLetters(ctx, limit)
+	if err != nil {
+		return nil, 0, err
+	}
+	size, err := w.refreshDLQSize(ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	entries := make([]queue.DeadLetter, 0, len(raw))
+	for _, item := range raw {
+		var entry queue.DeadLetter
+		if err := json.Unmarshal([]byte(item), &entry); err != nil {
+			entry = queue.DeadLetter{Payload: item, Error: "unreadable dead-letter entry"}
+		}
+		entries = append(entries, entry)
+	}
+	return entries, size, nil
+}
+
+// ReplayDeadLetters moves up to count of the oldest dead letters back onto
+// the queue, or all of them when count is 0, and returns how many were moved.
+// The dead-letter queue is measured once up front, so votes that fail again
+// while the replay runs are left for the next one instead of cycling forever.
+func (w *Worker) ReplayDeadLetters(ctx context.Context, count int) (int, error) {
+	size, err := w.queue.DeadLetterCount(ctx)
+	if err != nil {
+		return 0, err
+	}
+	limit := int(size)
+	if count > 0 && count < limit {
+		limit = count
+	}
+
+	replayed := 0
+	for replayed < limit {
+		err := w.queue.ReplayDeadLetter(ctx)
+		if err == queue.ErrNoDeadLetters {
+			break
+		} else if err != nil {
+			return replayed, err
+		}
+		replayed++
+	}
+
+	w.refreshDLQSize(ctx)
+	w.logger.WithField("replayed", replayed).Info("Replayed dead-letter queue")
+	return replayed, nil
+}
+
+// PurgeDeadLetters discards every dead letter and returns how many there were
+func (w *Worker) PurgeDeadLetters(ctx context.Context) (int64, error) {
+	purged, err := w.queue.PurgeDeadLetters(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	w.metrics.DLQSize.Set(0)
+	w.logger.WithField("purged", purged).Warn("Purged dead-letter queue")
+	return purged, nil
+}
@@ -0,0 +1,179 @@
+package processor
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+
+	"worker/internal/queue"
+)
+
+// abandonTimeout bounds both waiting for the consumers to return and putting
+// back the votes still in flight once the shutdown timeout expires
+const abandonTimeout = 5 * time.Second
+
+// settlement is how a vote in flight left the worker
+type settlement int
+
+const (
+	// settledDone votes were acknowledged or dead-lettered
+	settledDone settlement = iota
+	// settledRequeued votes were put back onto the queue
+	settledRequeued
+	// settledPending votes could not be acknowledged, dead-lettered or put
+	// back; they stay in the processing list or pending entries until they
+	// are recovered
+	settledPending
+)
+
+// flightTracker holds the votes the consumers have received but not settled
+// yet, and counts how they were settled once a drain has begun
+type flightTracker struct {
+	// gauge exports the number of votes in flight
+	gauge      prometheus.Gauge
+	mu         sync.Mutex
+	deliveries map[queue.Delivery]int
+	draining   bool
+	drained    int
+	requeued   int
+	pending    int
+}
+
+// add tracks a received batch
+func (t *flightTracker) add(batch []queue.Delivery) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.deliveries == nil {
+		t.deliveries = make(map[queue.Delivery]int)
+	}
+	for _, d := range batch {
+		t.deliveries[d]++
+	}
+	t.gauge.Add(float64(len(batch)))
+}
+
+// settle forgets deliveries the consumers are done with, counting them by
+// outcome once a drain has begun. Deliveries given up by abandon are ignored.
+func (t *flightTracker) settle(outcome settlement, deliveries ...queue.Delivery) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, d := range deliveries {
+		if t.deliveries[d] == 0 {
+			continue
+		}
+		if t.deliveries[d]--; t.deliveries[d] == 0 {
+			delete(t.deliveries, d)
+		}
+		t.gauge.Dec()
+
+		if !t.draining {
+			continue
+		}
+		switch outcome {
+		case settledRequeued:
+			t.requeued++
+		case settledPending:
+			t.pending++
+		default:
+			t.drained++
+		}
+	}
+}
+
+// startDraining begins counting how in-flight votes are settled
+func (t *flightTracker) startDraining() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.draining = true
+}
+
+// abandon stops tracking every vote still in flight and returns them
+func (t *flightTracker) abandon() []queue.Delivery {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var abandoned []queue.Delivery
+	for d, count := range t.deliveries {
+		for i := 0; i < count; i++ {
+			abandoned = append(abandoned, d)
+		}
+	}
+	t.deliveries = nil
+	t.gauge.Sub(float64(len(abandoned)))
+	return abandoned
+}
+
+// countRequeued counts votes requeued after being abandoned
+func (t *flightTracker) countRequeued(n int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.requeued += n
+}
+
+// summary returns how many votes were settled, requeued and left pending
+// during the drain
+func (t *flightTracker) summary() (drained, requeued, pending int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.drained, t.requeued, t.pending
+}
+
+// drain waits up to SHUTDOWN_TIMEOUT for the consumers, which no longer take
+// new votes, to settle the votes they hold. After that the inserts and acks
+// still running are aborted, the consumers get abandonTimeout to return, and
+// the votes still in flight are put back onto the queue; those that cannot be
+// stay in the processing list or pending entries, where the next start
+// recovers them.
+func (w *Worker) drain() {
+	done := make(chan struct{})
+	go func() {
+		w.consumers.Wait()
+		close(done)
+	}()
+
+	timedOut := false
+	select {
+	case <-done:
+	case <-time.After(w.config.ShutdownTimeout):
+		timedOut = true
+	}
+
+	abandonedPending := 0
+	if timedOut {
+		// Roll back the inserts first, so that no vote is stored after it
+		// has been put back
+		w.abortBatches()
+		select {
+		case <-done:
+		case <-time.After(abandonTimeout):
+			w.logger.Error("Consumers did not stop after their inserts were aborted")
+		}
+
+		abandoned := w.inFlight.abandon()
+		w.logger.WithField("votes", len(abandoned)).Warn("Shutdown timeout reached, requeueing votes still in flight")
+
+		ctx, cancel := context.WithTimeout(w.ctx, abandonTimeout)
+		for _, d := range abandoned {
+			if err := w.queue.Requeue(ctx, d); err != nil {
+				w.logger.WithError(err).Error("Failed to requeue vote")
+				abandonedPending++
+			}
+		}
+		cancel()
+		w.inFlight.countRequeued(len(abandoned) - abandonedPending)
+	}
+
+	drained, requeued, pending := w.inFlight.summary()
+	pending += abandonedPending
+	w.logger.WithFields(logrus.Fields{
+		"drained":   drained,
+		"requeued":  requeued,
+		"pending":   pending,
+		"timed_out": timedOut,
+	}).Info("In-flight votes drained")
+}
@@ -0,0 +1,127 @@
+package processor
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+
+	"worker/internal/store"
+)
+
+// VoteEvent is published after votes for an option are committed
+type VoteEvent struct {
+	PollID      string    `json:"poll_id"`
+	Choice      string    `json:"choice"`
+	Tally       int64     `json:"tally"`
+	Version     int64     `json:"version"`
+	ProcessedAt time.Time `json:"processed_at"`
+}
+
+// eventPublisher coalesces vote events per option and publishes the latest
+// one on a fixed interval, so bursts do not flood subscribers
+type eventPublisher struct {
+	mu      sync.Mutex
+	pending map[store.TallyKey]VoteEvent
+}
+
+// offer queues events, replacing events of the same option with a lower
+// tally version. It never blocks on Redis.
+func (p *eventPublisher) offer(events []VoteEvent) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.pending == nil {
+		p.pending = make(map[store.TallyKey]VoteEvent)
+	}
+	for _, event := range events {
+		key := store.TallyKey{Poll: event.PollID, Vote: event.Choice}
+		if current, ok := p.pending[key]; ok && current.Version > event.Version {
+			continue
+		}
+		p.pending[key] = event
+	}
+}
+
+// take removes and returns all queued events
+func (p *eventPublisher) take() []VoteEvent {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	events := make([]VoteEvent, 0, len(p.pending))
+	for _, event := range p.pending {
+		events = append(events, event)
+	}
+	p.pending = nil
+	return events
+}
+
+// publishVoteEvents queues events for the options changed by a committed batch
+func (w *Worker) publishVoteEvents(counts map[store.TallyKey]store.TallyCount) {
+	if w.config.EventsChannel == "" || len(counts) == 0 {
+		return
+	}
+
+	now := time.Now().UTC()
+	events := make([]VoteEvent, 0, len(counts))
+	for key, count := range counts {
+		events = append(events, VoteEvent{
+			PollID:      key.Poll,
+			Choice:      key.Vote,
+			Tally:       count.Count,
+			Version:     count.Version,
+			ProcessedAt: now,
+		})
+	}
+	w.events.offer(events)
+}
+
+// startEventPublisher flushes coalesced events to the events channel
+func (w *Worker) startEventPublisher() {
+	if w.config.EventsChannel == "" {
+		return
+	}
+
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+
+		ticker := time.NewTicker(w.config.EventsInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-w.ctx.Done():
+				// Deliver what is left before the Redis client closes
+				ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+				w.flushVoteEvents(ctx)
+				cancel()
+				return
+			case <-ticker.C:
+				w.flushVoteEvents(w.ctx)
+			}
+		}
+	}()
+}
+
+// flushVoteEvents publishes all queued events in one pipeline
+func (w *Worker) flushVoteEvents(ctx context.Context) {
+	events := w.events.take()
+	if len(events) == 0 {
+		return
+	}
+
+	_, err := w.redisClient.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, event := range events {
+			payload, _ := json.Marshal(event)
+			pipe.Publish(ctx, w.config.EventsChannel, payload)
+		}
+		return nil
+	})
+	if err != nil {
+		w.metrics.EventPublishFailures.Add(float64(len(events)))
+		w.logger.WithError(err).Warn("Failed to publish vote events")
+	}
+}
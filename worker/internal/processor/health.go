@@ -0,0 +1,112 @@
+package processor
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Worker states reported by the health endpoints
+const (
+	// StateStarting is reported until Start has connected every dependency
+	// and started the consumers
+	StateStarting = "starting"
+	// StateRunning is reported while consumers are processing votes
+	StateRunning = "running"
+	// StateStopping is reported once Stop has been called
+	StateStopping = "stopping"
+)
+
+// HealthCheck probes one dependency of the worker
+type HealthCheck struct {
+	Name  string
+	Check func(ctx context.Context) error
+}
+
+// CheckResult is the outcome of one health check
+type CheckResult struct {
+	Name    string
+	Err     error
+	Latency time.Duration
+}
+
+// State returns the lifecycle state of the worker
+func (w *Worker) State() string {
+	return w.state.Load().(string)
+}
+
+// HealthChecks returns a probe for each dependency the worker uses
+func (w *Worker) HealthChecks() []HealthCheck {
+	var checks []HealthCheck
+	if w.redisClient != nil {
+		checks = append(checks, HealthCheck{
+			Name:  "redis",
+			Check: func(ctx context.Context) error { return w.redisClient.Ping(ctx).Err() },
+		})
+	}
+	if w.sink != nil {
+		checks = append(checks, HealthCheck{Name: "database", Check: w.sink.Ping})
+	}
+	return checks
+}
+
+// checkDependencies runs every health check concurrently. Each check is
+// abandoned after HEALTH_CHECK_TIMEOUT, so a hung dependency cannot block
+// the health monitor for longer than that.
+func (w *Worker) checkDependencies(ctx context.Context) []CheckResult {
+	checks := w.HealthChecks()
+	results := make([]CheckResult, len(checks))
+
+	var wg sync.WaitGroup
+	for i, check := range checks {
+		wg.Add(1)
+		go func(i int, check HealthCheck) {
+			defer wg.Done()
+			results[i] = runCheck(ctx, check, w.config.HealthCheckTimeout)
+		}(i, check)
+	}
+	wg.Wait()
+	return results
+}
+
+// runCheck runs one check, returning when it finishes or its timeout elapses
+func runCheck(ctx context.Context, check HealthCheck, timeout time.Duration) CheckResult {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() { done <- check.Check(ctx) }()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = fmt.Errorf("%s check timed out after %s", check.Name, timeout)
+	}
+	return CheckResult{Name: check.Name, Err: err, Latency: time.Since(start)}
+}
+
+// markIteration records that a consumer is at the top of its processing loop
+func (w *Worker) markIteration(consumer int) {
+	w.lastIteration[consumer].Store(time.Now().UnixNano())
+}
+
+// Liveness reports whether every consumer is still going round its
+// processing loop. A consumer that has not started a new iteration within
+// STALL_TIMEOUT is stalled, for example on a call that never returns.
+// Consumers are only checked while the worker is running.
+func (w *Worker) Liveness() error {
+	if w.State() != StateRunning {
+		return nil
+	}
+
+	for consumer := range w.lastIteration {
+		last := time.Unix(0, w.lastIteration[consumer].Load())
+		if idle := time.Since(last); idle > w.config.StallTimeout {
+			return fmt.Errorf("consumer %d stalled: no loop iteration for %s", consumer, idle.Round(time.Second))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,214 @@
+package processor
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"worker/internal/resilience"
+)
+
+// DependencyStatus is the latest health check result of one dependency
+type DependencyStatus struct {
+	Healthy             bool       `json:"healthy"`
+	Error               string     `json:"error,omitempty"`
+	LatencySeconds      float64    `json:"latency_seconds"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	LastChecked         time.Time  `json:"last_checked"`
+	LastSuccess         *time.Time `json:"last_success,omitempty"`
+	// CircuitBreaker is the state of the breaker guarding the dependency
+	CircuitBreaker *resilience.BreakerStatus `json:"circuit_breaker,omitempty"`
+}
+
+// DatabasePoolStats is the state of the database connection pool, from sql.DBStats
+type DatabasePoolStats struct {
+	MaxOpenConnections int     `json:"max_open_connections"`
+	OpenConnections    int     `json:"open_connections"`
+	InUse              int     `json:"in_use"`
+	Idle               int     `json:"idle"`
+	WaitCount          int64   `json:"wait_count"`
+	WaitSeconds        float64 `json:"wait_seconds"`
+	MaxIdleClosed      int64   `json:"max_idle_closed"`
+	MaxLifetimeClosed  int64   `json:"max_lifetime_closed"`
+}
+
+// RedisPoolStats is the state of the Redis connection pool
+type RedisPoolStats struct {
+	Hits       uint32 `json:"hits"`
+	Misses     uint32 `json:"misses"`
+	Timeouts   uint32 `json:"timeouts"`
+	TotalConns uint32 `json:"total_connections"`
+	IdleConns  uint32 `json:"idle_connections"`
+	StaleConns uint32 `json:"stale_connections"`
+}
+
+// HealthReport is the cached health of the worker and its dependencies
+type HealthReport struct {
+	// Healthy is true when the worker is running and every dependency
+	// passed its last check
+	Healthy      bool                        `json:"healthy"`
+	State        string                      `json:"state"`
+	Dependencies map[string]DependencyStatus `json:"dependencies"`
+	DatabasePool *DatabasePoolStats          `json:"database_pool,omitempty"`
+	RedisPool    *RedisPoolStats             `json:"redis_pool,omitempty"`
+}
+
+// healthMonitor caches the results of the periodic dependency checks
+type healthMonitor struct {
+	mu       sync.RWMutex
+	statuses map[string]DependencyStatus
+}
+
+// record stores the result of a check, carrying over the failure count and
+// the time of the last success
+func (m *healthMonitor) record(result CheckResult, checked time.Time) DependencyStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.statuses == nil {
+		m.statuses = make(map[string]DependencyStatus)
+	}
+	status := m.statuses[result.Name]
+	status.LastChecked = checked
+	status.LatencySeconds = result.Latency.Seconds()
+	if result.Err != nil {
+		status.Healthy = false
+		status.Error = result.Err.Error()
+		status.ConsecutiveFailures++
+	} else {
+		status.Healthy = true
+		status.Error = ""
+		status.ConsecutiveFailures = 0
+		status.LastSuccess = &checked
+	}
+	m.statuses[result.Name] = status
+	return status
+}
+
+// snapshot copies the cached statuses
+func (m *healthMonitor) snapshot() map[string]DependencyStatus {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	statuses := make(map[string]DependencyStatus, len(m.statuses))
+	for name, status := range m.statuses {
+		statuses[name] = status
+	}
+	return statuses
+}
+
+// checkHealth runs every dependency check once and caches the results
+func (w *Worker) checkHealth(ctx context.Context) {
+	for _, result := range w.checkDependencies(ctx) {
+		status := w.monitor.record(result, time.Now())
+
+		w.metrics.DependencyUp.WithLabelValues(result.Name).Set(boolToFloat(status.Healthy))
+		w.metrics.DependencyCheckDuration.WithLabelValues(result.Name).Set(status.LatencySeconds)
+		w.metrics.DependencyFailures.WithLabelValues(result.Name).Set(float64(status.ConsecutiveFailures))
+		if result.Err != nil {
+			w.logger.WithError(result.Err).WithField("failures", status.ConsecutiveFailures).
+				Warnf("Health check of %s failed", result.Name)
+		}
+	}
+
+	if pool := w.redisPoolStats(); pool != nil {
+		w.metrics.RedisPoolConnections.WithLabelValues("total").Set(float64(pool.TotalConns))
+		w.metrics.RedisPoolConnections.WithLabelValues("idle").Set(float64(pool.IdleConns))
+		w.metrics.RedisPoolConnections.WithLabelValues("stale").Set(float64(pool.StaleConns))
+	}
+}
+
+// sampleQueueLength records how many votes wait in the queue
+func (w *Worker) sampleQueueLength(ctx context.Context) {
+	length, err := w.queue.Depth(ctx)
+	if err != nil {
+		w.logger.WithError(err).Warn("Failed to sample queue length")
+		return
+	}
+	w.metrics.QueueLength.Set(float64(length))
+}
+
+// startHealthMonitor re-checks the dependencies and samples the queue length
+// every HEALTH_CHECK_INTERVAL
+func (w *Worker) startHealthMonitor() {
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+
+		ticker := time.NewTicker(w.config.HealthCheckInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-w.ctx.Done():
+				return
+			case <-ticker.C:
+				w.checkHealth(w.ctx)
+				w.sampleQueueLength(w.ctx)
+			}
+		}
+	}()
+}
+
+// Health returns the cached health report. It never waits on a dependency.
+func (w *Worker) Health() HealthReport {
+	report := HealthReport{
+		State:        w.State(),
+		Dependencies: w.monitor.snapshot(),
+	}
+	for name, status := range report.Dependencies {
+		if breaker := w.breakers[name]; breaker != nil {
+			breakerStatus := breaker.Status()
+			status.CircuitBreaker = &breakerStatus
+			report.Dependencies[name] = status
+		}
+	}
+
+	// The connections may still be set up by Start until it is running
+	if report.State == StateRunning {
+		report.DatabasePool = w.databasePoolStats()
+		report.RedisPool = w.redisPoolStats()
+	}
+
+	report.Healthy = report.State == StateRunning
+	for _, status := range report.Dependencies {
+		if !status.Healthy {
+			report.Healthy = false
+		}
+	}
+	return report
+}
+
+// databasePoolStats reads the database pool statistics, nil without a database
+func (w *Worker) databasePoolStats() *DatabasePoolStats {
+	if w.sink == nil {
+		return nil
+	}
+	stats := w.sink.Stats()
+	return &DatabasePoolStats{
+		MaxOpenConnections: stats.MaxOpenConnections,
+		OpenConnections:    stats.OpenConnections,
+		InUse:              stats.InUse,
+		Idle:               stats.Idle,
+		WaitCount:          stats.WaitCount,
+		WaitSeconds:        stats.WaitDuration.Seconds(),
+		MaxIdleClosed:      stats.MaxIdleClosed,
+		MaxLifetimeClosed:  stats.MaxLifetimeClosed,
+	}
+}
+
+// redisPoolStats reads the Redis pool statistics, nil without Redis
+func (w *Worker) redisPoolStats() *RedisPoolStats {
+	if w.redisClient == nil {
+		return nil
+	}
+	stats := w.redisClient.PoolStats()
+	return &RedisPoolStats{
+		Hits:       stats.Hits,
+		Misses:     stats.Misses,
+		Timeouts:   stats.Timeouts,
+		TotalConns: stats.TotalConns,
+		IdleConns:  stats.IdleConns,
+		StaleConns: stats.StaleConns,
+	}
+}
@@ -0,0 +1,172 @@
+package processor
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+
+	"worker/internal/config"
+	"worker/internal/store"
+)
+
+// reasonPollClosed rejects votes for a poll that has been closed
+const reasonPollClosed = "poll_closed"
+
+// otherPollLabel is the metric label shared by polls the worker does not know
+const otherPollLabel = "other"
+
+// pollRefreshInterval is how often poll states are re-read so that changes
+// made through another replica take effect
+const pollRefreshInterval = 15 * time.Second
+
+// ErrUnknownPoll is returned when changing the state of a poll that is not on the ballot
+var ErrUnknownPoll = errors.New("unknown poll")
+
+// pollRegistry caches which polls are closed. Polls without a row in the
+// polls table are open.
+type pollRegistry struct {
+	// open exports whether each poll accepts votes
+	open   *prometheus.GaugeVec
+	mu     sync.RWMutex
+	closed map[string]bool
+	known  map[string]bool
+}
+
+// isClosed reports whether votes for a poll must be rejected
+func (r *pollRegistry) isClosed(pollID string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.closed[pollID]
+}
+
+// isKnown reports whether a poll has a row in the polls table
+func (r *pollRegistry) isKnown(pollID string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.known[pollID]
+}
+
+// set replaces the cached poll states
+func (r *pollRegistry) set(states []store.PollState) {
+	closed := make(map[string]bool, len(states))
+	known := make(map[string]bool, len(states))
+	for _, state := range states {
+		known[state.PollID] = true
+		if state.Status == store.PollClosed {
+			closed[state.PollID] = true
+		}
+		r.open.WithLabelValues(state.PollID).Set(boolToFloat(state.Status == store.PollOpen))
+	}
+
+	r.mu.Lock()
+	r.closed = closed
+	r.known = known
+	r.mu.Unlock()
+}
+
+// pollLabel names a poll in metric labels. Without a ballot poll_id is
+// whatever clients send, so polls that are not DEFAULT_POLL, on the ballot or
+// in the polls table share one label to keep the number of series bounded.
+func (w *Worker) pollLabel(settings *config.Config, ballot *Ballot, pollID string) string {
+	if pollID == settings.DefaultPoll || (ballot != nil && ballot.HasPoll(pollID)) || w.polls.isKnown(pollID) {
+		return pollID
+	}
+	return otherPollLabel
+}
+
+// checkPoll rejects votes for closed polls
+func (w *Worker) checkPoll(pollID string) error {
+	if w.polls.isClosed(pollID) {
+		return &rejectionError{reason: reasonPollClosed, err: fmt.Errorf("poll %q is closed", pollID)}
+	}
+	return nil
+}
+
+// refreshPolls reloads the poll state cache
+func (w *Worker) refreshPolls() error {
+	states, err := w.sink.PollStates()
+	if err != nil {
+		return err
+	}
+	w.polls.set(states)
+	return nil
+}
+
+// startPollRefresher keeps the poll state cache in step with the database
+func (w *Worker) startPollRefresher() {
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+
+		ticker := time.NewTicker(pollRefreshInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-w.ctx.Done():
+				return
+			case <-ticker.C:
+				if err := w.refreshPolls(); err != nil {
+					w.metrics.DBErrors.Inc()
+					w.logger.WithError(err).Warn("Failed to refresh poll states")
+				}
+			}
+		}
+	}()
+}
+
+// Polls lists known polls and their state. Polls from the ballot that were
+// never opened or closed explicitly are open.
+func (w *Worker) Polls() ([]store.PollState, error) {
+	states, err := w.sink.PollStates()
+	if err != nil {
+		w.metrics.DBErrors.Inc()
+		return nil, err
+	}
+
+	known := make(map[string]bool, len(states))
+	for _, state := range states {
+		known[state.PollID] = true
+	}
+	for _, pollID := range w.currentBallot().PollIDs() {
+		if !known[pollID] {
+			states = append(states, store.PollState{PollID: pollID, Status: store.PollOpen})
+		}
+	}
+	sort.Slice(states, func(i, j int) bool { return states[i].PollID < states[j].PollID })
+	return states, nil
+}
+
+// SetPollStatus opens or closes a poll, returning ErrUnknownPoll for polls
+// that are not on the ballot
+func (w *Worker) SetPollStatus(pollID, status string) error {
+	if !w.currentBallot().HasPoll(pollID) {
+		return fmt.Errorf("%w %q", ErrUnknownPoll, pollID)
+	}
+
+	if err := w.sink.SetPollStatus(pollID, status); err != nil {
+		w.metrics.DBErrors.Inc()
+		return err
+	}
+	if err := w.refreshPolls(); err != nil {
+		return err
+	}
+
+	w.logger.WithFields(logrus.Fields{
+		"poll_id": pollID,
+		"status":  status,
+	}).Info("Poll status changed")
+	return nil
+}
+
+func boolToFloat(value bool) float64 {
+	if value {
+		return 1
+	}
+	return 0
+}
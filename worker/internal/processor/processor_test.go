@@ -0,0 +1,1027 @@
+package processor
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	"worker/internal/config"
+	"worker/internal/queue"
+	"worker/internal/resilience"
+	"worker/internal/store"
+)
+
+// newTestWorker creates a worker consuming from an in-memory queue and
+// storing votes in a fresh SQLite database
+func newTestWorker(t *testing.T) (*Worker, *queue.Memory) {
+	t.Helper()
+
+	cfg := &config.Config{
+		VoteQueue:           "votes",
+		DeadLetterQueue:     "votes:dlq",
+		MaxRetries:          3,
+		BatchSize:           10,
+		BatchFlushInterval:  10 * time.Millisecond,
+		Concurrency:         1,
+		VotePolicy:          store.PolicyAppend,
+		DefaultPoll:         "default",
+		DBDriver:            store.DriverSQLite,
+		SQLitePath:          filepath.Join(t.TempDir(), "voting.db"),
+		HealthCheckTimeout:  time.Second,
+		HealthCheckInterval: time.Second,
+		StallTimeout:        time.Minute,
+		RetryInitialDelay:   10 * time.Millisecond,
+		RetryMaxDelay:       100 * time.Millisecond,
+		BreakerThreshold:    2,
+		BreakerCooldown:     50 * time.Millisecond,
+		StartupTimeout:      time.Second,
+		ShutdownTimeout:     time.Second,
+	}
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	sink, err := store.NewSQLiteSink(cfg, logger)
+	require.NoError(t, err)
+	source := queue.NewMemory()
+
+	w := New(cfg, logger, Dependencies{Queue: source, Sink: sink})
+	t.Cleanup(w.Stop)
+	require.NoError(t, w.connectDB(context.Background()))
+	require.NoError(t, w.initDB())
+
+	return w, source
+}
+
+// receive takes the next batch from the queue, returning when it was asked for
+func receive(t *testing.T, w *Worker) ([]queue.Delivery, time.Time) {
+	t.Helper()
+
+	dequeued := time.Now()
+	batch, err := w.queue.Receive(w.ctx, 0, queue.BatchLimits{
+		Size: w.settings().BatchSize,
+		Wait: w.settings().BatchFlushInterval,
+	})
+	require.NoError(t, err)
+	return batch, dequeued
+}
+
+// deadLetters decodes every entry of the dead-letter queue, newest first
+func deadLetters(t *testing.T, source *queue.Memory) []queue.DeadLetter {
+	t.Helper()
+
+	raw, err := source.DeadLetters(context.Background(), 100)
+	require.NoError(t, err)
+
+	entries := make([]queue.DeadLetter, 0, len(raw))
+	for _, item := range raw {
+		var entry queue.DeadLetter
+		require.NoError(t, json.Unmarshal([]byte(item), &entry))
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// storedVotes returns the stored choice of every vote_id
+func storedVotes(t *testing.T, w *Worker) map[string]string {
+	t.Helper()
+
+	db, err := sql.Open("sqlite", w.config.SQLitePath)
+	require.NoError(t, err)
+	defer db.Close()
+
+	rows, err := db.Query("SELECT vote_id, vote FROM votes")
+	require.NoError(t, err)
+	defer rows.Close()
+
+	votes := make(map[string]string)
+	for rows.Next() {
+		var voteID, vote string
+		require.NoError(t, rows.Scan(&voteID, &vote))
+		votes[voteID] = vote
+	}
+	require.NoError(t, rows.Err())
+	return votes
+}
+
+func TestProcessBatchStoresVotes(t *testing.T) {
+	w, source := newTestWorker(t)
+
+	source.Push(
+		`{"vote_id": "v1", "vote": "cats", "voter_id": "user1", "timestamp": "2023-01-01T12:00:00Z"}`,
+		`{"vote_id": "v2", "vote": "dogs", "voter_id": "user2", "timestamp": "2023-01-01T12:00:01Z"}`,
+		`{"vote_id": "v1", "vote": "cats", "voter_id": "user1", "timestamp": "2023-01-01T12:00:00Z"}`,
+	)
+	w.processBatch(receive(t, w))
+
+	assert.Equal(t, map[string]string{"v1": "cats", "v2": "dogs"}, storedVotes(t, w))
+	assert.Equal(t, 0, source.InFlight())
+	assert.Empty(t, deadLetters(t, source))
+
+	// A redelivered vote is acknowledged without being stored twice
+	source.Push(`{"vote_id": "v2", "vote": "dogs", "voter_id": "user2", "timestamp": "2023-01-01T12:00:01Z"}`)
+	w.processBatch(receive(t, w))
+	assert.Len(t, storedVotes(t, w), 2)
+	assert.Equal(t, 0, source.InFlight())
+
+	tallies, err := w.sink.Tallies()
+	require.NoError(t, err)
+	assert.Equal(t, map[store.TallyKey]int64{
+		{Poll: "default", Vote: "cats"}: 1,
+		{Poll: "default", Vote: "dogs"}: 1,
+	}, tallies)
+}
+
+func TestRedisTallyMirrorKeepsNewestCounts(t *testing.T) {
+	w, _ := newTestWorker(t)
+	server := miniredis.RunT(t)
+	w.redisClient = redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { w.redisClient.Close() })
+	w.config.TallyRedisHash = "tallies"
+
+	at := time.Date(2023, 1, 1, 12, 0, 0, 0, time.UTC)
+	insert := func(voteID string) *store.BatchResult {
+		result, err := w.sink.InsertBatch(context.Background(), []store.Vote{
+			{VoteID: voteID, PollID: "pets", Choice: "cats", VoterID: voteID, Timestamp: at},
+		})
+		require.NoError(t, err)
+		return result
+	}
+	first := insert("v1")
+	second := insert("v2")
+	server.HSet("tallies:pets", "cats", "7")
+
+	// The repair runs between the second batch's commit and its mirror
+	// update, which then arrives after the repair already counted it; the
+	// first batch's update arrives last of all
+	require.NoError(t, w.repairRedisTallies())
+	assert.Equal(t, "2", server.HGet("tallies:pets", "cats"))
+	w.updateRedisTallies(second.Counts)
+	w.updateRedisTallies(first.Counts)
+	assert.Equal(t, "2", server.HGet("tallies:pets", "cats"))
+
+	third := insert("v3")
+	w.updateRedisTallies(third.Counts)
+	assert.Equal(t, "3", server.HGet("tallies:pets", "cats"))
+}
+
+func TestDerivedVoteIDsDifferAcrossPolls(t *testing.T) {
+	w, source := newTestWorker(t)
+
+	// The same voter picks "yes" in two polls at the same instant; the
+	// second vote goes to the default poll
+	source.Push(
+		`{"poll_id": "budget", "vote": "yes", "voter_id": "user1", "timestamp": "2023-01-01T12:00:00Z"}`,
+		`{"vote": "yes", "voter_id": "user1", "timestamp": "2023-01-01T12:00:00Z"}`,
+	)
+	w.processBatch(receive(t, w))
+
+	stored := storedVotes(t, w)
+	assert.Len(t, stored, 2)
+
+	// A redelivery still maps to the row already stored
+	source.Push(`{"poll_id": "budget", "vote": "yes", "voter_id": "user1", "timestamp": "2023-01-01T12:00:00Z"}`)
+	w.processBatch(receive(t, w))
+	assert.Equal(t, stored, storedVotes(t, w))
+}
+
+func TestVotingPolicies(t *testing.T) {
+	votes := []string{
+		`{"vote_id": "v1", "vote": "cats", "voter_id": "user1", "timestamp": "2023-01-01T12:00:00Z"}`,
+		`{"vote_id": "v2", "vote": "dogs", "voter_id": "user1", "timestamp": "2023-01-01T12:00:05Z"}`,
+		`{"vote_id": "v3", "vote": "birds", "voter_id": "user1", "timestamp": "2023-01-01T12:00:02Z"}`,
+	}
+
+	tests := []struct {
+		policy   string
+		expected map[string]string
+	}{
+		{policy: store.PolicyAppend, expected: map[string]string{"v1": "cats", "v2": "dogs", "v3": "birds"}},
+		{policy: store.PolicyFirstWins, expected: map[string]string{"v1": "cats"}},
+		{policy: store.PolicyLastWins, expected: map[string]string{"v2": "dogs"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			w, source := newTestWorker(t)
+			w.config.VotePolicy = tt.policy
+
+			// One batch per vote, so earlier votes are already stored
+			for _, vote := range votes {
+				source.Push(vote)
+				w.processBatch(receive(t, w))
+			}
+
+			assert.Equal(t, tt.expected, storedVotes(t, w))
+			assert.Equal(t, 0, source.InFlight())
+		})
+	}
+}
+
+// recordingSink records the size of every batch inserted
+type recordingSink struct {
+	store.VoteSink
+	mu      sync.Mutex
+	batches []int
+}
+
+func (s *recordingSink) InsertBatch(ctx context.Context, votes []store.Vote) (*store.BatchResult, error) {
+	s.mu.Lock()
+	s.batches = append(s.batches, len(votes))
+	s.mu.Unlock()
+	return s.VoteSink.InsertBatch(ctx, votes)
+}
+
+func (s *recordingSink) sizes() []int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]int(nil), s.batches...)
+}
+
+func TestConsumerInsertsVotesInBatches(t *testing.T) {
+	w, source := newTestWorker(t)
+	w.config.BatchSize = 2
+	sink := &recordingSink{VoteSink: w.sink}
+	w.sink = sink
+
+	for i := 1; i <= 5; i++ {
+		source.Push(fmt.Sprintf(`{"vote_id": "v%d", "vote": "cats", "voter_id": "user%d"}`, i, i))
+	}
+	require.NoError(t, w.Start())
+
+	// Full batches are written as soon as they are taken, the rest once
+	// the flush interval passes
+	require.Eventually(t, func() bool { return len(storedVotes(t, w)) == 5 }, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, []int{2, 2, 1}, sink.sizes())
+	assert.Equal(t, 0, source.InFlight())
+}
+
+// poisonSink fails every batch that contains the vote with vote_id "bad",
+// like a database refusing one row of a multi-row insert
+type poisonSink struct {
+	store.VoteSink
+}
+
+func (s poisonSink) InsertBatch(ctx context.Context, votes []store.Vote) (*store.BatchResult, error) {
+	for _, vote := range votes {
+		if vote.VoteID == "bad" {
+			return nil, errors.New("value too long for column")
+		}
+	}
+	return s.VoteSink.InsertBatch(ctx, votes)
+}
+
+func TestFailingVoteDoesNotHoldBackItsBatch(t *testing.T) {
+	w, source := newTestWorker(t)
+	w.sink = poisonSink{VoteSink: w.sink}
+
+	// Only the failing vote is charged a retry each time; the others are
+	// stored the first time round
+	for attempt := 1; attempt <= w.config.MaxRetries; attempt++ {
+		for i := 1; i <= 3; i++ {
+			source.Push(fmt.Sprintf(`{"vote_id": "v%d-%d", "vote": "cats", "voter_id": "user%d-%d"}`, attempt, i, attempt, i))
+		}
+		if attempt == 1 {
+			source.Push(`{"vote_id": "bad", "vote": "dogs", "voter_id": "user0"}`)
+		}
+		assert.Error(t, w.processBatch(receive(t, w)))
+		assert.Len(t, storedVotes(t, w), 3*attempt)
+	}
+
+	assert.Equal(t, 0, source.Len())
+	assert.Equal(t, 0, source.InFlight())
+	entries := deadLetters(t, source)
+	require.Len(t, entries, 1)
+	assert.Equal(t, `{"vote_id": "bad", "vote": "dogs", "voter_id": "user0"}`, entries[0].Payload)
+	assert.Equal(t, reasonRetriesExhausted, entries[0].Reason)
+	assert.Equal(t, w.config.MaxRetries, entries[0].Attempts)
+	assert.Equal(t, resilience.Closed, w.breakers[dependencyDatabase].State())
+}
+
+func TestConsumerPoolStoresEveryVoteOnce(t *testing.T) {
+	w, source := newTestWorker(t)
+	w.config.Concurrency = 4
+	w.config.BatchSize = 1
+	hook := test.NewLocal(w.logger)
+
+	for i := 1; i <= 8; i++ {
+		source.Push(fmt.Sprintf(`{"vote_id": "v%d", "vote": "cats", "voter_id": "user%d"}`, i, i))
+	}
+	require.NoError(t, w.Start())
+
+	// Consumers share the queue and the database, and store every vote once
+	require.Eventually(t, func() bool { return len(storedVotes(t, w)) == 8 }, 5*time.Second, 10*time.Millisecond)
+	require.Eventually(t, func() bool { return source.InFlight() == 0 }, time.Second, 10*time.Millisecond)
+	assert.Equal(t, 0, source.Len())
+
+	consumers := make(map[interface{}]bool)
+	for _, entry := range hook.AllEntries() {
+		if entry.Message == "Starting vote processing" {
+			consumers[entry.Data["consumer"]] = true
+		}
+	}
+	assert.Len(t, consumers, 4)
+}
+
+func TestVoteEventsKeepLatestTallyVersion(t *testing.T) {
+	var events eventPublisher
+	now := time.Now()
+
+	// A consumer that committed later may offer its event first, stamped earlier
+	events.offer([]VoteEvent{{PollID: "pets", Choice: "cats", Tally: 5, Version: 7, ProcessedAt: now}})
+	events.offer([]VoteEvent{{PollID: "pets", Choice: "cats", Tally: 4, Version: 6, ProcessedAt: now.Add(time.Millisecond)}})
+	events.offer([]VoteEvent{{PollID: "pets", Choice: "dogs", Tally: 1, Version: 0, ProcessedAt: now}})
+
+	published := make(map[string]int64)
+	for _, event := range events.take() {
+		published[event.Choice] = event.Tally
+	}
+	assert.Equal(t, map[string]int64{"cats": 5, "dogs": 1}, published)
+	assert.Empty(t, events.take())
+}
+
+func TestVoteEventsCoalesceBatchesPerOption(t *testing.T) {
+	w, source := newTestWorker(t)
+	server := miniredis.RunT(t)
+	w.redisClient = redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { w.redisClient.Close() })
+	w.config.EventsChannel = "votes:events"
+
+	ctx := context.Background()
+	subscriber := w.redisClient.Subscribe(ctx, w.config.EventsChannel)
+	defer subscriber.Close()
+	_, err := subscriber.Receive(ctx)
+	require.NoError(t, err)
+
+	// Two batches between flushes, the first with several votes per option
+	source.Push(
+		`{"vote_id": "v1", "poll_id": "pets", "vote": "cats", "voter_id": "user1"}`,
+		`{"vote_id": "v2", "poll_id": "pets", "vote": "cats", "voter_id": "user2"}`,
+		`{"vote_id": "v3", "poll_id": "pets", "vote": "dogs", "voter_id": "user3"}`,
+		`{"vote_id": "v4", "poll_id": "pets", "vote": "cats", "voter_id": "user4"}`,
+	)
+	require.NoError(t, w.processBatch(receive(t, w)))
+	source.Push(`{"vote_id": "v5", "poll_id": "pets", "vote": "cats", "voter_id": "user5"}`)
+	require.NoError(t, w.processBatch(receive(t, w)))
+	w.flushVoteEvents(ctx)
+
+	counts, err := w.sink.TallyCounts()
+	require.NoError(t, err)
+
+	// One event per option, carrying its latest tally and version
+	published := make(map[string]VoteEvent)
+	messages := subscriber.Channel()
+	for len(published) < 2 {
+		select {
+		case message := <-messages:
+			var event VoteEvent
+			require.NoError(t, json.Unmarshal([]byte(message.Payload), &event))
+			assert.NotContains(t, published, event.Choice, "option published twice")
+			published[event.Choice] = event
+		case <-time.After(time.Second):
+			t.Fatalf("received %d of 2 events", len(published))
+		}
+	}
+	select {
+	case message := <-messages:
+		t.Fatalf("unexpected event %s", message.Payload)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	for choice, tally := range map[string]int64{"cats": 4, "dogs": 1} {
+		count := counts[store.TallyKey{Poll: "pets", Vote: choice}]
+		assert.Equal(t, tally, count.Count)
+		assert.Equal(t, tally, published[choice].Tally)
+		assert.Equal(t, count.Version, published[choice].Version)
+	}
+}
+
+func TestProcessBatchDeadLettersInvalidPayloads(t *testing.T) {
+	w, source := newTestWorker(t)
+
+	source.Push(
+		`not json`,
+		`{"vote": "", "voter_id": "user1"}`,
+		`{"vote": "cats"}`,
+		`{"vote": "much-too-long-choice", "voter_id": "user2"}`,
+	)
+	w.processBatch(receive(t, w))
+
+	entries := deadLetters(t, source)
+	require.Len(t, entries, 4)
+	assert.Equal(t, reasonValidation, entries[0].Reason)
+	assert.Equal(t, reasonValidation, entries[1].Reason)
+	assert.Equal(t, reasonValidation, entries[2].Reason)
+	assert.Equal(t, reasonDecode, entries[3].Reason)
+	assert.Equal(t, `not json`, entries[3].Payload)
+	assert.Equal(t, 1, entries[3].Attempts)
+
+	assert.Equal(t, 0, source.Len())
+	assert.Equal(t, 0, source.InFlight())
+}
+
+func TestProcessBatchRejectsBallotAndClosedPoll(t *testing.T) {
+	w, source := newTestWorker(t)
+
+	ballot, err := NewBallot([]PollDefinition{
+		{ID: "pets", Options: []string{"cats", "dogs"}},
+		{ID: "food", Options: []string{"pizza", "tacos"}},
+	})
+	require.NoError(t, err)
+	w.live.set(w.config, ballot)
+	w.polls.set([]store.PollState{{PollID: "food", Status: store.PollClosed}})
+
+	source.Push(
+		`{"poll_id": "pets", "vote": "birds", "voter_id": "user1"}`,
+		`{"poll_id": "movies", "vote": "cats", "voter_id": "user2"}`,
+		`{"poll_id": "food", "vote": "pizza", "voter_id": "user3"}`,
+	)
+	w.processBatch(receive(t, w))
+
+	entries := deadLetters(t, source)
+	require.Len(t, entries, 3)
+	assert.Equal(t, reasonPollClosed, entries[0].Reason)
+	assert.Equal(t, reasonUnknownPoll, entries[1].Reason)
+	assert.Equal(t, reasonInvalidOption, entries[2].Reason)
+	assert.Equal(t, 0, source.InFlight())
+}
+
+func TestNewBallotRejectsInvalidDefinitions(t *testing.T) {
+	for _, definitions := range [][]PollDefinition{
+		{{Options: []string{"cats"}}},
+		{{ID: "pets"}},
+		{{ID: "pets", Options: []string{"cats", ""}}},
+		{{ID: "pets", Options: []string{"much-too-long"}}},
+		{{ID: "pets", Options: []string{"cats"}}, {ID: "pets", Options: []string{"dogs"}}},
+	} {
+		_, err := NewBallot(definitions)
+		assert.Error(t, err, "%+v", definitions)
+	}
+}
+
+func TestProcessBatchValidatesBallotOptions(t *testing.T) {
+	tests := []struct {
+		source string
+		setup  func(t *testing.T, w *Worker)
+	}{
+		{source: ballotSourceFile, setup: func(t *testing.T, w *Worker) {
+			w.config.BallotFile = filepath.Join(t.TempDir(), "ballot.yaml")
+			require.NoError(t, os.WriteFile(w.config.BallotFile, []byte("polls:\n  - id: default\n    options: [cats, dogs]\n  - id: languages\n    options: [go, python]\n"), 0o600))
+		}},
+		{source: ballotSourceDatabase, setup: func(t *testing.T, w *Worker) {
+			db, err := sql.Open("sqlite", w.config.SQLitePath)
+			require.NoError(t, err)
+			defer db.Close()
+			_, err = db.Exec("INSERT INTO ballot_options (poll_id, option_value) VALUES ('default', 'cats'), ('default', 'dogs'), ('languages', 'go'), ('languages', 'python')")
+			require.NoError(t, err)
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.source, func(t *testing.T) {
+			w, source := newTestWorker(t)
+			w.config.BallotSource = tt.source
+			tt.setup(t, w)
+			ballot, err := w.loadBallot(w.config)
+			require.NoError(t, err)
+			w.live.set(w.config, ballot)
+
+			source.Push(
+				`{"vote_id": "v1", "vote": "cats", "voter_id": "user1"}`,
+				`{"vote_id": "v2", "poll_id": "languages", "vote": "go", "voter_id": "user1"}`,
+				`{"vote_id": "v3", "vote": "go", "voter_id": "user2"}`,
+				`{"vote_id": "v4", "poll_id": "movies", "vote": "cats", "voter_id": "user3"}`,
+			)
+			require.NoError(t, w.processBatch(receive(t, w)))
+
+			// Votes without a poll_id are checked against DEFAULT_POLL
+			assert.Equal(t, map[string]string{"v1": "cats", "v2": "go"}, storedVotes(t, w))
+
+			entries := deadLetters(t, source)
+			require.Len(t, entries, 2)
+			assert.Equal(t, reasonUnknownPoll, entries[0].Reason)
+			assert.Equal(t, `unknown poll "movies"`, entries[0].Error)
+			assert.Equal(t, reasonInvalidOption, entries[1].Reason)
+			assert.Equal(t, `"go" is not an option of poll "default"`, entries[1].Error)
+			assert.Equal(t, 1, entries[1].Attempts)
+			assert.Equal(t, 0, source.InFlight())
+		})
+	}
+}
+
+func TestVotesProcessedLabelsOnlyKnownPolls(t *testing.T) {
+	w, source := newTestWorker(t)
+	require.NoError(t, w.SetPollStatus("pets", store.PollOpen))
+
+	// Without a ballot any poll_id is accepted, but only DEFAULT_POLL and
+	// polls in the polls table get a label of their own
+	source.Push(
+		`{"vote_id": "v1", "vote": "cats", "voter_id": "user1"}`,
+		`{"vote_id": "v2", "poll_id": "pets", "vote": "cats", "voter_id": "user1"}`,
+		`{"vote_id": "v3", "poll_id": "random-1", "vote": "cats", "voter_id": "user1"}`,
+		`{"vote_id": "v4", "poll_id": "random-2", "vote": "cats", "voter_id": "user1"}`,
+	)
+	require.NoError(t, w.processBatch(receive(t, w)))
+	require.Len(t, storedVotes(t, w), 4)
+
+	assert.Equal(t, 3, testutil.CollectAndCount(w.metrics.VotesProcessed))
+	assert.Equal(t, 1.0, testutil.ToFloat64(w.metrics.VotesProcessed.WithLabelValues("default", "cats")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(w.metrics.VotesProcessed.WithLabelValues("pets", "cats")))
+	assert.Equal(t, 2.0, testutil.ToFloat64(w.metrics.VotesProcessed.WithLabelValues(otherPollLabel, "cats")))
+
+	// Polls on the ballot are labelled too
+	ballot, err := NewBallot([]PollDefinition{{ID: "languages", Options: []string{"go"}}})
+	require.NoError(t, err)
+	w.live.set(w.config, ballot)
+	source.Push(`{"vote_id": "v5", "poll_id": "languages", "vote": "go", "voter_id": "user1"}`)
+	require.NoError(t, w.processBatch(receive(t, w)))
+	assert.Equal(t, 1.0, testutil.ToFloat64(w.metrics.VotesProcessed.WithLabelValues("languages", "go")))
+}
+
+func TestProcessBatchRetriesThenDeadLetters(t *testing.T) {
+	w, source := newTestWorker(t)
+
+	payload := `{"vote": "cats", "voter_id": "user1", "timestamp": "2023-01-01T12:00:00Z"}`
+	source.Push(payload)
+
+	// Every insert fails once the database is gone, so the vote is requeued
+	// until MAX_RETRIES is reached
+	require.NoError(t, w.sink.Close())
+	for attempt := 1; attempt < w.config.MaxRetries; attempt++ {
+		w.processBatch(receive(t, w))
+		assert.Equal(t, 1, source.Len(), "attempt %d should requeue the vote", attempt)
+		assert.Empty(t, deadLetters(t, source))
+	}
+
+	w.processBatch(receive(t, w))
+	assert.Equal(t, 0, source.Len())
+	assert.Equal(t, 0, source.InFlight())
+
+	entries := deadLetters(t, source)
+	require.Len(t, entries, 1)
+	assert.Equal(t, reasonRetriesExhausted, entries[0].Reason)
+	assert.Equal(t, payload, entries[0].Payload)
+	assert.Equal(t, w.config.MaxRetries, entries[0].Attempts)
+	assert.NotEmpty(t, entries[0].Error)
+	assert.False(t, entries[0].FirstFailure.IsZero())
+	assert.False(t, entries[0].LastFailure.Before(entries[0].FirstFailure))
+}
+
+// replayingQueue hands every replayed vote straight back to the worker, as a
+// running consumer would while a replay is in progress
+type replayingQueue struct {
+	*queue.Memory
+	replayed func()
+}
+
+func (q replayingQueue) ReplayDeadLetter(ctx context.Context) error {
+	if err := q.Memory.ReplayDeadLetter(ctx); err != nil {
+		return err
+	}
+	q.replayed()
+	return nil
+}
+
+func TestReplayDeadLettersStopsAtInitialSize(t *testing.T) {
+	w, source := newTestWorker(t)
+
+	source.Push(
+		`{"vote": "cats", "voter_id": ""}`,
+		`{"vote": "", "voter_id": "user2"}`,
+	)
+	w.processBatch(receive(t, w))
+	require.Len(t, deadLetters(t, source), 2)
+
+	// Replayed votes fail validation again and land back in the dead-letter
+	// queue, which never empties; the replay still stops after two
+	w.queue = replayingQueue{Memory: source, replayed: func() { w.processBatch(receive(t, w)) }}
+	replayed, err := w.ReplayDeadLetters(context.Background(), 0)
+	require.NoError(t, err)
+	assert.Equal(t, 2, replayed)
+	assert.Len(t, deadLetters(t, source), 2)
+	assert.Equal(t, 0, source.Len())
+
+	replayed, err = w.ReplayDeadLetters(context.Background(), 1)
+	require.NoError(t, err)
+	assert.Equal(t, 1, replayed)
+	assert.Len(t, deadLetters(t, source), 2)
+	assert.Equal(t, 2.0, testutil.ToFloat64(w.metrics.DLQSize))
+}
+
+func TestReloadAppliesSafeSettings(t *testing.T) {
+	w, source := newTestWorker(t)
+
+	ballotFile := filepath.Join(t.TempDir(), "ballot.yaml")
+	require.NoError(t, os.WriteFile(ballotFile, []byte("polls:\n  - id: pets\n    options: [cats, dogs]\n"), 0o600))
+
+	next := *w.config
+	next.BatchSize = 1
+	next.DefaultPoll = "pets"
+	next.BallotSource = ballotSourceFile
+	next.BallotFile = ballotFile
+	next.LogLevel = "debug"
+	w.loadConfig = func() (*config.Config, error) { return &next, nil }
+
+	changes, err := w.Reload()
+	require.NoError(t, err)
+
+	keys := make([]string, 0, len(changes))
+	for _, change := range changes {
+		keys = append(keys, change.Key)
+	}
+	assert.Equal(t, []string{"BATCH_SIZE", "BALLOT_SOURCE", "BALLOT_FILE", "DEFAULT_POLL", "LOG_LEVEL"}, keys)
+	assert.Equal(t, logrus.DebugLevel, w.logger.GetLevel())
+
+	// Votes use the new default poll and ballot, one per batch
+	source.Push(`{"vote": "cats", "voter_id": "user1"}`, `{"vote": "birds", "voter_id": "user2"}`)
+	batch, dequeued := receive(t, w)
+	require.Len(t, batch, 1)
+	w.processBatch(batch, dequeued)
+	w.processBatch(receive(t, w))
+
+	stored := storedVotes(t, w)
+	require.Len(t, stored, 1)
+	for _, vote := range stored {
+		assert.Equal(t, "cats", vote)
+	}
+	entries := deadLetters(t, source)
+	require.Len(t, entries, 1)
+	assert.Equal(t, reasonInvalidOption, entries[0].Reason)
+}
+
+func TestReloadRejectsConnectionSettings(t *testing.T) {
+	w, _ := newTestWorker(t)
+
+	next := *w.config
+	next.BatchSize = 50
+	next.SQLitePath = filepath.Join(t.TempDir(), "other.db")
+	w.loadConfig = func() (*config.Config, error) { return &next, nil }
+
+	changes, err := w.Reload()
+	require.ErrorIs(t, err, ErrReloadRejected)
+	assert.ErrorContains(t, err, "SQLITE_PATH")
+	assert.Len(t, changes, 2)
+	assert.Equal(t, 10, w.settings().BatchSize, "a rejected reload applies nothing")
+}
+
+func TestCheckDependenciesTimesOut(t *testing.T) {
+	release := make(chan struct{})
+	t.Cleanup(func() { close(release) })
+	hung := HealthCheck{Name: "hung", Check: func(ctx context.Context) error {
+		<-release // ignores its context
+		return nil
+	}}
+
+	start := time.Now()
+	result := runCheck(context.Background(), hung, 20*time.Millisecond)
+	assert.Less(t, time.Since(start), time.Second)
+	assert.ErrorContains(t, result.Err, "hung check timed out")
+}
+
+func TestLivenessDetectsStalledConsumer(t *testing.T) {
+	w, _ := newTestWorker(t)
+	w.lastIteration = make([]atomic.Int64, 2)
+	w.markIteration(0)
+	w.markIteration(1)
+	w.state.Store(StateRunning)
+	assert.NoError(t, w.Liveness())
+
+	w.lastIteration[1].Store(time.Now().Add(-2 * w.config.StallTimeout).UnixNano())
+	assert.ErrorContains(t, w.Liveness(), "consumer 1 stalled")
+}
+
+func TestHealthMonitorCountsConsecutiveFailures(t *testing.T) {
+	w, _ := newTestWorker(t)
+	ctx := context.Background()
+
+	w.checkHealth(ctx)
+	database := w.Health().Dependencies["database"]
+	assert.True(t, database.Healthy)
+	require.NotNil(t, database.LastSuccess)
+	lastSuccess := *database.LastSuccess
+
+	require.NoError(t, w.sink.Close())
+	w.checkHealth(ctx)
+	w.checkHealth(ctx)
+
+	report := w.Health()
+	assert.False(t, report.Healthy)
+	database = report.Dependencies["database"]
+	assert.False(t, database.Healthy)
+	assert.NotEmpty(t, database.Error)
+	assert.Equal(t, 2, database.ConsecutiveFailures)
+	assert.Equal(t, lastSuccess, *database.LastSuccess)
+}
+
+func TestDatabaseBreakerStopsConsumption(t *testing.T) {
+	w, source := newTestWorker(t)
+	source.Push(`{"vote": "cats", "voter_id": "user1"}`)
+
+	// Failed inserts open the breaker once the threshold is reached
+	require.NoError(t, w.sink.Close())
+	for i := 0; i < w.config.BreakerThreshold; i++ {
+		assert.Error(t, w.processBatch(receive(t, w)))
+	}
+	breaker := w.breakers[dependencyDatabase]
+	assert.Equal(t, resilience.Open, breaker.State())
+	assert.False(t, w.dependenciesAvailable(context.Background()))
+	w.checkHealth(context.Background())
+	assert.Equal(t, "open", w.Health().Dependencies["database"].CircuitBreaker.State)
+
+	// After the cooldown the database is probed; it is still down, so the
+	// breaker reopens and the requeued vote stays on the queue
+	time.Sleep(w.config.BreakerCooldown)
+	assert.False(t, w.dependenciesAvailable(context.Background()))
+	assert.Equal(t, resilience.Open, breaker.State())
+	assert.Equal(t, 1, source.Len())
+}
+
+func TestWaitForRetriesUntilConnected(t *testing.T) {
+	w, _ := newTestWorker(t)
+
+	attempts := 0
+	connect := func(ctx context.Context) error {
+		attempts++
+		if attempts < 3 {
+			return errors.New("connection refused")
+		}
+		return nil
+	}
+	require.NoError(t, w.waitFor(context.Background(), dependencyDatabase, connect))
+	assert.Equal(t, 3, attempts)
+	assert.True(t, w.Health().Dependencies["database"].Healthy)
+
+	// Without success the wait gives up at the deadline, and the health
+	// report shows why the worker is still starting
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err := w.waitFor(ctx, dependencyDatabase, func(ctx context.Context) error { return errors.New("connection refused") })
+	assert.ErrorContains(t, err, "gave up waiting for database")
+
+	report := w.Health()
+	assert.Equal(t, StateStarting, report.State)
+	assert.Positive(t, report.Dependencies["database"].ConsecutiveFailures)
+	assert.Equal(t, "connection refused", report.Dependencies["database"].Error)
+}
+
+func TestStopAbortsStartupWait(t *testing.T) {
+	w, _ := newTestWorker(t)
+	w.config.StartupTimeout = time.Minute
+	require.NoError(t, w.sink.Close())
+	w.sink = nil
+	w.config.SQLitePath = filepath.Join(t.TempDir(), "missing", "voting.db")
+
+	started := make(chan error, 1)
+	go func() { started <- w.Start() }()
+
+	require.Eventually(t, func() bool {
+		return w.Health().Dependencies["database"].ConsecutiveFailures > 0
+	}, 5*time.Second, 10*time.Millisecond)
+	w.Stop()
+
+	select {
+	case err := <-started:
+		assert.ErrorContains(t, err, "gave up waiting for database")
+	case <-time.After(5 * time.Second):
+		t.Fatal("Start did not return after Stop")
+	}
+	assert.Equal(t, StateStopping, w.State())
+}
+
+// blockingSink holds every insert until released or aborted
+type blockingSink struct {
+	store.VoteSink
+	entered chan struct{}
+	release chan struct{}
+	// aborted counts the inserts given up because their context ended
+	aborted atomic.Int32
+}
+
+func (s *blockingSink) InsertBatch(ctx context.Context, votes []store.Vote) (*store.BatchResult, error) {
+	s.entered <- struct{}{}
+	select {
+	case <-s.release:
+	case <-ctx.Done():
+		s.aborted.Add(1)
+		return nil, ctx.Err()
+	}
+	return s.VoteSink.InsertBatch(ctx, votes)
+}
+
+// startBlocked starts the worker and waits until a consumer is inserting a vote
+func startBlocked(t *testing.T, w *Worker, source *queue.Memory) *blockingSink {
+	t.Helper()
+
+	sink := &blockingSink{VoteSink: w.sink, entered: make(chan struct{}, 1), release: make(chan struct{})}
+	w.sink = sink
+	source.Push(`{"vote_id": "v1", "vote": "cats", "voter_id": "user1"}`)
+	require.NoError(t, w.Start())
+
+	select {
+	case <-sink.entered:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the vote was not received")
+	}
+	return sink
+}
+
+func TestStopDrainsInFlightVotes(t *testing.T) {
+	w, source := newTestWorker(t)
+	sink := startBlocked(t, w, source)
+
+	stopped := make(chan struct{})
+	go func() {
+		w.Stop()
+		close(stopped)
+	}()
+
+	// The consumer finishes its batch before the connections close
+	require.Eventually(t, func() bool { return w.State() == StateStopping }, time.Second, time.Millisecond)
+	close(sink.release)
+	<-stopped
+
+	assert.Equal(t, 0, source.InFlight())
+	assert.Equal(t, 0, source.Len())
+	drained, requeued, pending := w.inFlight.summary()
+	assert.Equal(t, 1, drained)
+	assert.Zero(t, requeued)
+	assert.Zero(t, pending)
+}
+
+func TestStopRequeuesVotesAfterShutdownTimeout(t *testing.T) {
+	w, source := newTestWorker(t)
+	w.config.ShutdownTimeout = 50 * time.Millisecond
+	sink := startBlocked(t, w, source)
+
+	w.Stop()
+
+	// The insert was aborted and its consumer returned before the
+	// connections closed
+	assert.Equal(t, int32(1), sink.aborted.Load())
+	consumersDone := make(chan struct{})
+	go func() {
+		w.consumers.Wait()
+		close(consumersDone)
+	}()
+	select {
+	case <-consumersDone:
+	case <-time.After(100 * time.Millisecond):
+		t.Fatal("Stop returned while a consumer was still running")
+	}
+	assert.Empty(t, storedVotes(t, w))
+	assert.Equal(t, 1, source.Len(), "the stuck vote is back on the queue")
+	assert.Equal(t, 0, source.InFlight())
+	drained, requeued, pending := w.inFlight.summary()
+	assert.Zero(t, drained)
+	assert.Equal(t, 1, requeued)
+	assert.Zero(t, pending)
+}
+
+// failingAckQueue fails every acknowledgement
+type failingAckQueue struct {
+	queue.Source
+}
+
+func (q failingAckQueue) Ack(ctx context.Context, deliveries []queue.Delivery) error {
+	return errors.New("ack failed")
+}
+
+func TestStopCountsUnacknowledgedVotesAsPending(t *testing.T) {
+	w, source := newTestWorker(t)
+	w.queue = failingAckQueue{Source: source}
+	sink := startBlocked(t, w, source)
+
+	stopped := make(chan struct{})
+	go func() {
+		w.Stop()
+		close(stopped)
+	}()
+
+	// The vote is stored but stays in the processing list, where recovery
+	// finds it and deduplication skips it
+	require.Eventually(t, func() bool { return w.State() == StateStopping }, time.Second, time.Millisecond)
+	close(sink.release)
+	<-stopped
+
+	assert.Len(t, storedVotes(t, w), 1)
+	assert.Equal(t, 1, source.InFlight())
+	drained, requeued, pending := w.inFlight.summary()
+	assert.Zero(t, drained)
+	assert.Zero(t, requeued)
+	assert.Equal(t, 1, pending)
+}
+
+func TestWorkersHaveSeparateMetrics(t *testing.T) {
+	first, source := newTestWorker(t)
+	second, _ := newTestWorker(t)
+
+	timestamp := time.Now().Add(-time.Minute).UTC().Format(time.RFC3339)
+	source.Push(`{"vote": "cats", "voter_id": "user1", "timestamp": "` + timestamp + `"}`)
+	first.processBatch(receive(t, first))
+
+	assert.Equal(t, 1.0, testutil.ToFloat64(first.metrics.VotesProcessed.WithLabelValues("default", "cats")))
+	assert.Zero(t, testutil.ToFloat64(second.metrics.VotesProcessed.WithLabelValues("default", "cats")))
+
+	// The vote was a minute old when processed
+	families, err := first.metrics.Gatherer().Gather()
+	require.NoError(t, err)
+	ages := 0
+	for _, family := range families {
+		if family.GetName() == "vote_age_seconds" {
+			histogram := family.GetMetric()[0].GetHistogram()
+			ages = int(histogram.GetSampleCount())
+			assert.InDelta(t, 60, histogram.GetSampleSum(), 5)
+		}
+	}
+	assert.Equal(t, 1, ages)
+
+	source.Push(`{"vote": "dogs", "voter_id": "user2"}`, `{"vote": "dogs", "voter_id": "user3"}`)
+	first.sampleQueueLength(context.Background())
+	assert.Equal(t, 2.0, testutil.ToFloat64(first.metrics.QueueLength))
+}
+
+func TestProcessBatchContinuesVoteTraces(t *testing.T) {
+	w, source := newTestWorker(t)
+	recorder := tracetest.NewSpanRecorder()
+	w.tracer = sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)).Tracer(tracerName)
+
+	const (
+		traceID  = "4bf92f3577b34da6a3ce929d0e0e4736"
+		parentID = "00f067aa0ba902b7"
+	)
+	source.Push(
+		`{"vote": "cats", "voter_id": "user1", "traceparent": "00-`+traceID+`-`+parentID+`-01"}`,
+		`{"vote": "dogs"}`,
+	)
+	require.NoError(t, w.processBatch(receive(t, w)))
+
+	spans := make(map[string][]sdktrace.ReadOnlySpan)
+	for _, span := range recorder.Ended() {
+		spans[span.Name()] = append(spans[span.Name()], span)
+	}
+	require.Len(t, spans[spanVote], 2)
+	require.Len(t, spans[spanDequeue], 2)
+	require.Len(t, spans[spanDecode], 2)
+	require.Len(t, spans[spanValidate], 2)
+	require.Len(t, spans[spanInsert], 1, "the invalid vote never reaches the database")
+
+	// The stored vote continues the trace of its traceparent
+	stored := spans[spanInsert][0]
+	assert.Equal(t, traceID, stored.SpanContext().TraceID().String())
+	for _, name := range []string{spanVote, spanDequeue, spanDecode, spanValidate} {
+		traced := 0
+		for _, span := range spans[name] {
+			if span.SpanContext().TraceID() == stored.SpanContext().TraceID() {
+				traced++
+			}
+		}
+		assert.Equal(t, 1, traced, name)
+	}
+	for _, span := range spans[spanVote] {
+		if span.SpanContext().TraceID().String() == traceID {
+			assert.Equal(t, parentID, span.Parent().SpanID().String())
+			assert.True(t, span.Parent().IsRemote())
+			assert.Equal(t, codes.Unset, span.Status().Code)
+		} else {
+			// The vote without traceparent starts its own trace and fails validation
+			assert.False(t, span.Parent().IsValid())
+			assert.Equal(t, codes.Error, span.Status().Code)
+		}
+	}
+
+	// The processing time links to the trace of the stored vote
+	families, err := w.metrics.Gatherer().Gather()
+	require.NoError(t, err)
+	var exemplars []string
+	for _, family := range families {
+		if family.GetName() != "vote_process_duration_seconds" {
+			continue
+		}
+		for _, bucket := range family.GetMetric()[0].GetHistogram().GetBucket() {
+			for _, label := range bucket.GetExemplar().GetLabel() {
+				exemplars = append(exemplars, label.GetName()+"="+label.GetValue())
+			}
+		}
+	}
+	assert.Equal(t, []string{"trace_id=" + traceID}, exemplars)
+}
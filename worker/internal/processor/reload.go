@@ -0,0 +1,119 @@
+package processor
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+
+	"worker/internal/config"
+)
+
+// ErrReloadRejected is returned when a reload changes settings that only
+// take effect after a restart
+var ErrReloadRejected = errors.New("configuration reload rejected")
+
+// liveSettings holds what a reload replaces while consumers are running
+type liveSettings struct {
+	mu     sync.RWMutex
+	config *config.Config
+	ballot *Ballot
+}
+
+// get returns the configuration and ballot in effect
+func (l *liveSettings) get() (*config.Config, *Ballot) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.config, l.ballot
+}
+
+// set replaces the configuration and ballot in effect
+func (l *liveSettings) set(cfg *config.Config, ballot *Ballot) {
+	l.mu.Lock()
+	l.config = cfg
+	l.ballot = ballot
+	l.mu.Unlock()
+}
+
+// settings returns the configuration in effect, including reloaded settings
+func (w *Worker) settings() *config.Config {
+	cfg, _ := w.live.get()
+	return cfg
+}
+
+// currentBallot returns the ballot definition in effect
+func (w *Worker) currentBallot() *Ballot {
+	_, ballot := w.live.get()
+	return ballot
+}
+
+// Reload re-reads the configuration and the ballot definition and applies
+// them without interrupting consumers. Only settings marked reloadable in
+// config.Config may change; if any other setting changed, the whole reload
+// is rejected with ErrReloadRejected because it needs new connections. The
+// changed settings are returned and logged either way.
+func (w *Worker) Reload() ([]config.Change, error) {
+	w.reloadMu.Lock()
+	defer w.reloadMu.Unlock()
+
+	changes, err := w.reload()
+	if err != nil {
+		w.metrics.ConfigReloads.WithLabelValues("rejected").Inc()
+		w.logger.WithError(err).Warn("Configuration reload failed")
+		return changes, err
+	}
+
+	w.metrics.ConfigReloads.WithLabelValues("applied").Inc()
+	w.logger.WithField("changes", len(changes)).Info("Configuration reloaded")
+	return changes, nil
+}
+
+func (w *Worker) reload() ([]config.Change, error) {
+	next, err := w.loadConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	changes := w.settings().Diff(next)
+	var fixed []string
+	for _, change := range changes {
+		w.logger.WithFields(logrus.Fields{
+			"setting":    change.Key,
+			"old":        change.Old,
+			"new":        change.New,
+			"reloadable": change.Reloadable,
+		}).Info("Configuration setting changed")
+		if !change.Reloadable {
+			fixed = append(fixed, change.Key)
+		}
+	}
+	if len(fixed) > 0 {
+		return changes, fmt.Errorf("%w: changing %s requires a restart", ErrReloadRejected, strings.Join(fixed, ", "))
+	}
+
+	// The ballot is re-read even when no setting changed, since the file or
+	// the ballot_options table may have
+	ballot, err := w.loadBallot(next)
+	if err != nil {
+		return changes, fmt.Errorf("failed to reload ballot: %w", err)
+	}
+
+	setLogLevel(w.logger, next.LogLevel)
+	w.live.set(next, ballot)
+	if ballot != nil {
+		w.logger.WithField("polls", ballot.PollIDs()).Info("Ballot definition loaded")
+	}
+	return changes, nil
+}
+
+// setLogLevel changes the level of logger, keeping it when level is invalid
+func setLogLevel(logger *logrus.Logger, level string) {
+	parsed, err := logrus.ParseLevel(level)
+	if err != nil {
+		logger.WithError(err).Warn("Ignoring invalid log level")
+		return
+	}
+	logger.SetLevel(parsed)
+}
@@ -0,0 +1,100 @@
+package processor
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"worker/internal/config"
+	"worker/internal/resilience"
+)
+
+// breakerWait is how long a consumer waits before checking an open circuit
+// breaker again
+const breakerWait = time.Second
+
+// Dependencies guarded by a circuit breaker
+const (
+	dependencyRedis    = "redis"
+	dependencyDatabase = "database"
+)
+
+// newBreakers creates a closed circuit breaker for Redis and the database
+func (w *Worker) newBreakers(cfg *config.Config) map[string]*resilience.Breaker {
+	breakers := make(map[string]*resilience.Breaker)
+	for _, name := range []string{dependencyRedis, dependencyDatabase} {
+		breakers[name] = resilience.NewBreaker(name, cfg.BreakerThreshold, cfg.BreakerCooldown, w.breakerChanged)
+	}
+	return breakers
+}
+
+// breakerChanged logs a circuit breaker state change and exports the new state
+func (w *Worker) breakerChanged(name string, from, to resilience.State) {
+	w.metrics.CircuitBreakerState.WithLabelValues(name).Set(float64(to))
+
+	logger := w.logger.WithField("dependency", name).WithField("from", from.String())
+	switch to {
+	case resilience.Open:
+		logger.Warnf("Circuit breaker of %s opened", name)
+	case resilience.HalfOpen:
+		logger.Infof("Circuit breaker of %s half-open, probing", name)
+	case resilience.Closed:
+		logger.Infof("Circuit breaker of %s closed", name)
+	}
+}
+
+// dependenciesAvailable reports whether the consumers may take votes off the
+// queue. It is false while the breaker of any dependency in use is open, so
+// votes stay on the queue instead of being retried against a failing
+// database. Once a breaker's cooldown has passed the dependency is probed
+// with its health check, which closes or reopens the breaker.
+func (w *Worker) dependenciesAvailable(ctx context.Context) bool {
+	for _, check := range w.HealthChecks() {
+		breaker := w.breakers[check.Name]
+		if breaker == nil {
+			continue
+		}
+
+		allowed, trial := breaker.Allow()
+		if !allowed {
+			return false
+		}
+		if trial {
+			if result := runCheck(ctx, check, w.config.HealthCheckTimeout); result.Err != nil {
+				breaker.Failure()
+				return false
+			}
+			breaker.Success()
+		}
+	}
+	return true
+}
+
+// waitFor calls connect until it succeeds, backing off between attempts,
+// and gives up once ctx is done. Each attempt is recorded in the health
+// report, so /health shows why the worker is still starting.
+func (w *Worker) waitFor(ctx context.Context, name string, connect func(context.Context) error) error {
+	for attempt := 1; ; attempt++ {
+		start := time.Now()
+		err := connect(ctx)
+		w.monitor.record(CheckResult{Name: name, Err: err, Latency: time.Since(start)}, time.Now())
+		if err == nil {
+			return nil
+		}
+
+		delay := w.retry.Delay(attempt)
+		w.logger.WithError(err).WithFields(logrus.Fields{
+			"dependency": name,
+			"attempt":    attempt,
+			"retry_in":   delay.String(),
+		}).Warnf("Waiting for %s", name)
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("gave up waiting for %s after %d attempts: %w", name, attempt, err)
+		case <-time.After(delay):
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package processor
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/sirupsen/logrus"
+
+	"worker/internal/store"
+)
+
+// tallyHashKey returns the Redis hash mirroring a poll's tallies
+func (w *Worker) tallyHashKey(poll string) string {
+	return fmt.Sprintf("%s:%s", w.config.TallyRedisHash, poll)
+}
+
+// tallyVersionsKey returns the Redis hash holding the vote_tallies version of
+// each count in the poll's tally hash
+func (w *Worker) tallyVersionsKey(poll string) string {
+	return w.tallyHashKey(poll) + ":versions"
+}
+
+// mirrorTallyScript sets each count in KEYS[1] unless KEYS[2] records that a
+// newer version is already mirrored. ARGV holds option, count and version
+// triples.
+var mirrorTallyScript = redis.NewScript(`
+for i = 1, #ARGV, 3 do
+	local mirrored = tonumber(redis.call('HGET', KEYS[2], ARGV[i]) or '-1')
+	if tonumber(ARGV[i + 2]) >= mirrored then
+		redis.call('HSET', KEYS[1], ARGV[i], ARGV[i + 1])
+		redis.call('HSET', KEYS[2], ARGV[i], ARGV[i + 2])
+	end
+end
+return 0
+`)
+
+// mirrorRedisTallies copies committed running tallies into the Redis hashes.
+// Counts carry their vote_tallies version and only replace older ones, so
+// batches and repairs may reach Redis in any order.
+func (w *Worker) mirrorRedisTallies(ctx context.Context, counts map[store.TallyKey]store.TallyCount) error {
+	byPoll := make(map[string][]interface{})
+	for key, count := range counts {
+		byPoll[key.Poll] = append(byPoll[key.Poll], key.Vote, count.Count, count.Version)
+	}
+
+	_, err := w.redisClient.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for poll, args := range byPoll {
+			mirrorTallyScript.Eval(ctx, pipe, []string{w.tallyHashKey(poll), w.tallyVersionsKey(poll)}, args...)
+		}
+		return nil
+	})
+	return err
+}
+
+// updateRedisTallies mirrors the tallies of a committed batch. Failures are
+// only logged; reconciliation repairs the hashes.
+func (w *Worker) updateRedisTallies(counts map[store.TallyKey]store.TallyCount) {
+	if w.config.TallyRedisHash == "" || len(counts) == 0 {
+		return
+	}
+
+	if err := w.mirrorRedisTallies(w.ctx, counts); err != nil {
+		w.metrics.RedisErrors.Inc()
+		w.logger.WithError(err).Warn("Failed to update Redis tallies")
+	}
+}
+
+// startTallyReconciler periodically recomputes tallies from the votes table
+func (w *Worker) startTallyReconciler() {
+	if w.config.TallyReconcileInterval <= 0 {
+		return
+	}
+
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+
+		ticker := time.NewTicker(w.config.TallyReconcileInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-w.ctx.Done():
+				return
+			case <-ticker.C:
+				if err := w.reconcileTallies(); err != nil {
+					w.metrics.DBErrors.Inc()
+					w.logger.WithError(err).Error("Failed to reconcile tallies")
+				}
+			}
+		}
+	}()
+}
+
+// reconcileTallies corrects drifted vote_tallies, reports the drift per poll
+// and repairs the Redis mirror from the corrected tallies
+func (w *Worker) reconcileTallies() error {
+	reconciliation, err := w.sink.ReconcileTallies()
+	if err != nil {
+		return err
+	}
+	if reconciliation == nil {
+		// Another replica is reconciling
+		return nil
+	}
+
+	settings, ballot := w.live.get()
+	drift := make(map[string]float64, len(reconciliation.Drift))
+	for poll, value := range reconciliation.Drift {
+		drift[w.pollLabel(settings, ballot, poll)] += value
+	}
+	for label, value := range drift {
+		w.metrics.TallyDrift.WithLabelValues(label).Set(value)
+	}
+	if reconciliation.Corrections > 0 {
+		w.logger.WithField("corrections", reconciliation.Corrections).Warn("Corrected drifted vote tallies")
+	}
+
+	return w.repairRedisTallies()
+}
+
+// repairRedisTallies mirrors every running tally into Redis. Counts the
+// batches mirrored since the tallies were read are newer and stay in place.
+func (w *Worker) repairRedisTallies() error {
+	if w.config.TallyRedisHash == "" {
+		return nil
+	}
+
+	counts, err := w.sink.TallyCounts()
+	if err != nil {
+		return err
+	}
+	if err := w.mirrorRedisTallies(w.ctx, counts); err != nil {
+		w.metrics.RedisErrors.Inc()
+		return fmt.Errorf("failed to repair Redis tallies: %w", err)
+	}
+
+	w.logger.WithFields(logrus.Fields{"options": len(counts)}).Debug("Repaired Redis tallies")
+	return nil
+}
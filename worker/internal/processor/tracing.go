@@ -0,0 +1,97 @@
+package processor
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName is the instrumentation scope of the vote processing spans
+const tracerName = "worker/internal/processor"
+
+// Span names of the steps a vote goes through
+const (
+	spanVote     = "process vote"
+	spanDequeue  = "dequeue"
+	spanDecode   = "decode"
+	spanValidate = "validate"
+	spanInsert   = "insert"
+)
+
+// traceContext reads the W3C traceparent a vote may carry
+var traceContext = propagation.TraceContext{}
+
+// voteTrace follows one vote through the worker. Its span continues the
+// trace of the vote's traceparent, so that the vote service and the worker
+// show up in one trace, or starts a new trace when there is none.
+type voteTrace struct {
+	tracer trace.Tracer
+	ctx    context.Context
+	span   trace.Span
+}
+
+// startVoteTrace starts the span of a vote taken off the queue at dequeued.
+// The vote is only decoded afterwards, so its steps so far are recorded
+// with their past start and end times.
+func (w *Worker) startVoteTrace(traceparent string, dequeued time.Time) voteTrace {
+	ctx := context.Background()
+	if traceparent != "" {
+		ctx = traceContext.Extract(ctx, propagation.MapCarrier{"traceparent": traceparent})
+	}
+	ctx, span := w.tracer.Start(ctx, spanVote,
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithTimestamp(dequeued),
+	)
+	return voteTrace{tracer: w.tracer, ctx: ctx, span: span}
+}
+
+// step records a finished step of the vote as a child span
+func (t voteTrace) step(name string, start, end time.Time, err error, attrs ...attribute.KeyValue) {
+	_, span := t.tracer.Start(t.ctx, name, trace.WithTimestamp(start), trace.WithAttributes(attrs...))
+	recordError(span, err)
+	span.End(trace.WithTimestamp(end))
+}
+
+// begin starts a step of the vote that is still to run
+func (t voteTrace) begin(name string) trace.Span {
+	_, span := t.tracer.Start(t.ctx, name)
+	return span
+}
+
+// fail ends a step and the vote, both failed with err
+func (t voteTrace) fail(step trace.Span, err error) {
+	recordError(step, err)
+	step.End()
+	t.end(err)
+}
+
+// end ends the span of the vote, failed when err is not nil
+func (t voteTrace) end(err error) {
+	recordError(t.span, err)
+	t.span.End()
+}
+
+// recordError marks a span failed with err, if any
+func recordError(span trace.Span, err error) {
+	if err == nil {
+		return
+	}
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
+
+// observeWithTrace observes a value, attaching the trace ID as an exemplar
+// when the trace is sampled so that the metric links to the trace
+func observeWithTrace(observer prometheus.Observer, value float64, span trace.SpanContext) {
+	exemplars, ok := observer.(prometheus.ExemplarObserver)
+	if !ok || !span.IsSampled() {
+		observer.Observe(value)
+		return
+	}
+	exemplars.ObserveWithExemplar(value, prometheus.Labels{"trace_id": span.TraceID().String()})
+}
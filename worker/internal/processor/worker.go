@@ -0,0 +1,436 @@
+// Package processor consumes votes from a queue source, validates them and
+// stores them through a vote sink.
+package processor
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+
+	"worker/internal/config"
+	"worker/internal/metrics"
+	"worker/internal/queue"
+	"worker/internal/resilience"
+	"worker/internal/store"
+)
+
+// Vote is the JSON payload of a vote on the queue
+type Vote struct {
+	VoteID    string `json:"vote_id,omitempty"`
+	PollID    string `json:"poll_id,omitempty"`
+	Vote      string `json:"vote"`
+	VoterID   string `json:"voter_id"`
+	Timestamp string `json:"timestamp"`
+	// Traceparent is the W3C trace context of the request that cast the vote
+	Traceparent string `json:"traceparent,omitempty"`
+}
+
+// Dependencies are the connections a worker uses. Those left nil are created
+// from the configuration when the worker starts; the worker closes all of
+// them when it stops.
+type Dependencies struct {
+	Redis *redis.Client
+	Queue queue.Source
+	Sink  store.VoteSink
+	// LoadConfig re-reads the configuration on Reload. It defaults to
+	// loading from the environment and WORKER_CONFIG.
+	LoadConfig func() (*config.Config, error)
+	// Metrics are created for the worker when nil
+	Metrics *metrics.Metrics
+	// TracerProvider creates the spans of vote processing. No spans are
+	// recorded when it is nil.
+	TracerProvider trace.TracerProvider
+}
+
+// Worker handles vote processing
+type Worker struct {
+	// config is the configuration the worker started with. Settings that a
+	// reload may change are read through settings() instead.
+	config      *config.Config
+	loadConfig  func() (*config.Config, error)
+	live        liveSettings
+	reloadMu    sync.Mutex
+	redisClient *redis.Client
+	sink        store.VoteSink
+	polls       *pollRegistry
+	queue       queue.Source
+	events      eventPublisher
+	logger      *logrus.Logger
+	metrics     *metrics.Metrics
+	tracer      trace.Tracer
+	monitor     healthMonitor
+	inFlight    flightTracker
+	breakers    map[string]*resilience.Breaker
+	retry       resilience.Backoff
+	state       atomic.Value
+	// lastIteration holds when each consumer last started a loop iteration,
+	// in Unix nanoseconds
+	lastIteration []atomic.Int64
+	// startMu is held while Start runs, so that Stop waits for it
+	startMu   sync.Mutex
+	closeOnce sync.Once
+	// consumeCtx is cancelled when the consumers must stop taking votes,
+	// before ctx, which the rest of the worker runs on
+	consumeCtx    context.Context
+	stopConsuming context.CancelFunc
+	consumers     sync.WaitGroup
+	// batchCtx covers inserting and acknowledging batches. The drain cancels
+	// it when SHUTDOWN_TIMEOUT expires, before putting votes back.
+	batchCtx     context.Context
+	abortBatches context.CancelFunc
+	ctx          context.Context
+	cancel       context.CancelFunc
+	wg           sync.WaitGroup
+}
+
+// New creates a new worker instance
+func New(cfg *config.Config, logger *logrus.Logger, deps Dependencies) *Worker {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	loadConfig := deps.LoadConfig
+	if loadConfig == nil {
+		loadConfig = func() (*config.Config, error) { return config.Load("") }
+	}
+	if cfg.LogLevel != "" {
+		setLogLevel(logger, cfg.LogLevel)
+	}
+	m := deps.Metrics
+	if m == nil {
+		m = metrics.New()
+	}
+	tracerProvider := deps.TracerProvider
+	if tracerProvider == nil {
+		tracerProvider = noop.NewTracerProvider()
+	}
+
+	w := &Worker{
+		config:      cfg,
+		loadConfig:  loadConfig,
+		live:        liveSettings{config: cfg},
+		redisClient: deps.Redis,
+		sink:        deps.Sink,
+		queue:       deps.Queue,
+		polls:       &pollRegistry{open: m.PollOpen},
+		logger:      logger,
+		metrics:     m,
+		tracer:      tracerProvider.Tracer(tracerName),
+		inFlight:    flightTracker{gauge: m.VotesInFlight},
+		retry:       resilience.NewBackoff(cfg.RetryInitialDelay, cfg.RetryMaxDelay),
+		ctx:         ctx,
+		cancel:      cancel,
+	}
+	w.consumeCtx, w.stopConsuming = context.WithCancel(ctx)
+	w.batchCtx, w.abortBatches = context.WithCancel(ctx)
+	w.breakers = w.newBreakers(cfg)
+	w.state.Store(StateStarting)
+	return w
+}
+
+// Metrics returns the worker's Prometheus metrics
+func (w *Worker) Metrics() *metrics.Metrics {
+	return w.metrics
+}
+
+// NewLogger creates the JSON logger used by the worker
+func NewLogger() *logrus.Logger {
+	logger := logrus.New()
+	logger.SetFormatter(&logrus.JSONFormatter{})
+	logger.SetLevel(logrus.InfoLevel)
+	return logger
+}
+
+// usesRedis reports whether any feature in use needs a Redis connection
+func (w *Worker) usesRedis() bool {
+	return w.redisClient != nil || w.queue == nil || w.config.TallyRedisHash != "" || w.config.EventsChannel != ""
+}
+
+// connectRedis establishes Redis connection
+func (w *Worker) connectRedis(ctx context.Context) error {
+	if w.redisClient == nil {
+		w.redisClient = redis.NewClient(&redis.Options{
+			Addr:     fmt.Sprintf("%s:%d", w.config.RedisHost, w.config.RedisPort),
+			Password: w.config.RedisPassword,
+			DB:       w.config.RedisDB,
+		})
+	}
+
+	_, err := w.redisClient.Ping(ctx).Result()
+	if err != nil {
+		return fmt.Errorf("redis connection failed: %w", err)
+	}
+
+	w.logger.Info("Connected to Redis")
+	return nil
+}
+
+// connectDB connects to the database selected by DB_DRIVER
+func (w *Worker) connectDB(ctx context.Context) error {
+	if w.sink == nil {
+		sink, err := store.New(w.config, w.logger)
+		if err != nil {
+			return err
+		}
+		w.sink = sink
+	}
+
+	if err := w.sink.Ping(ctx); err != nil {
+		return fmt.Errorf("database ping failed: %w", err)
+	}
+
+	w.logger.WithField("driver", w.config.DBDriver).Info("Connected to database")
+	return nil
+}
+
+// initDB brings the database schema up to date by applying pending migrations
+func (w *Worker) initDB() error {
+	return w.sink.Migrate()
+}
+
+// processVotes receives votes from the queue source and processes them in
+// batches. Failed receives and inserts are retried with backoff, and no votes
+// are taken off the queue while a dependency's circuit breaker is open.
+func (w *Worker) processVotes(index int) {
+	defer w.consumers.Done()
+
+	logger := w.logger.WithField("consumer", index)
+	logger.Info("Starting vote processing")
+
+	var receiveFailures, insertFailures int
+	for {
+		w.markIteration(index)
+
+		select {
+		case <-w.consumeCtx.Done():
+			logger.Info("Stopping vote processing")
+			return
+		default:
+			if !w.dependenciesAvailable(w.consumeCtx) {
+				w.sleep(breakerWait)
+				continue
+			}
+
+			settings := w.settings()
+			dequeued := time.Now()
+			batch, err := w.queue.Receive(w.consumeCtx, index, queue.BatchLimits{
+				Size: settings.BatchSize,
+				Wait: settings.BatchFlushInterval,
+			})
+			w.inFlight.add(batch)
+			if err != nil && w.consumeCtx.Err() == nil {
+				receiveFailures++
+				w.breakers[dependencyRedis].Failure()
+				delay := w.retry.Delay(receiveFailures)
+				logger.WithError(err).WithFields(logrus.Fields{
+					"failures": receiveFailures,
+					"retry_in": delay.String(),
+				}).Error("Failed to receive votes")
+				if len(batch) == 0 {
+					w.sleep(delay)
+				}
+			} else if err == nil {
+				receiveFailures = 0
+				w.breakers[dependencyRedis].Success()
+			}
+			if len(batch) == 0 {
+				continue
+			}
+
+			// The failed votes are back on the queue; wait before taking
+			// them again so a struggling database is not hammered
+			if err := w.processBatch(batch, dequeued); err != nil {
+				insertFailures++
+				w.sleep(w.retry.Delay(insertFailures))
+			} else {
+				insertFailures = 0
+			}
+		}
+	}
+}
+
+// Start connects to Redis and the database, prepares the schema and the
+// queue, and starts the consumers. It returns once the worker is running.
+// Redis and the database are retried with backoff for up to STARTUP_TIMEOUT,
+// or until Stop is called.
+func (w *Worker) Start() error {
+	w.startMu.Lock()
+	defer w.startMu.Unlock()
+
+	if err := w.start(); err != nil {
+		w.cancel()
+		w.consumers.Wait()
+		w.wg.Wait()
+		w.close()
+		return err
+	}
+	return nil
+}
+
+func (w *Worker) start() error {
+	if !store.ValidPolicy(w.config.VotePolicy) {
+		return fmt.Errorf("unknown vote policy %q", w.config.VotePolicy)
+	}
+
+	// Wait for Redis and the database, which may still be starting themselves
+	startup, cancel := context.WithTimeout(w.consumeCtx, w.config.StartupTimeout)
+	defer cancel()
+
+	// Connect to Redis
+	if w.usesRedis() {
+		if err := w.waitFor(startup, dependencyRedis, w.connectRedis); err != nil {
+			return err
+		}
+	}
+
+	// Select the queue votes are consumed from
+	if w.queue == nil {
+		source, err := queue.New(w.redisClient, w.config, w.logger, w.metrics)
+		if err != nil {
+			return err
+		}
+		w.queue = source
+	}
+
+	// Connect to database
+	if err := w.waitFor(startup, dependencyDatabase, w.connectDB); err != nil {
+		return err
+	}
+
+	// Export the pool statistics of the connected database on every scrape
+	if err := w.metrics.Register(metrics.NewDBStatsCollector(w.sink.Stats)); err != nil {
+		return fmt.Errorf("failed to register database pool metrics: %w", err)
+	}
+
+	// Initialize database
+	if err := w.initDB(); err != nil {
+		return err
+	}
+
+	// Load the ballot definition used to validate votes
+	ballot, err := w.loadBallot(w.config)
+	if err != nil {
+		return err
+	}
+	w.live.set(w.config, ballot)
+	if ballot != nil {
+		w.logger.WithField("polls", ballot.PollIDs()).Info("Ballot definition loaded")
+	}
+
+	// Load which polls are closed and keep that in step with other replicas
+	if err := w.refreshPolls(); err != nil {
+		return err
+	}
+	w.startPollRefresher()
+
+	// Seed the Redis tally mirror and keep vote_tallies honest
+	if err := w.repairRedisTallies(); err != nil {
+		w.logger.WithError(err).Warn("Failed to seed Redis tallies")
+	}
+	w.startTallyReconciler()
+	w.startEventPublisher()
+
+	// Recover votes left behind by earlier runs and dead workers
+	if err := w.queue.Prepare(w.ctx); err != nil {
+		return err
+	}
+	if _, err := w.refreshDLQSize(w.ctx); err != nil {
+		w.logger.WithError(err).Warn("Failed to read dead-letter queue size")
+	}
+
+	// Check the dependencies before reporting ready, then keep checking
+	w.checkHealth(w.ctx)
+	w.sampleQueueLength(w.ctx)
+	w.startHealthMonitor()
+
+	for _, check := range w.HealthChecks() {
+		w.metrics.CircuitBreakerState.WithLabelValues(check.Name).Set(float64(w.breakers[check.Name].State()))
+	}
+
+	// Start processing votes; all consumers share the Redis client and DB pool
+	w.lastIteration = make([]atomic.Int64, w.config.Concurrency)
+	for i := 0; i < w.config.Concurrency; i++ {
+		w.markIteration(i)
+		w.consumers.Add(1)
+		go w.processVotes(i)
+	}
+
+	// Stop may have been called meanwhile
+	if !w.state.CompareAndSwap(StateStarting, StateRunning) {
+		return w.ctx.Err()
+	}
+	w.logger.Info("Worker started successfully")
+	return nil
+}
+
+// Stop drains the worker and closes its connections. The consumers stop
+// taking votes and settle those they hold, for up to SHUTDOWN_TIMEOUT; then
+// the background tasks stop, flushing pending vote events, and the database
+// and Redis are closed. Called while Start is waiting for dependencies, it
+// makes Start give up and return an error.
+func (w *Worker) Stop() {
+	w.inFlight.startDraining()
+	w.stopConsuming()
+	w.state.Store(StateStopping)
+
+	w.startMu.Lock()
+	defer w.startMu.Unlock()
+
+	w.drain()
+	w.cancel()
+	w.wg.Wait()
+	w.close()
+	w.logger.Info("Worker stopped")
+}
+
+// close releases the sink, the queue source and the Redis client, once
+func (w *Worker) close() {
+	w.closeOnce.Do(func() {
+		if w.sink != nil {
+			w.sink.Close()
+		}
+		if w.queue != nil {
+			w.queue.Close()
+		}
+		if w.redisClient != nil {
+			w.redisClient.Close()
+		}
+	})
+}
+
+// sleep pauses for the given duration or until the consumers are stopped
+func (w *Worker) sleep(d time.Duration) {
+	select {
+	case <-w.consumeCtx.Done():
+	case <-time.After(d):
+	}
+}
+
+// parseTimestamp attempts to parse timestamp in multiple formats
+func parseTimestamp(timestampStr string) (time.Time, error) {
+	// List of formats to try, in order of preference
+	formats := []string{
+		time.RFC3339,                 // "2006-01-02T15:04:05Z07:00"
+		time.RFC3339Nano,             // "2006-01-02T15:04:05.999999999Z07:00"
+		"2006-01-02T15:04:05.999999", // Python isoformat() without timezone
+		"2006-01-02T15:04:05",        // Without microseconds and timezone
+		time.DateTime,                // "2006-01-02 15:04:05"
+	}
+
+	for _, format := range formats {
+		if t, err := time.Parse(format, timestampStr); err == nil {
+			// If parsing succeeds but no timezone info, assume UTC
+			if t.Location() == time.UTC && format != time.RFC3339 && format != time.RFC3339Nano {
+				return t.UTC(), nil
+			}
+			return t, nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("unable to parse timestamp: %s", timestampStr)
+}
@@ -0,0 +1,231 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/sirupsen/logrus"
+
+	"worker/internal/config"
+	"worker/internal/metrics"
+)
+
+const (
+	// heartbeatInterval is how often a worker refreshes its liveness key
+	heartbeatInterval = 10 * time.Second
+	// heartbeatTTL is how long a worker is considered alive after its last heartbeat
+	heartbeatTTL = 30 * time.Second
+	// batchPollInterval is how long to wait for more votes while a batch is open
+	batchPollInterval = 50 * time.Millisecond
+)
+
+// replayScript atomically pops the oldest dead letter and pushes its payload back onto the queue
+var replayScript = redis.NewScript(`
+local raw = redis.call('RPOP', KEYS[1])
+if not raw then
+	return false
+end
+local entry = cjson.decode(raw)
+redis.call('LPUSH', KEYS[2], entry.payload)
+return raw
+`)
+
+// List consumes VOTE_QUEUE as a Redis list. Each vote is atomically moved
+// into a per-worker processing list and only removed from it once settled.
+type List struct {
+	redisQueue
+
+	stopHeartbeat context.CancelFunc
+	wg            sync.WaitGroup
+}
+
+// NewList creates the Redis list queue source
+func NewList(client *redis.Client, config *config.Config, logger *logrus.Logger, m *metrics.Metrics) *List {
+	q := &List{
+		redisQueue: redisQueue{
+			client:  client,
+			config:  config,
+			logger:  logger,
+			metrics: m,
+			replay:  replayScript,
+		},
+	}
+	q.enqueue = func(ctx context.Context, pipe redis.Pipeliner, data string) {
+		pipe.LPush(ctx, config.VoteQueue, data)
+	}
+	q.remove = func(ctx context.Context, pipe redis.Pipeliner, d Delivery) {
+		pipe.LRem(ctx, q.processingKey(), 1, d.Data)
+	}
+	return q
+}
+
+// processingKey returns the name of this worker's processing list
+func (q *List) processingKey() string {
+	return processingKeyFor(q.config.VoteQueue, q.config.WorkerID)
+}
+
+// processingKeyFor returns the processing list name for a given worker
+func processingKeyFor(queue, workerID string) string {
+	return fmt.Sprintf("%s:processing:%s", queue, workerID)
+}
+
+// heartbeatKeyFor returns the liveness key name for a given worker
+func heartbeatKeyFor(queue, workerID string) string {
+	return fmt.Sprintf("%s:worker:%s", queue, workerID)
+}
+
+// Prepare registers this worker and recovers votes left behind by dead workers
+func (q *List) Prepare(ctx context.Context) error {
+	q.startHeartbeat(ctx)
+	return q.recoverProcessingLists(ctx)
+}
+
+// Close stops the heartbeat and expires it right away so leftovers can be recovered
+func (q *List) Close() error {
+	if q.stopHeartbeat != nil {
+		q.stopHeartbeat()
+	}
+	q.wg.Wait()
+	return nil
+}
+
+// Depth returns the length of the queue list
+func (q *List) Depth(ctx context.Context) (int64, error) {
+	length, err := q.client.LLen(ctx, q.config.VoteQueue).Result()
+	if err != nil {
+		q.metrics.RedisErrors.Inc()
+		return 0, err
+	}
+	return length, nil
+}
+
+// Receive moves up to limits.Size votes into the processing list. It blocks
+// briefly for the first vote, then keeps filling the batch until it is full
+// or limits.Wait has elapsed.
+func (q *List) Receive(ctx context.Context, consumer int, limits BatchLimits) ([]Delivery, error) {
+	first, err := q.client.BRPopLPush(ctx, q.config.VoteQueue, q.processingKey(), 1*time.Second).Result()
+	if err == redis.Nil {
+		// No data available
+		return nil, nil
+	} else if err != nil {
+		q.metrics.RedisErrors.Inc()
+		return nil, fmt.Errorf("failed to pop from Redis: %w", err)
+	}
+
+	batch := []Delivery{{Data: first}}
+	deadline := time.Now().Add(limits.Wait)
+
+	for len(batch) < limits.Size && time.Now().Before(deadline) && ctx.Err() == nil {
+		voteData, err := q.client.RPopLPush(ctx, q.config.VoteQueue, q.processingKey()).Result()
+		if err == redis.Nil {
+			sleepContext(ctx, min(batchPollInterval, time.Until(deadline)))
+			continue
+		} else if err != nil {
+			q.metrics.RedisErrors.Inc()
+			return batch, fmt.Errorf("failed to pop from Redis: %w", err)
+		}
+
+		batch = append(batch, Delivery{Data: voteData})
+	}
+
+	return batch, nil
+}
+
+// startHeartbeat periodically marks this worker as alive so that other
+// workers leave its processing list alone
+func (q *List) startHeartbeat(ctx context.Context) {
+	key := heartbeatKeyFor(q.config.VoteQueue, q.config.WorkerID)
+	ctx, q.stopHeartbeat = context.WithCancel(ctx)
+
+	beat := func() {
+		if err := q.client.Set(ctx, key, time.Now().Format(time.RFC3339), heartbeatTTL).Err(); err != nil && ctx.Err() == nil {
+			q.metrics.RedisErrors.Inc()
+			q.logger.WithError(err).Warn("Failed to refresh worker heartbeat")
+		}
+	}
+	beat()
+
+	q.wg.Add(1)
+	go func() {
+		defer q.wg.Done()
+
+		ticker := time.NewTicker(heartbeatInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				// Expire the heartbeat right away so leftovers can be recovered
+				expireCtx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+				q.client.Del(expireCtx, key)
+				cancel()
+				return
+			case <-ticker.C:
+				beat()
+			}
+		}
+	}()
+}
+
+// recoverProcessingLists moves votes from processing lists of dead workers
+// (and from this worker's own list left by a previous run) back onto the queue
+func (q *List) recoverProcessingLists(ctx context.Context) error {
+	prefix := processingKeyFor(q.config.VoteQueue, "")
+	recovered := 0
+
+	iter := q.client.Scan(ctx, 0, prefix+"*", 100).Iterator()
+	for iter.Next(ctx) {
+		key := iter.Val()
+		workerID := strings.TrimPrefix(key, prefix)
+
+		if workerID != q.config.WorkerID {
+			alive, err := q.client.Exists(ctx, heartbeatKeyFor(q.config.VoteQueue, workerID)).Result()
+			if err != nil {
+				q.metrics.RedisErrors.Inc()
+				return fmt.Errorf("failed to check worker heartbeat: %w", err)
+			}
+			if alive > 0 {
+				continue
+			}
+		}
+
+		count := 0
+		for {
+			err := q.client.RPopLPush(ctx, key, q.config.VoteQueue).Err()
+			if err == redis.Nil {
+				break
+			} else if err != nil {
+				q.metrics.RedisErrors.Inc()
+				return fmt.Errorf("failed to recover processing list %s: %w", key, err)
+			}
+			count++
+		}
+
+		if count > 0 {
+			q.logger.WithFields(logrus.Fields{
+				"worker_id": workerID,
+				"votes":     count,
+			}).Warn("Recovered votes from stale processing list")
+		}
+		recovered += count
+	}
+	if err := iter.Err(); err != nil {
+		q.metrics.RedisErrors.Inc()
+		return fmt.Errorf("failed to scan processing lists: %w", err)
+	}
+
+	q.metrics.VotesRecovered.Add(float64(recovered))
+	return nil
+}
+
+// sleepContext pauses for the given duration or until ctx is done
+func sleepContext(ctx context.Context, d time.Duration) {
+	select {
+	case <-ctx.Done():
+	case <-time.After(d):
+	}
+}
@@ -0,0 +1,141 @@
+package queue
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"worker/internal/config"
+	"worker/internal/metrics"
+)
+
+// newTestRedis starts an in-process Redis server and a client for it
+func newTestRedis(t *testing.T) (*miniredis.Miniredis, *redis.Client) {
+	t.Helper()
+
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { client.Close() })
+	return server, client
+}
+
+// newTestConfig configures a queue source for a worker
+func newTestConfig(workerID string) *config.Config {
+	return &config.Config{
+		VoteQueue:       "votes",
+		DeadLetterQueue: "votes:dlq",
+		StreamGroup:     "workers",
+		StreamClaimIdle: time.Minute,
+		Concurrency:     1,
+		WorkerID:        workerID,
+	}
+}
+
+// newTestList creates the list source of a worker on client
+func newTestList(t *testing.T, client *redis.Client, workerID string) *List {
+	t.Helper()
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	q := NewList(client, newTestConfig(workerID), logger, metrics.New())
+	t.Cleanup(func() { q.Close() })
+	return q
+}
+
+func TestListKeepsVotesInProcessingListUntilAcked(t *testing.T) {
+	server, client := newTestRedis(t)
+	q := newTestList(t, client, "worker-1")
+	ctx := context.Background()
+	require.NoError(t, q.Prepare(ctx))
+
+	server.Lpush("votes", "a")
+	server.Lpush("votes", "b")
+
+	batch, err := q.Receive(ctx, 0, BatchLimits{Size: 2, Wait: 10 * time.Millisecond})
+	require.NoError(t, err)
+	require.Len(t, batch, 2)
+	assert.Equal(t, "a", batch[0].Data)
+
+	processing, err := server.List("votes:processing:worker-1")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"a", "b"}, processing)
+
+	require.NoError(t, q.Ack(ctx, batch[:1]))
+	require.NoError(t, q.Requeue(ctx, batch[1]))
+	assert.False(t, server.Exists("votes:processing:worker-1"))
+	queued, err := server.List("votes")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"b"}, queued)
+}
+
+func TestListRecoversProcessingListsOfDeadWorkers(t *testing.T) {
+	server, client := newTestRedis(t)
+	ctx := context.Background()
+
+	// worker-1 crashed holding a vote, worker-2 is alive and holds another,
+	// and this worker's own list was left behind by its previous run
+	server.Lpush("votes:processing:worker-1", "dead")
+	server.Lpush("votes:processing:worker-2", "alive")
+	require.NoError(t, server.Set("votes:worker:worker-2", "now"))
+	server.Lpush("votes:processing:worker-3", "own")
+
+	q := newTestList(t, client, "worker-3")
+	require.NoError(t, q.Prepare(ctx))
+
+	queued, err := server.List("votes")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"dead", "own"}, queued)
+	assert.False(t, server.Exists("votes:processing:worker-1"))
+	assert.True(t, server.Exists("votes:processing:worker-2"), "a live worker keeps its votes")
+	assert.Equal(t, 2.0, testutil.ToFloat64(q.metrics.VotesRecovered))
+
+	// The heartbeat expires with the worker, so that others may recover its list
+	assert.True(t, server.Exists("votes:worker:worker-3"))
+	server.FastForward(heartbeatTTL)
+	assert.False(t, server.Exists("votes:worker:worker-3"))
+}
+
+func TestListCloseExpiresHeartbeat(t *testing.T) {
+	server, client := newTestRedis(t)
+	q := newTestList(t, client, "worker-1")
+	require.NoError(t, q.Prepare(context.Background()))
+	require.True(t, server.Exists("votes:worker:worker-1"))
+
+	require.NoError(t, q.Close())
+	assert.False(t, server.Exists("votes:worker:worker-1"))
+}
+
+func TestListReceiveFillsBatchUntilFlushInterval(t *testing.T) {
+	server, client := newTestRedis(t)
+	q := newTestList(t, client, "worker-1")
+	ctx := context.Background()
+	require.NoError(t, q.Prepare(ctx))
+
+	for _, vote := range []string{"a", "b", "c"} {
+		server.Lpush("votes", vote)
+	}
+
+	// A batch stops at its size
+	batch, err := q.Receive(ctx, 0, BatchLimits{Size: 2, Wait: time.Second})
+	require.NoError(t, err)
+	assert.Len(t, batch, 2)
+
+	// A partial batch is returned once the flush interval passes, including
+	// votes that arrived in the meantime
+	time.AfterFunc(20*time.Millisecond, func() { client.LPush(ctx, "votes", "d") })
+	start := time.Now()
+	batch, err = q.Receive(ctx, 0, BatchLimits{Size: 10, Wait: 200 * time.Millisecond})
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, time.Since(start), 200*time.Millisecond)
+	require.Len(t, batch, 2)
+	assert.Equal(t, "c", batch[0].Data)
+	assert.Equal(t, "d", batch[1].Data)
+}
@@ -0,0 +1,212 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Memory is an in-process queue source. It keeps the same delivery
+// semantics as the Redis backends, so the processing, retry and dead-letter
+// paths can be exercised without Redis.
+type Memory struct {
+	mu          sync.Mutex
+	queue       []Delivery
+	inFlight    map[string]Delivery
+	attempts    map[string]FailureRecord
+	deadLetters []string
+	nextID      int
+	// notify is signalled when votes are pushed onto an empty queue
+	notify chan struct{}
+}
+
+// NewMemory creates an empty in-memory queue source
+func NewMemory() *Memory {
+	return &Memory{
+		inFlight: make(map[string]Delivery),
+		attempts: make(map[string]FailureRecord),
+		notify:   make(chan struct{}, 1),
+	}
+}
+
+// Push adds vote payloads to the back of the queue
+func (q *Memory) Push(payloads ...string) {
+	q.mu.Lock()
+	for _, data := range payloads {
+		q.queue = append(q.queue, q.newDelivery(data))
+	}
+	q.mu.Unlock()
+
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+}
+
+// newDelivery assigns a payload its delivery ID; q.mu must be held
+func (q *Memory) newDelivery(data string) Delivery {
+	q.nextID++
+	return Delivery{ID: strconv.Itoa(q.nextID), Data: data}
+}
+
+// Len returns the number of votes waiting to be received
+func (q *Memory) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.queue)
+}
+
+// InFlight returns the number of received votes that are not settled yet
+func (q *Memory) InFlight() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.inFlight)
+}
+
+// Depth returns the number of votes waiting to be received
+func (q *Memory) Depth(ctx context.Context) (int64, error) {
+	return int64(q.Len()), nil
+}
+
+// Prepare is a no-op; nothing survives a restart of an in-memory queue
+func (q *Memory) Prepare(ctx context.Context) error {
+	return nil
+}
+
+// Close is a no-op
+func (q *Memory) Close() error {
+	return nil
+}
+
+// Receive takes up to limits.Size votes, waiting up to a second for the first one
+func (q *Memory) Receive(ctx context.Context, consumer int, limits BatchLimits) ([]Delivery, error) {
+	if batch := q.take(limits.Size); len(batch) > 0 {
+		return batch, nil
+	}
+
+	timer := time.NewTimer(1 * time.Second)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return nil, nil
+	case <-timer.C:
+		return nil, nil
+	case <-q.notify:
+		return q.take(limits.Size), nil
+	}
+}
+
+// take moves up to size votes from the queue into flight
+func (q *Memory) take(size int) []Delivery {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	n := min(len(q.queue), size)
+	batch := append([]Delivery(nil), q.queue[:n]...)
+	q.queue = q.queue[n:]
+	for _, d := range batch {
+		q.inFlight[d.ID] = d
+	}
+	return batch
+}
+
+// Ack forgets processed votes and their retry counts
+func (q *Memory) Ack(ctx context.Context, deliveries []Delivery) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for _, d := range deliveries {
+		delete(q.inFlight, d.ID)
+		delete(q.attempts, d.Data)
+	}
+	return nil
+}
+
+// Requeue puts a vote back at the end of the queue
+func (q *Memory) Requeue(ctx context.Context, d Delivery) error {
+	q.mu.Lock()
+	delete(q.inFlight, d.ID)
+	q.queue = append(q.queue, q.newDelivery(d.Data))
+	q.mu.Unlock()
+
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// DeadLetter moves a vote to the dead-letter queue
+func (q *Memory) DeadLetter(ctx context.Context, d Delivery, entry []byte) (int64, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	delete(q.inFlight, d.ID)
+	delete(q.attempts, d.Data)
+	q.deadLetters = append([]string{string(entry)}, q.deadLetters...)
+	return int64(len(q.deadLetters)), nil
+}
+
+// RecordFailure increments the retry count of a payload
+func (q *Memory) RecordFailure(ctx context.Context, data string) (FailureRecord, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	record, ok := q.attempts[data]
+	if !ok {
+		record.FirstFailure = time.Now().UTC()
+	}
+	record.Attempts++
+	q.attempts[data] = record
+	return record, nil
+}
+
+// DeadLetters returns the newest dead letters
+func (q *Memory) DeadLetters(ctx context.Context, limit int) ([]string, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if limit > len(q.deadLetters) {
+		limit = len(q.deadLetters)
+	}
+	return append([]string(nil), q.deadLetters[:limit]...), nil
+}
+
+// DeadLetterCount returns the number of dead letters
+func (q *Memory) DeadLetterCount(ctx context.Context) (int64, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return int64(len(q.deadLetters)), nil
+}
+
+// ReplayDeadLetter moves the oldest dead letter's payload back onto the queue
+func (q *Memory) ReplayDeadLetter(ctx context.Context) error {
+	q.mu.Lock()
+	if len(q.deadLetters) == 0 {
+		q.mu.Unlock()
+		return ErrNoDeadLetters
+	}
+	raw := q.deadLetters[len(q.deadLetters)-1]
+	q.deadLetters = q.deadLetters[:len(q.deadLetters)-1]
+	q.mu.Unlock()
+
+	var entry DeadLetter
+	if err := json.Unmarshal([]byte(raw), &entry); err != nil {
+		return err
+	}
+	q.Push(entry.Payload)
+	return nil
+}
+
+// PurgeDeadLetters discards every dead letter
+func (q *Memory) PurgeDeadLetters(ctx context.Context) (int64, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	purged := int64(len(q.deadLetters))
+	q.deadLetters = nil
+	return purged, nil
+}
@@ -0,0 +1,50 @@
+package queue
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryQueueDelivery(t *testing.T) {
+	q := NewMemory()
+	limits := BatchLimits{Size: 2, Wait: 10 * time.Millisecond}
+	ctx := context.Background()
+
+	q.Push("a", "b", "c")
+
+	batch, err := q.Receive(ctx, 0, limits)
+	require.NoError(t, err)
+	require.Len(t, batch, 2)
+	assert.Equal(t, "a", batch[0].Data)
+	assert.Equal(t, "b", batch[1].Data)
+	assert.Equal(t, 1, q.Len())
+	assert.Equal(t, 2, q.InFlight())
+
+	require.NoError(t, q.Ack(ctx, batch[:1]))
+	require.NoError(t, q.Requeue(ctx, batch[1]))
+	assert.Equal(t, 0, q.InFlight())
+
+	batch, err = q.Receive(ctx, 0, limits)
+	require.NoError(t, err)
+	require.Len(t, batch, 2)
+	assert.Equal(t, "c", batch[0].Data)
+	assert.Equal(t, "b", batch[1].Data)
+}
+
+func TestMemoryQueueReceiveTimesOut(t *testing.T) {
+	q := NewMemory()
+	ctx, cancel := context.WithCancel(context.Background())
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		cancel()
+	}()
+
+	batch, err := q.Receive(ctx, 0, BatchLimits{Size: 10, Wait: time.Second})
+	require.NoError(t, err)
+	assert.Empty(t, batch)
+}
@@ -0,0 +1,266 @@
+// Package queue provides the sources votes are consumed from: a Redis list,
+// a Redis stream consumer group and an in-memory queue.
+package queue
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/sirupsen/logrus"
+
+	"worker/internal/config"
+	"worker/internal/metrics"
+)
+
+// Queue backends
+const (
+	// BackendList consumes VOTE_QUEUE as a Redis list
+	BackendList = "list"
+	// BackendStream consumes VOTE_QUEUE as a Redis stream with a consumer group
+	BackendStream = "stream"
+)
+
+// ErrNoDeadLetters is returned when replaying from an empty dead-letter queue
+var ErrNoDeadLetters = errors.New("dead-letter queue is empty")
+
+// BatchLimits bound a batch taken by Receive. They are passed on every call
+// so that a configuration reload takes effect on the next batch.
+type BatchLimits struct {
+	// Size is the most deliveries in a batch
+	Size int
+	// Wait is how long a started batch is kept open for more deliveries
+	Wait time.Duration
+}
+
+// Delivery is a vote payload taken from a queue source
+type Delivery struct {
+	// ID identifies the delivery within its source; it is empty for the list backend
+	ID   string
+	Data string
+}
+
+// FailureRecord tracks retries of a payload between deliveries
+type FailureRecord struct {
+	Attempts     int       `json:"attempts"`
+	FirstFailure time.Time `json:"first_failure"`
+}
+
+// DeadLetter wraps a vote payload that could not be processed
+type DeadLetter struct {
+	Payload      string    `json:"payload"`
+	Reason       string    `json:"reason"`
+	Error        string    `json:"error"`
+	Attempts     int       `json:"attempts"`
+	FirstFailure time.Time `json:"first_failure"`
+	LastFailure  time.Time `json:"last_failure"`
+}
+
+// Acknowledger settles deliveries once the worker is done with them
+type Acknowledger interface {
+	// Ack removes processed deliveries from the queue and forgets their retries
+	Ack(ctx context.Context, deliveries []Delivery) error
+	// Requeue puts a delivery back onto the queue for another attempt
+	Requeue(ctx context.Context, d Delivery) error
+	// DeadLetter moves a delivery to the dead-letter queue, storing entry in
+	// its place, and returns the new dead-letter queue size
+	DeadLetter(ctx context.Context, d Delivery, entry []byte) (int64, error)
+	// RecordFailure increments the retry count of a payload. The returned
+	// record is usable even when err reports that it could not be persisted.
+	RecordFailure(ctx context.Context, data string) (FailureRecord, error)
+}
+
+// DeadLetterStore gives the admin endpoints access to the dead-letter queue
+type DeadLetterStore interface {
+	// DeadLetters returns up to limit entries, newest first
+	DeadLetters(ctx context.Context, limit int) ([]string, error)
+	// DeadLetterCount returns the number of dead-lettered entries
+	DeadLetterCount(ctx context.Context) (int64, error)
+	// ReplayDeadLetter moves the oldest entry's payload back onto the queue,
+	// returning ErrNoDeadLetters when there is none
+	ReplayDeadLetter(ctx context.Context) error
+	// PurgeDeadLetters discards every entry and returns how many there were
+	PurgeDeadLetters(ctx context.Context) (int64, error)
+}
+
+// Source is where votes come from. Each consumer goroutine receives
+// batches by its index; deliveries stay owned by the worker until they are
+// acknowledged, requeued or dead-lettered.
+type Source interface {
+	Acknowledger
+	DeadLetterStore
+
+	// Prepare readies the queue before consumers start and recovers
+	// deliveries abandoned by earlier runs
+	Prepare(ctx context.Context) error
+	// Depth returns the number of deliveries waiting to be received
+	Depth(ctx context.Context) (int64, error)
+	// Receive takes up to limits.Size deliveries for a consumer. It waits
+	// briefly for the first one and returns an empty batch if none arrives.
+	// A partial batch may be returned along with an error.
+	Receive(ctx context.Context, consumer int, limits BatchLimits) ([]Delivery, error)
+	// Close releases resources held by the source
+	Close() error
+}
+
+// New creates the Redis queue source selected by QUEUE_BACKEND
+func New(client *redis.Client, config *config.Config, logger *logrus.Logger, m *metrics.Metrics) (Source, error) {
+	switch config.QueueBackend {
+	case BackendList:
+		return NewList(client, config, logger, m), nil
+	case BackendStream:
+		return NewStream(client, config, logger, m), nil
+	default:
+		return nil, fmt.Errorf("unknown queue backend %q", config.QueueBackend)
+	}
+}
+
+// redisQueue holds what the list and stream backends share: retry counts and
+// the dead-letter list. The backend fills in how payloads are enqueued and
+// how a delivery is taken out of the worker's hands.
+type redisQueue struct {
+	client  *redis.Client
+	config  *config.Config
+	logger  *logrus.Logger
+	metrics *metrics.Metrics
+
+	enqueue func(ctx context.Context, pipe redis.Pipeliner, data string)
+	remove  func(ctx context.Context, pipe redis.Pipeliner, d Delivery)
+	replay  *redis.Script
+}
+
+// attemptsKey returns the name of the hash holding retry counts
+func (q *redisQueue) attemptsKey() string {
+	return fmt.Sprintf("%s:attempts", q.config.VoteQueue)
+}
+
+// payloadHash identifies a payload in the attempts hash
+func payloadHash(voteData string) string {
+	sum := sha256.Sum256([]byte(voteData))
+	return hex.EncodeToString(sum[:])
+}
+
+// Ack removes a committed batch from the queue in one round trip
+func (q *redisQueue) Ack(ctx context.Context, deliveries []Delivery) error {
+	_, err := q.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, d := range deliveries {
+			q.remove(ctx, pipe, d)
+			pipe.HDel(ctx, q.attemptsKey(), payloadHash(d.Data))
+		}
+		return nil
+	})
+	if err != nil {
+		q.metrics.RedisErrors.Inc()
+		return fmt.Errorf("failed to acknowledge votes: %w", err)
+	}
+	return nil
+}
+
+// Requeue atomically moves a vote from the worker back onto the queue
+func (q *redisQueue) Requeue(ctx context.Context, d Delivery) error {
+	_, err := q.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		q.enqueue(ctx, pipe, d.Data)
+		q.remove(ctx, pipe, d)
+		return nil
+	})
+	if err != nil {
+		q.metrics.RedisErrors.Inc()
+		return fmt.Errorf("failed to requeue vote: %w", err)
+	}
+	return nil
+}
+
+// DeadLetter moves a vote from the worker to the dead-letter list
+func (q *redisQueue) DeadLetter(ctx context.Context, d Delivery, entry []byte) (int64, error) {
+	var size *redis.IntCmd
+	_, err := q.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		size = pipe.LPush(ctx, q.config.DeadLetterQueue, entry)
+		q.remove(ctx, pipe, d)
+		pipe.HDel(ctx, q.attemptsKey(), payloadHash(d.Data))
+		return nil
+	})
+	if err != nil {
+		q.metrics.RedisErrors.Inc()
+		return 0, fmt.Errorf("failed to dead-letter vote: %w", err)
+	}
+	return size.Val(), nil
+}
+
+// RecordFailure increments the retry count of a payload in the attempts hash
+func (q *redisQueue) RecordFailure(ctx context.Context, data string) (FailureRecord, error) {
+	record := FailureRecord{FirstFailure: time.Now().UTC()}
+
+	field := payloadHash(data)
+	raw, readErr := q.client.HGet(ctx, q.attemptsKey(), field).Result()
+	if readErr == nil {
+		json.Unmarshal([]byte(raw), &record)
+	} else if readErr != redis.Nil {
+		q.metrics.RedisErrors.Inc()
+		q.logger.WithError(readErr).Warn("Failed to read vote retry count")
+	}
+	record.Attempts++
+
+	encoded, _ := json.Marshal(record)
+	if err := q.client.HSet(ctx, q.attemptsKey(), field, encoded).Err(); err != nil {
+		q.metrics.RedisErrors.Inc()
+		return record, fmt.Errorf("failed to store vote retry count: %w", err)
+	}
+	return record, nil
+}
+
+// DeadLetters returns the newest dead letters
+func (q *redisQueue) DeadLetters(ctx context.Context, limit int) ([]string, error) {
+	entries, err := q.client.LRange(ctx, q.config.DeadLetterQueue, 0, int64(limit-1)).Result()
+	if err != nil {
+		q.metrics.RedisErrors.Inc()
+		return nil, err
+	}
+	return entries, nil
+}
+
+// DeadLetterCount returns the length of the dead-letter list
+func (q *redisQueue) DeadLetterCount(ctx context.Context) (int64, error) {
+	size, err := q.client.LLen(ctx, q.config.DeadLetterQueue).Result()
+	if err != nil {
+		q.metrics.RedisErrors.Inc()
+		return 0, err
+	}
+	return size, nil
+}
+
+// ReplayDeadLetter moves the oldest dead letter back onto the queue
+func (q *redisQueue) ReplayDeadLetter(ctx context.Context) error {
+	raw, err := q.replay.Run(ctx, q.client, []string{q.config.DeadLetterQueue, q.config.VoteQueue}).Text()
+	if err == redis.Nil {
+		return ErrNoDeadLetters
+	} else if err != nil {
+		q.metrics.RedisErrors.Inc()
+		return err
+	}
+
+	var entry DeadLetter
+	if json.Unmarshal([]byte(raw), &entry) == nil {
+		q.client.HDel(ctx, q.attemptsKey(), payloadHash(entry.Payload))
+	}
+	return nil
+}
+
+// PurgeDeadLetters deletes the dead-letter list
+func (q *redisQueue) PurgeDeadLetters(ctx context.Context) (int64, error) {
+	var size *redis.IntCmd
+	_, err := q.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		size = pipe.LLen(ctx, q.config.DeadLetterQueue)
+		pipe.Del(ctx, q.config.DeadLetterQueue)
+		return nil
+	})
+	if err != nil {
+		q.metrics.RedisErrors.Inc()
+		return 0, err
+	}
+	return size.Val(), nil
+}
@@ -0,0 +1,269 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/sirupsen/logrus"
+
+	"worker/internal/config"
+	"worker/internal/metrics"
+)
+
+// streamPayloadField is the stream entry field carrying the vote JSON
+const streamPayloadField = "payload"
+
+// replayStreamScript is replayScript for the stream backend
+var replayStreamScript = redis.NewScript(`
+local raw = redis.call('RPOP', KEYS[1])
+if not raw then
+	return false
+end
+local entry = cjson.decode(raw)
+redis.call('XADD', KEYS[2], '*', 'payload', entry.payload)
+return raw
+`)
+
+// streamConsumer holds the stream state of one processing goroutine
+type streamConsumer struct {
+	// name identifies the goroutine within the consumer group
+	name string
+	// lastClaim is when stale entries were last claimed
+	lastClaim time.Time
+	// recovered is set once entries left pending by a previous run are re-read
+	recovered bool
+}
+
+// Stream consumes VOTE_QUEUE as a Redis stream through a consumer group.
+// Entries stay pending on their consumer until acknowledged.
+type Stream struct {
+	redisQueue
+
+	consumers []*streamConsumer
+}
+
+// NewStream creates the Redis stream queue source
+func NewStream(client *redis.Client, config *config.Config, logger *logrus.Logger, m *metrics.Metrics) *Stream {
+	q := &Stream{
+		redisQueue: redisQueue{
+			client:  client,
+			config:  config,
+			logger:  logger,
+			metrics: m,
+			replay:  replayStreamScript,
+		},
+	}
+	q.enqueue = func(ctx context.Context, pipe redis.Pipeliner, data string) {
+		pipe.XAdd(ctx, &redis.XAddArgs{
+			Stream: config.VoteQueue,
+			Values: map[string]interface{}{streamPayloadField: data},
+		})
+	}
+	q.remove = func(ctx context.Context, pipe redis.Pipeliner, d Delivery) {
+		pipe.XAck(ctx, config.VoteQueue, config.StreamGroup, d.ID)
+		pipe.XDel(ctx, config.VoteQueue, d.ID)
+	}
+
+	for i := 0; i < config.Concurrency; i++ {
+		q.consumers = append(q.consumers, &streamConsumer{name: fmt.Sprintf("%s-%d", config.WorkerID, i)})
+	}
+	return q
+}
+
+// Prepare creates the consumer group (and the stream) if missing. Stale
+// entries of dead consumers are claimed while receiving.
+func (q *Stream) Prepare(ctx context.Context) error {
+	err := q.client.XGroupCreateMkStream(ctx, q.config.VoteQueue, q.config.StreamGroup, "0").Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		q.metrics.RedisErrors.Inc()
+		return fmt.Errorf("failed to create stream consumer group: %w", err)
+	}
+
+	q.logger.WithField("group", q.config.StreamGroup).Info("Consuming votes from Redis stream")
+	return nil
+}
+
+// Close is a no-op; pending entries are left for the next run or other consumers
+func (q *Stream) Close() error {
+	return nil
+}
+
+// Depth returns the length of the stream. Entries are deleted once
+// acknowledged, so this includes entries pending on a consumer.
+func (q *Stream) Depth(ctx context.Context) (int64, error) {
+	length, err := q.client.XLen(ctx, q.config.VoteQueue).Result()
+	if err != nil {
+		q.metrics.RedisErrors.Inc()
+		return 0, err
+	}
+	return length, nil
+}
+
+// Receive reads up to limits.Size entries for a consumer. Entries left pending
+// by a previous run of the same consumer are re-read first, and entries idle
+// on other consumers for StreamClaimIdle are claimed periodically.
+func (q *Stream) Receive(ctx context.Context, consumer int, limits BatchLimits) ([]Delivery, error) {
+	if consumer < 0 || consumer >= len(q.consumers) {
+		return nil, fmt.Errorf("unknown stream consumer %d", consumer)
+	}
+	c := q.consumers[consumer]
+
+	if !c.recovered {
+		batch, err := q.read(ctx, c, "0", limits.Size, 0)
+		if err != nil {
+			return nil, err
+		}
+		if len(batch) > 0 {
+			q.metrics.VotesRecovered.Add(float64(len(batch)))
+			return batch, nil
+		}
+		c.recovered = true
+	}
+
+	if time.Since(c.lastClaim) >= q.config.StreamClaimIdle {
+		c.lastClaim = time.Now()
+		claimed, err := q.claimStaleEntries(ctx, c, limits.Size)
+		if err != nil {
+			return nil, err
+		}
+		if len(claimed) > 0 {
+			q.metrics.VotesRecovered.Add(float64(len(claimed)))
+			return claimed, nil
+		}
+	}
+
+	batch, err := q.read(ctx, c, ">", limits.Size, 1*time.Second)
+	if err != nil || len(batch) == 0 {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(limits.Wait)
+	for len(batch) < limits.Size && ctx.Err() == nil {
+		remaining := time.Until(deadline)
+		if remaining < time.Millisecond {
+			break
+		}
+
+		more, err := q.read(ctx, c, ">", limits.Size-len(batch), remaining)
+		if err != nil {
+			return batch, err
+		}
+		batch = append(batch, more...)
+	}
+
+	return batch, nil
+}
+
+// read reads up to count entries for a consumer with XREADGROUP. id ">"
+// reads new entries and blocks up to block; id "0" re-reads the consumer's
+// pending entries.
+func (q *Stream) read(ctx context.Context, c *streamConsumer, id string, count int, block time.Duration) ([]Delivery, error) {
+	args := &redis.XReadGroupArgs{
+		Group:    q.config.StreamGroup,
+		Consumer: c.name,
+		Streams:  []string{q.config.VoteQueue, id},
+		Count:    int64(count),
+		Block:    block,
+	}
+	if id != ">" {
+		args.Block = -1
+	}
+
+	streams, err := q.client.XReadGroup(ctx, args).Result()
+	if err == redis.Nil {
+		return nil, nil
+	} else if err != nil {
+		q.metrics.RedisErrors.Inc()
+		q.recreateLostGroup(ctx, err)
+		return nil, fmt.Errorf("failed to read from Redis stream: %w", err)
+	}
+
+	var batch []Delivery
+	for _, stream := range streams {
+		batch = append(batch, q.deliveries(ctx, stream.Messages)...)
+	}
+	return batch, nil
+}
+
+// claimStaleEntries takes over up to count entries idle for StreamClaimIdle;
+// XAUTOCLAIM is sent raw since the client cannot parse the Redis 7 reply
+func (q *Stream) claimStaleEntries(ctx context.Context, c *streamConsumer, count int) ([]Delivery, error) {
+	reply, err := q.client.Do(ctx, "XAUTOCLAIM",
+		q.config.VoteQueue, q.config.StreamGroup, c.name,
+		q.config.StreamClaimIdle.Milliseconds(), "0-0",
+		"COUNT", count,
+	).Slice()
+	if err != nil {
+		q.metrics.RedisErrors.Inc()
+		q.recreateLostGroup(ctx, err)
+		return nil, fmt.Errorf("failed to claim stale stream entries: %w", err)
+	}
+	if len(reply) < 2 {
+		return nil, fmt.Errorf("unexpected XAUTOCLAIM reply of %d elements", len(reply))
+	}
+
+	entries, _ := reply[1].([]interface{})
+	messages := make([]redis.XMessage, 0, len(entries))
+	for _, entry := range entries {
+		fields, ok := entry.([]interface{})
+		if !ok || len(fields) < 2 {
+			continue
+		}
+
+		id, _ := fields[0].(string)
+		values := make(map[string]interface{})
+		pairs, _ := fields[1].([]interface{})
+		for i := 0; i+1 < len(pairs); i += 2 {
+			if key, ok := pairs[i].(string); ok {
+				values[key] = pairs[i+1]
+			}
+		}
+		messages = append(messages, redis.XMessage{ID: id, Values: values})
+	}
+
+	batch := q.deliveries(ctx, messages)
+	if len(batch) > 0 {
+		q.logger.WithField("votes", len(batch)).Warn("Claimed stale stream entries")
+	}
+	return batch, nil
+}
+
+// recreateLostGroup creates the consumer group again when err reports it
+// missing, as after a Redis restart without persistence, so that the next
+// read succeeds
+func (q *Stream) recreateLostGroup(ctx context.Context, err error) {
+	if !strings.HasPrefix(err.Error(), "NOGROUP") {
+		return
+	}
+
+	q.logger.WithError(err).Warn("Stream consumer group is missing, recreating it")
+	if err := q.Prepare(ctx); err != nil {
+		q.logger.WithError(err).Error("Failed to recreate stream consumer group")
+	}
+}
+
+// deliveries converts stream entries into deliveries. Entries carrying a
+// payload field are used as-is; otherwise the fields themselves form the vote.
+func (q *Stream) deliveries(ctx context.Context, messages []redis.XMessage) []Delivery {
+	batch := make([]Delivery, 0, len(messages))
+	for _, message := range messages {
+		if len(message.Values) == 0 {
+			// The entry was deleted while pending, just acknowledge it
+			q.client.XAck(ctx, q.config.VoteQueue, q.config.StreamGroup, message.ID)
+			continue
+		}
+
+		data, ok := message.Values[streamPayloadField].(string)
+		if !ok {
+			raw, _ := json.Marshal(message.Values)
+			data = string(raw)
+		}
+
+		batch = append(batch, Delivery{ID: message.ID, Data: data})
+	}
+	return batch
+}
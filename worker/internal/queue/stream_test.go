@@ -0,0 +1,159 @@
+package queue
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"worker/internal/metrics"
+)
+
+// newTestStream creates the stream source of a worker on client
+func newTestStream(t *testing.T, client *redis.Client, workerID string) *Stream {
+	t.Helper()
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	q := NewStream(client, newTestConfig(workerID), logger, metrics.New())
+	require.NoError(t, q.Prepare(context.Background()))
+	return q
+}
+
+// addVotes appends vote payloads to the stream
+func addVotes(t *testing.T, client *redis.Client, payloads ...string) {
+	t.Helper()
+
+	for _, data := range payloads {
+		require.NoError(t, client.XAdd(context.Background(), &redis.XAddArgs{
+			Stream: "votes",
+			Values: map[string]interface{}{streamPayloadField: data},
+		}).Err())
+	}
+}
+
+// pendingConsumers returns the consumer each pending entry is assigned to
+func pendingConsumers(t *testing.T, client *redis.Client) map[string]string {
+	t.Helper()
+
+	pending, err := client.XPendingExt(context.Background(), &redis.XPendingExtArgs{
+		Stream: "votes", Group: "workers", Start: "-", End: "+", Count: 100,
+	}).Result()
+	if err != redis.Nil {
+		require.NoError(t, err)
+	}
+
+	consumers := make(map[string]string, len(pending))
+	for _, entry := range pending {
+		consumers[entry.ID] = entry.Consumer
+	}
+	return consumers
+}
+
+func TestStreamKeepsEntriesPendingUntilAcked(t *testing.T) {
+	_, client := newTestRedis(t)
+	q := newTestStream(t, client, "worker-1")
+	ctx := context.Background()
+
+	addVotes(t, client, "a", "b", "c")
+	require.NoError(t, client.XAdd(ctx, &redis.XAddArgs{
+		Stream: "votes",
+		Values: map[string]interface{}{"vote": "cats"},
+	}).Err())
+
+	batch, err := q.Receive(ctx, 0, BatchLimits{Size: 2, Wait: 10 * time.Millisecond})
+	require.NoError(t, err)
+	require.Len(t, batch, 2)
+	assert.Equal(t, "a", batch[0].Data)
+	assert.Len(t, pendingConsumers(t, client), 2)
+
+	// Acknowledged entries are deleted, so the depth only counts the rest
+	require.NoError(t, q.Ack(ctx, batch))
+	assert.Empty(t, pendingConsumers(t, client))
+	depth, err := q.Depth(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), depth)
+
+	// Entries without a payload field carry the vote in their fields
+	batch, err = q.Receive(ctx, 0, BatchLimits{Size: 10, Wait: 10 * time.Millisecond})
+	require.NoError(t, err)
+	require.Len(t, batch, 2)
+	assert.Equal(t, "c", batch[0].Data)
+	assert.JSONEq(t, `{"vote": "cats"}`, batch[1].Data)
+}
+
+func TestStreamRereadsPendingEntriesAfterRestart(t *testing.T) {
+	_, client := newTestRedis(t)
+	ctx := context.Background()
+	addVotes(t, client, "a", "b")
+
+	crashed := newTestStream(t, client, "worker-1")
+	batch, err := crashed.Receive(ctx, 0, BatchLimits{Size: 10, Wait: 10 * time.Millisecond})
+	require.NoError(t, err)
+	require.Len(t, batch, 2)
+
+	// The restarted worker gets the same entries back before new ones
+	addVotes(t, client, "c")
+	q := newTestStream(t, client, "worker-1")
+	recovered, err := q.Receive(ctx, 0, BatchLimits{Size: 10, Wait: 10 * time.Millisecond})
+	require.NoError(t, err)
+	assert.Equal(t, batch, recovered)
+	assert.Equal(t, 2.0, testutil.ToFloat64(q.metrics.VotesRecovered))
+	require.NoError(t, q.Ack(ctx, recovered))
+
+	next, err := q.Receive(ctx, 0, BatchLimits{Size: 10, Wait: 10 * time.Millisecond})
+	require.NoError(t, err)
+	require.Len(t, next, 1)
+	assert.Equal(t, "c", next[0].Data)
+}
+
+func TestStreamClaimsStaleEntriesOfOtherConsumers(t *testing.T) {
+	server, client := newTestRedis(t)
+	ctx := context.Background()
+	start := time.Now()
+	server.SetTime(start)
+	addVotes(t, client, "a")
+
+	dead := newTestStream(t, client, "worker-1")
+	batch, err := dead.Receive(ctx, 0, BatchLimits{Size: 10, Wait: 10 * time.Millisecond})
+	require.NoError(t, err)
+	require.Len(t, batch, 1)
+
+	// Entries are left alone until idle for StreamClaimIdle
+	q := newTestStream(t, client, "worker-2")
+	claimed, err := q.Receive(ctx, 0, BatchLimits{Size: 10, Wait: 10 * time.Millisecond})
+	require.NoError(t, err)
+	assert.Empty(t, claimed)
+
+	server.SetTime(start.Add(2 * time.Minute))
+	q.consumers[0].lastClaim = time.Time{}
+	claimed, err = q.Receive(ctx, 0, BatchLimits{Size: 10, Wait: 10 * time.Millisecond})
+	require.NoError(t, err)
+	assert.Equal(t, batch, claimed)
+	assert.Equal(t, map[string]string{batch[0].ID: "worker-2-0"}, pendingConsumers(t, client))
+	assert.Equal(t, 1.0, testutil.ToFloat64(q.metrics.VotesRecovered))
+}
+
+func TestStreamRecreatesLostGroup(t *testing.T) {
+	_, client := newTestRedis(t)
+	q := newTestStream(t, client, "worker-1")
+	ctx := context.Background()
+
+	// As after a Redis restart without persistence
+	require.NoError(t, client.Del(ctx, "votes").Err())
+	addVotes(t, client, "a")
+
+	_, err := q.Receive(ctx, 0, BatchLimits{Size: 10, Wait: 10 * time.Millisecond})
+	require.ErrorContains(t, err, "NOGROUP")
+
+	batch, err := q.Receive(ctx, 0, BatchLimits{Size: 10, Wait: 10 * time.Millisecond})
+	require.NoError(t, err)
+	require.Len(t, batch, 1)
+	assert.Equal(t, "a", batch[0].Data)
+}
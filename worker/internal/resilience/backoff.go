@@ -0,0 +1,41 @@
+// Package resilience provides the retry policy and circuit breakers the
+// worker uses around Redis and the database.
+package resilience
+
+import (
+	"math"
+	"math/rand"
+	"time"
+)
+
+// Backoff is an exponential retry policy with jitter
+type Backoff struct {
+	// Initial is the delay before the first retry
+	Initial time.Duration
+	// Max caps the delay however many attempts have failed
+	Max time.Duration
+	// Multiplier grows the delay after each failed attempt
+	Multiplier float64
+}
+
+// NewBackoff creates a policy doubling the delay from initial up to max
+func NewBackoff(initial, max time.Duration) Backoff {
+	return Backoff{Initial: initial, Max: max, Multiplier: 2}
+}
+
+// Delay returns how long to wait after the given number of consecutive
+// failures, starting at 1. Half of the delay is random so that replicas
+// failing together do not retry in lockstep.
+func (b Backoff) Delay(failures int) time.Duration {
+	if failures < 1 {
+		return 0
+	}
+
+	delay := float64(b.Initial) * math.Pow(b.Multiplier, float64(failures-1))
+	if delay > float64(b.Max) || math.IsInf(delay, 0) {
+		delay = float64(b.Max)
+	}
+
+	half := delay / 2
+	return time.Duration(half + rand.Float64()*half)
+}
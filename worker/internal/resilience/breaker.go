@@ -0,0 +1,141 @@
+package resilience
+
+import (
+	"sync"
+	"time"
+)
+
+// State is the state of a circuit breaker
+type State int
+
+// Circuit breaker states
+const (
+	// Closed lets every call through
+	Closed State = iota
+	// HalfOpen lets a single trial call through after the cooldown
+	HalfOpen
+	// Open rejects calls until the cooldown has passed
+	Open
+)
+
+func (s State) String() string {
+	switch s {
+	case Closed:
+		return "closed"
+	case HalfOpen:
+		return "half-open"
+	case Open:
+		return "open"
+	default:
+		return "unknown"
+	}
+}
+
+// BreakerStatus is a snapshot of a circuit breaker
+type BreakerStatus struct {
+	State               string     `json:"state"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	OpenedAt            *time.Time `json:"opened_at,omitempty"`
+}
+
+// Breaker is a circuit breaker for one dependency. It opens after Threshold
+// consecutive failures, rejects calls for Cooldown, then lets one trial call
+// decide whether it closes again or stays open for another cooldown.
+type Breaker struct {
+	name      string
+	threshold int
+	cooldown  time.Duration
+	onChange  func(name string, from, to State)
+
+	mu       sync.Mutex
+	state    State
+	failures int
+	openedAt time.Time
+}
+
+// NewBreaker creates a closed breaker. onChange, if not nil, is called on
+// every state change while the breaker's lock is held.
+func NewBreaker(name string, threshold int, cooldown time.Duration, onChange func(name string, from, to State)) *Breaker {
+	return &Breaker{
+		name:      name,
+		threshold: threshold,
+		cooldown:  cooldown,
+		onChange:  onChange,
+	}
+}
+
+// Name returns the dependency the breaker guards
+func (b *Breaker) Name() string {
+	return b.name
+}
+
+// Allow reports whether a call may go ahead. Once the cooldown of an open
+// breaker has passed, exactly one caller is allowed with trial set; the
+// outcome it reports closes or reopens the breaker.
+func (b *Breaker) Allow() (allowed, trial bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case Closed:
+		return true, false
+	case Open:
+		if time.Since(b.openedAt) >= b.cooldown {
+			b.setState(HalfOpen)
+			return true, true
+		}
+	}
+	return false, false
+}
+
+// Success records a successful call, closing the breaker
+func (b *Breaker) Success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures = 0
+	if b.state != Closed {
+		b.setState(Closed)
+	}
+}
+
+// Failure records a failed call. It opens the breaker once the threshold is
+// reached, or again straight away when the trial call failed.
+func (b *Breaker) Failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures++
+	if b.state == HalfOpen || (b.state == Closed && b.failures >= b.threshold) {
+		b.openedAt = time.Now()
+		b.setState(Open)
+	}
+}
+
+// State returns the current state
+func (b *Breaker) State() State {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+// Status returns a snapshot for health reports
+func (b *Breaker) Status() BreakerStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	status := BreakerStatus{State: b.state.String(), ConsecutiveFailures: b.failures}
+	if b.state != Closed {
+		openedAt := b.openedAt
+		status.OpenedAt = &openedAt
+	}
+	return status
+}
+
+func (b *Breaker) setState(state State) {
+	from := b.state
+	b.state = state
+	if b.onChange != nil {
+		b.onChange(b.name, from, state)
+	}
+}
@@ -0,0 +1,58 @@
+package resilience
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackoffGrowsWithJitterUpToMax(t *testing.T) {
+	backoff := NewBackoff(100*time.Millisecond, time.Second)
+
+	assert.Zero(t, backoff.Delay(0))
+	for i := 0; i < 100; i++ {
+		assert.InDelta(t, 75*time.Millisecond, backoff.Delay(1), float64(25*time.Millisecond))
+		assert.InDelta(t, 300*time.Millisecond, backoff.Delay(3), float64(100*time.Millisecond))
+		assert.InDelta(t, 750*time.Millisecond, backoff.Delay(50), float64(250*time.Millisecond))
+		assert.InDelta(t, 750*time.Millisecond, backoff.Delay(5000), float64(250*time.Millisecond))
+	}
+}
+
+func TestBreakerOpensAndRecovers(t *testing.T) {
+	var transitions []string
+	breaker := NewBreaker("database", 3, 20*time.Millisecond, func(name string, from, to State) {
+		transitions = append(transitions, from.String()+">"+to.String())
+	})
+
+	breaker.Failure()
+	breaker.Failure()
+	allowed, _ := breaker.Allow()
+	assert.True(t, allowed, "below the threshold the breaker stays closed")
+
+	breaker.Failure()
+	assert.Equal(t, Open, breaker.State())
+	allowed, _ = breaker.Allow()
+	assert.False(t, allowed)
+
+	// After the cooldown one trial call is let through; its failure reopens
+	time.Sleep(25 * time.Millisecond)
+	allowed, trial := breaker.Allow()
+	assert.True(t, allowed)
+	assert.True(t, trial)
+	allowed, _ = breaker.Allow()
+	assert.False(t, allowed, "only one trial call at a time")
+	breaker.Failure()
+	assert.Equal(t, Open, breaker.State())
+
+	time.Sleep(25 * time.Millisecond)
+	_, trial = breaker.Allow()
+	assert.True(t, trial)
+	breaker.Success()
+	assert.Equal(t, Closed, breaker.State())
+	assert.Equal(t, BreakerStatus{State: "closed"}, breaker.Status())
+
+	assert.Equal(t, []string{
+		"closed>open", "open>half-open", "half-open>open", "open>half-open", "half-open>closed",
+	}, transitions)
+}
@@ -0,0 +1,229 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Vote is a decoded and validated vote ready to be stored
+type Vote struct {
+	VoteID    string
+	PollID    string
+	Choice    string
+	VoterID   string
+	Timestamp time.Time
+}
+
+// BatchResult describes what happened to the votes of a committed batch
+type BatchResult struct {
+	// Stored are the votes written to the votes table
+	Stored       []Vote
+	Deduplicated int
+	Replaced     int
+	// Rejected counts the votes refused by the voting policy, by reason
+	Rejected map[string]int
+	// Tallies are the count changes the batch made to vote_tallies
+	Tallies TallyDeltas
+	// Counts are the running tallies of the options the batch touched; they
+	// are only read when vote events are published or tallies are mirrored
+	// into Redis
+	Counts map[TallyKey]TallyCount
+}
+
+// InsertBatch writes votes with a single multi-row insert inside a transaction.
+// Votes whose vote_id is already stored are skipped and the voting policy is
+// applied before anything is written. On MySQL the insert is redone row by row
+// only when a concurrent batch stored some of the votes first. Cancelling ctx
+// rolls the transaction back unless it has already committed.
+func (s *SQLSink) InsertBatch(ctx context.Context, votes []Vote) (*BatchResult, error) {
+	result := &BatchResult{Rejected: make(map[string]int), Tallies: make(TallyDeltas)}
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+
+	existing, err := existingVoteIDs(tx, votes)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	fresh := make([]Vote, 0, len(votes))
+	for _, vote := range votes {
+		if existing[vote.VoteID] {
+			s.logger.WithField("vote_id", vote.VoteID).Debug("Skipping duplicate vote")
+			result.Deduplicated++
+			continue
+		}
+		// Later copies within the same batch are duplicates too
+		existing[vote.VoteID] = true
+		fresh = append(fresh, vote)
+	}
+
+	accepted, err := s.applyPolicy(tx, fresh, result)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	inserted, err := insertVotes(tx, accepted)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	// The rest were stored by a concurrent batch after the lookup above
+	result.Deduplicated += len(accepted) - len(inserted)
+
+	if s.config.VotePolicy == PolicyAppend {
+		if err := recordBallots(tx, inserted); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+
+	// Keep the running tallies in step with the rows written above, leaving
+	// out those a concurrent batch stored and counted itself
+	for _, vote := range inserted {
+		result.Tallies.Add(vote.PollID, vote.Choice, 1)
+	}
+	if err := updateTallies(tx, result.Tallies); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if s.config.EventsChannel != "" || s.config.TallyRedisHash != "" {
+		if result.Counts, err = queryTallyCounts(tx, result.Tallies); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit batch: %w", err)
+	}
+
+	result.Stored = inserted
+	return result, nil
+}
+
+// insertVotesPrefix starts an insert into votes; the value tuples follow
+const insertVotesPrefix = "INSERT INTO votes (vote_id, poll_id, vote, voter_id, timestamp) VALUES "
+
+// insertVotes writes votes, skipping those whose vote_id a concurrent batch
+// stored since existingVoteIDs ran, and returns the votes actually written
+func insertVotes(tx *sqlTx, votes []Vote) ([]Vote, error) {
+	if len(votes) == 0 {
+		return nil, nil
+	}
+
+	// Ignoring conflicts keeps a concurrent redelivery from failing the whole batch
+	placeholders := make([]string, 0, len(votes))
+	args := make([]interface{}, 0, len(votes)*5)
+	for _, vote := range votes {
+		placeholders = append(placeholders, "(?, ?, ?, ?, ?)")
+		args = append(args, vote.VoteID, vote.PollID, vote.Choice, vote.VoterID, vote.Timestamp)
+	}
+	query := insertVotesPrefix + strings.Join(placeholders, ", ") + tx.dialect.insertIgnore("id")
+
+	returning := tx.dialect.returning("vote_id")
+	if returning == "" {
+		return insertVotesCounted(tx, votes, query, args)
+	}
+
+	rows, err := tx.Query(query+returning, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to insert batch: %w", err)
+	}
+	defer rows.Close()
+
+	written := make(map[string]bool, len(votes))
+	for rows.Next() {
+		var voteID string
+		if err := rows.Scan(&voteID); err != nil {
+			return nil, fmt.Errorf("failed to read inserted vote id: %w", err)
+		}
+		written[voteID] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to insert batch: %w", err)
+	}
+
+	inserted := make([]Vote, 0, len(written))
+	for _, vote := range votes {
+		if written[vote.VoteID] {
+			inserted = append(inserted, vote)
+		}
+	}
+	return inserted, nil
+}
+
+// insertVotesCounted runs the multi-row insert for dialects without RETURNING.
+// Skipped rows are not counted as affected (the DSN leaves clientFoundRows
+// off), so a full count means every vote was written. A short count only
+// happens when a concurrent batch stored some of the votes first; the insert
+// is then undone and redone row by row to learn which votes were written.
+func insertVotesCounted(tx *sqlTx, votes []Vote, query string, args []interface{}) ([]Vote, error) {
+	if _, err := tx.Exec("SAVEPOINT insert_votes"); err != nil {
+		return nil, fmt.Errorf("failed to insert batch: %w", err)
+	}
+
+	res, err := tx.Exec(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to insert batch: %w", err)
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return nil, fmt.Errorf("failed to insert batch: %w", err)
+	}
+	if affected == int64(len(votes)) {
+		return votes, nil
+	}
+
+	if _, err := tx.Exec("ROLLBACK TO SAVEPOINT insert_votes"); err != nil {
+		return nil, fmt.Errorf("failed to insert batch: %w", err)
+	}
+
+	inserted := make([]Vote, 0, len(votes))
+	for i, vote := range votes {
+		res, err := tx.Exec(insertVotesPrefix+"(?, ?, ?, ?, ?)"+tx.dialect.insertIgnore("id"), args[i*5:i*5+5]...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to insert vote: %w", err)
+		}
+		affected, err := res.RowsAffected()
+		if err != nil {
+			return nil, fmt.Errorf("failed to insert vote: %w", err)
+		}
+		if affected > 0 {
+			inserted = append(inserted, vote)
+		}
+	}
+	return inserted, nil
+}
+
+// existingVoteIDs returns which vote_ids of a batch are already stored
+func existingVoteIDs(tx *sqlTx, votes []Vote) (map[string]bool, error) {
+	placeholders := make([]string, 0, len(votes))
+	args := make([]interface{}, 0, len(votes))
+	for _, vote := range votes {
+		placeholders = append(placeholders, "?")
+		args = append(args, vote.VoteID)
+	}
+
+	rows, err := tx.Query("SELECT vote_id FROM votes WHERE vote_id IN ("+strings.Join(placeholders, ", ")+")", args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up vote ids: %w", err)
+	}
+	defer rows.Close()
+
+	existing := make(map[string]bool, len(votes))
+	for rows.Next() {
+		var voteID string
+		if err := rows.Scan(&voteID); err != nil {
+			return nil, fmt.Errorf("failed to read vote id: %w", err)
+		}
+		existing[voteID] = true
+	}
+	return existing, rows.Err()
+}
@@ -0,0 +1,164 @@
+package store
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"worker/internal/config"
+)
+
+// newMockSink creates a MySQL sink on a mock database that expects
+// statements in order
+func newMockSink(t *testing.T, policy string) (*SQLSink, sqlmock.Sqlmock) {
+	t.Helper()
+
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	sink := &SQLSink{
+		db:     &sqlDB{DB: db, dialect: mysqlDialect{}},
+		config: &config.Config{VotePolicy: policy},
+		logger: logger,
+	}
+	return sink, mock
+}
+
+// pollVote builds a vote in the pets poll cast at the given offset from a fixed time
+func pollVote(voteID, choice, voterID string, offset time.Duration) Vote {
+	at := time.Date(2023, 1, 1, 12, 0, 0, 0, time.UTC).Add(offset)
+	return Vote{VoteID: voteID, PollID: "pets", Choice: choice, VoterID: voterID, Timestamp: at}
+}
+
+// expectBallots expects append batches to record the ballots of the voters
+// whose votes they stored
+func expectBallots(mock sqlmock.Sqlmock) {
+	mock.ExpectExec(`INSERT INTO voter_ballots \(poll_id, voter_id, vote_id, vote, timestamp\) VALUES .* ON DUPLICATE KEY UPDATE vote_id = CASE WHEN VALUES\(timestamp\) >= voter_ballots.timestamp`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+}
+
+// expectSavepoint expects the savepoint MySQL batches are inserted after
+func expectSavepoint(mock sqlmock.Sqlmock) {
+	mock.ExpectExec(`SAVEPOINT insert_votes`).WillReturnResult(sqlmock.NewResult(0, 0))
+}
+
+func TestInsertBatchWritesOneMultiRowInsert(t *testing.T) {
+	sink, mock := newMockSink(t, PolicyAppend)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT vote_id FROM votes WHERE vote_id IN`).
+		WillReturnRows(sqlmock.NewRows([]string{"vote_id"}))
+	expectSavepoint(mock)
+	mock.ExpectExec(`INSERT INTO votes \(vote_id, poll_id, vote, voter_id, timestamp\) VALUES \(\?, \?, \?, \?, \?\), \(\?, \?, \?, \?, \?\), \(\?, \?, \?, \?, \?\) ON DUPLICATE KEY UPDATE id = id`).
+		WithArgs(
+			"v1", "pets", "cats", "user1", sqlmock.AnyArg(),
+			"v2", "pets", "dogs", "user2", sqlmock.AnyArg(),
+			"v3", "pets", "cats", "user3", sqlmock.AnyArg(),
+		).
+		WillReturnResult(sqlmock.NewResult(1, 3))
+	expectBallots(mock)
+	mock.ExpectExec(`INSERT INTO vote_tallies`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	result, err := sink.InsertBatch(context.Background(), []Vote{
+		pollVote("v1", "cats", "user1", 0),
+		pollVote("v2", "dogs", "user2", time.Second),
+		pollVote("v3", "cats", "user3", 2*time.Second),
+	})
+	require.NoError(t, err)
+	require.NoError(t, mock.ExpectationsWereMet())
+	assert.Len(t, result.Stored, 3)
+	assert.Equal(t, TallyDeltas{{Poll: "pets", Vote: "cats"}: 2, {Poll: "pets", Vote: "dogs"}: 1}, result.Tallies)
+}
+
+func TestInsertBatchStoresOnlyRowsMySQLWrote(t *testing.T) {
+	sink, mock := newMockSink(t, PolicyAppend)
+
+	// v2 is stored by another worker between the lookup and the insert, so
+	// the batch is written again row by row to learn which votes are new
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT vote_id FROM votes WHERE vote_id IN`).
+		WillReturnRows(sqlmock.NewRows([]string{"vote_id"}))
+	expectSavepoint(mock)
+	mock.ExpectExec(`INSERT INTO votes .* VALUES \(\?, \?, \?, \?, \?\), \(\?, \?, \?, \?, \?\), \(\?, \?, \?, \?, \?\) `).
+		WillReturnResult(sqlmock.NewResult(1, 2))
+	mock.ExpectExec(`ROLLBACK TO SAVEPOINT insert_votes`).WillReturnResult(sqlmock.NewResult(0, 0))
+	for _, row := range []struct {
+		voteID, choice, voterID string
+		affected                int64
+	}{
+		{"v1", "cats", "user1", 1},
+		{"v2", "dogs", "user2", 0},
+		{"v3", "cats", "user3", 1},
+	} {
+		mock.ExpectExec(`INSERT INTO votes \(vote_id, poll_id, vote, voter_id, timestamp\) VALUES \(\?, \?, \?, \?, \?\) ON DUPLICATE KEY UPDATE id = id`).
+			WithArgs(row.voteID, "pets", row.choice, row.voterID, sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(0, row.affected))
+	}
+	expectBallots(mock)
+	mock.ExpectExec(`INSERT INTO vote_tallies`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	result, err := sink.InsertBatch(context.Background(), []Vote{
+		pollVote("v1", "cats", "user1", 0),
+		pollVote("v2", "dogs", "user2", time.Second),
+		pollVote("v3", "cats", "user3", 2*time.Second),
+	})
+	require.NoError(t, err)
+	require.NoError(t, mock.ExpectationsWereMet())
+	require.Len(t, result.Stored, 2)
+	assert.Equal(t, "v1", result.Stored[0].VoteID)
+	assert.Equal(t, "v3", result.Stored[1].VoteID)
+	assert.Equal(t, 1, result.Deduplicated)
+	assert.Equal(t, TallyDeltas{{Poll: "pets", Vote: "cats"}: 2}, result.Tallies)
+}
+
+func TestInsertBatchRollsBackFailedInsert(t *testing.T) {
+	sink, mock := newMockSink(t, PolicyAppend)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT vote_id FROM votes`).WillReturnRows(sqlmock.NewRows([]string{"vote_id"}))
+	expectSavepoint(mock)
+	mock.ExpectExec(`INSERT INTO votes`).WillReturnError(assert.AnError)
+	mock.ExpectRollback()
+
+	_, err := sink.InsertBatch(context.Background(), []Vote{pollVote("v1", "cats", "user1", 0), pollVote("v2", "dogs", "user2", 0)})
+	assert.ErrorIs(t, err, assert.AnError)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestInsertBatchSkipsStoredVoteIDs(t *testing.T) {
+	sink, mock := newMockSink(t, PolicyAppend)
+
+	// v1 is already stored and v2 is redelivered within the batch
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT vote_id FROM votes`).
+		WillReturnRows(sqlmock.NewRows([]string{"vote_id"}).AddRow("v1"))
+	expectSavepoint(mock)
+	mock.ExpectExec(`INSERT INTO votes \(vote_id, poll_id, vote, voter_id, timestamp\) VALUES \(\?, \?, \?, \?, \?\) ON DUPLICATE KEY UPDATE`).
+		WithArgs("v2", "pets", "dogs", "user2", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(2, 1))
+	expectBallots(mock)
+	mock.ExpectExec(`INSERT INTO vote_tallies`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	result, err := sink.InsertBatch(context.Background(), []Vote{
+		pollVote("v1", "cats", "user1", 0),
+		pollVote("v2", "dogs", "user2", 0),
+		pollVote("v2", "dogs", "user2", 0),
+	})
+	require.NoError(t, err)
+	require.Len(t, result.Stored, 1)
+	assert.Equal(t, "v2", result.Stored[0].VoteID)
+	assert.Equal(t, 2, result.Deduplicated)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
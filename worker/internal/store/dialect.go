@@ -0,0 +1,333 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	_ "github.com/go-sql-driver/mysql"
+	_ "github.com/lib/pq"
+	_ "modernc.org/sqlite"
+
+	"worker/internal/config"
+)
+
+// Database drivers, selected by DB_DRIVER
+const (
+	DriverMySQL    = "mysql"
+	DriverPostgres = "postgres"
+	DriverSQLite   = "sqlite"
+)
+
+// dialect covers the SQL differences between the supported databases. Queries
+// are written with ? placeholders and MySQL-compatible syntax otherwise; the
+// dialect supplies the pieces that have no common form.
+type dialect interface {
+	// name is the DB_DRIVER value selecting the dialect
+	name() string
+	// open opens the connection pool described by the configuration
+	open(config *config.Config) (*sql.DB, error)
+	// rebind rewrites ? placeholders into the driver's syntax
+	rebind(query string) string
+	// upsert returns the clause that applies assignments when an insert
+	// conflicts on the given key columns
+	upsert(key []string, assignments string) string
+	// insertIgnore returns the clause that skips conflicting rows; column is
+	// any column of the table, used where a no-op assignment is required
+	insertIgnore(column string) string
+	// returning returns the clause making an insert report a column of the
+	// rows it wrote, or "" when the database has none
+	returning(column string) string
+	// excluded refers to the value a conflicting insert proposed for a column
+	excluded(column string) string
+	// forUpdate returns the suffix locking rows read inside a transaction
+	forUpdate() string
+	// snapshotIsolation is the isolation level giving a consistent read
+	snapshotIsolation() sql.IsolationLevel
+	// lock takes a named advisory lock on conn, waiting up to timeout
+	lock(ctx context.Context, conn *sql.Conn, name string, timeout time.Duration) (bool, error)
+	// unlock releases a lock taken with lock
+	unlock(ctx context.Context, conn *sql.Conn, name string)
+	// transactionalDDL reports whether schema changes can be rolled back
+	// as part of a transaction
+	transactionalDDL() bool
+	// tableExists reports whether a table exists
+	tableExists(db *sqlDB, table string) (bool, error)
+	// baselineProbes detect migrations already made by the pre-migration
+	// initDB; it is nil for databases that initDB never supported
+	baselineProbes() map[int]func(db *sqlDB) (bool, error)
+}
+
+// dialectFor returns the dialect of a DB_DRIVER value
+func dialectFor(driver string) (dialect, error) {
+	switch driver {
+	case DriverMySQL:
+		return mysqlDialect{}, nil
+	case DriverPostgres:
+		return postgresDialect{}, nil
+	case DriverSQLite:
+		return sqliteDialect{}, nil
+	default:
+		return nil, fmt.Errorf("unknown database driver %q", driver)
+	}
+}
+
+// mysqlDialect is the original dialect of the worker
+type mysqlDialect struct{}
+
+func (mysqlDialect) name() string { return DriverMySQL }
+
+func (mysqlDialect) open(config *config.Config) (*sql.DB, error) {
+	dsn := fmt.Sprintf("%s:%s@tcp(%s:%d)/%s?parseTime=true",
+		config.MySQLUser, config.MySQLPassword,
+		config.MySQLHost, config.MySQLPort,
+		config.MySQLDatabase)
+	return sql.Open("mysql", dsn)
+}
+
+func (mysqlDialect) rebind(query string) string { return query }
+
+func (mysqlDialect) upsert(key []string, assignments string) string {
+	return " ON DUPLICATE KEY UPDATE " + assignments
+}
+
+func (mysqlDialect) insertIgnore(column string) string {
+	return fmt.Sprintf(" ON DUPLICATE KEY UPDATE %s = %s", column, column)
+}
+
+// returning is empty; MySQL reports written rows through RowsAffected only
+func (mysqlDialect) returning(column string) string { return "" }
+
+func (mysqlDialect) excluded(column string) string { return "VALUES(" + column + ")" }
+
+func (mysqlDialect) forUpdate() string { return " FOR UPDATE" }
+
+func (mysqlDialect) snapshotIsolation() sql.IsolationLevel { return sql.LevelRepeatableRead }
+
+func (mysqlDialect) lock(ctx context.Context, conn *sql.Conn, name string, timeout time.Duration) (bool, error) {
+	var acquired sql.NullInt64
+	err := conn.QueryRowContext(ctx, "SELECT GET_LOCK(?, ?)", name, int(timeout.Seconds())).Scan(&acquired)
+	return acquired.Int64 == 1, err
+}
+
+func (mysqlDialect) unlock(ctx context.Context, conn *sql.Conn, name string) {
+	conn.ExecContext(ctx, "SELECT RELEASE_LOCK(?)", name)
+}
+
+// transactionalDDL is false; MySQL commits implicitly before each DDL statement
+func (mysqlDialect) transactionalDDL() bool { return false }
+
+func (mysqlDialect) tableExists(db *sqlDB, table string) (bool, error) {
+	var count int
+	err := db.QueryRow(`
+		SELECT COUNT(*) FROM INFORMATION_SCHEMA.TABLES
+		WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ?`, table).Scan(&count)
+	return count > 0, err
+}
+
+func (d mysqlDialect) baselineProbes() map[int]func(db *sqlDB) (bool, error) {
+	return map[int]func(db *sqlDB) (bool, error){
+		1: func(db *sqlDB) (bool, error) { return d.tableExists(db, "votes") },
+		2: func(db *sqlDB) (bool, error) { return mysqlIndexExists(db, "votes", "idx_vote") },
+		3: func(db *sqlDB) (bool, error) { return mysqlColumnExists(db, "votes", "vote_id") },
+		4: func(db *sqlDB) (bool, error) { return d.tableExists(db, "voter_ballots") },
+	}
+}
+
+func mysqlColumnExists(db *sqlDB, table, column string) (bool, error) {
+	var count int
+	err := db.QueryRow(`
+		SELECT COUNT(*) FROM INFORMATION_SCHEMA.COLUMNS
+		WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? AND COLUMN_NAME = ?`, table, column).Scan(&count)
+	return count > 0, err
+}
+
+func mysqlIndexExists(db *sqlDB, table, index string) (bool, error) {
+	var count int
+	err := db.QueryRow(`
+		SELECT COUNT(*) FROM INFORMATION_SCHEMA.STATISTICS
+		WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? AND INDEX_NAME = ?`, table, index).Scan(&count)
+	return count > 0, err
+}
+
+// postgresDialect targets PostgreSQL through lib/pq
+type postgresDialect struct{}
+
+// postgresLockPollInterval is how often a waiting advisory lock is retried
+const postgresLockPollInterval = 250 * time.Millisecond
+
+func (postgresDialect) name() string { return DriverPostgres }
+
+func (postgresDialect) open(config *config.Config) (*sql.DB, error) {
+	dsn := url.URL{
+		Scheme:   "postgres",
+		User:     url.UserPassword(config.PostgresUser, config.PostgresPassword),
+		Host:     fmt.Sprintf("%s:%d", config.PostgresHost, config.PostgresPort),
+		Path:     config.PostgresDatabase,
+		RawQuery: url.Values{"sslmode": {config.PostgresSSLMode}}.Encode(),
+	}
+	return sql.Open("postgres", dsn.String())
+}
+
+func (postgresDialect) rebind(query string) string {
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+func (postgresDialect) upsert(key []string, assignments string) string {
+	return fmt.Sprintf(" ON CONFLICT (%s) DO UPDATE SET %s", strings.Join(key, ", "), assignments)
+}
+
+func (postgresDialect) insertIgnore(column string) string { return " ON CONFLICT DO NOTHING" }
+
+func (postgresDialect) returning(column string) string { return " RETURNING " + column }
+
+func (postgresDialect) excluded(column string) string { return "excluded." + column }
+
+func (postgresDialect) forUpdate() string { return " FOR UPDATE" }
+
+func (postgresDialect) snapshotIsolation() sql.IsolationLevel { return sql.LevelRepeatableRead }
+
+// lock polls pg_try_advisory_lock, since pg_advisory_lock cannot time out
+// without changing the session's lock_timeout
+func (postgresDialect) lock(ctx context.Context, conn *sql.Conn, name string, timeout time.Duration) (bool, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		var acquired bool
+		if err := conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock(hashtext($1))", name).Scan(&acquired); err != nil {
+			return false, err
+		}
+		if acquired || !time.Now().Before(deadline) {
+			return acquired, nil
+		}
+		select {
+		case <-ctx.Done():
+			return false, ctx.Err()
+		case <-time.After(postgresLockPollInterval):
+		}
+	}
+}
+
+func (postgresDialect) unlock(ctx context.Context, conn *sql.Conn, name string) {
+	conn.ExecContext(ctx, "SELECT pg_advisory_unlock(hashtext($1))", name)
+}
+
+func (postgresDialect) transactionalDDL() bool { return true }
+
+func (postgresDialect) tableExists(db *sqlDB, table string) (bool, error) {
+	var count int
+	err := db.QueryRow(`
+		SELECT COUNT(*) FROM information_schema.tables
+		WHERE table_schema = current_schema() AND table_name = ?`, table).Scan(&count)
+	return count > 0, err
+}
+
+func (postgresDialect) baselineProbes() map[int]func(db *sqlDB) (bool, error) { return nil }
+
+// sqliteDialect targets a local SQLite file through the pure-Go modernc driver
+type sqliteDialect struct{}
+
+func (sqliteDialect) name() string { return DriverSQLite }
+
+// open enables WAL so readers do not block the writer, waits on locks instead
+// of failing, and starts transactions as writers so that concurrent batches
+// queue up rather than deadlock when upgrading their locks
+func (sqliteDialect) open(config *config.Config) (*sql.DB, error) {
+	dsn := "file:" + config.SQLitePath +
+		"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_txlock=immediate&_time_format=sqlite"
+	return sql.Open("sqlite", dsn)
+}
+
+func (sqliteDialect) rebind(query string) string { return query }
+
+func (sqliteDialect) upsert(key []string, assignments string) string {
+	return fmt.Sprintf(" ON CONFLICT (%s) DO UPDATE SET %s", strings.Join(key, ", "), assignments)
+}
+
+func (sqliteDialect) insertIgnore(column string) string { return " ON CONFLICT DO NOTHING" }
+
+func (sqliteDialect) returning(column string) string { return " RETURNING " + column }
+
+func (sqliteDialect) excluded(column string) string { return "excluded." + column }
+
+// forUpdate is empty; SQLite write transactions already exclude each other
+func (sqliteDialect) forUpdate() string { return "" }
+
+func (sqliteDialect) snapshotIsolation() sql.IsolationLevel { return sql.LevelDefault }
+
+// lock always succeeds; a SQLite file is not shared between replicas
+func (sqliteDialect) lock(ctx context.Context, conn *sql.Conn, name string, timeout time.Duration) (bool, error) {
+	return true, nil
+}
+
+func (sqliteDialect) unlock(ctx context.Context, conn *sql.Conn, name string) {}
+
+func (sqliteDialect) transactionalDDL() bool { return true }
+
+func (sqliteDialect) tableExists(db *sqlDB, table string) (bool, error) {
+	var count int
+	err := db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?", table).Scan(&count)
+	return count > 0, err
+}
+
+func (sqliteDialect) baselineProbes() map[int]func(db *sqlDB) (bool, error) { return nil }
+
+// sqlDB is a connection pool that rewrites ? placeholders for its dialect
+type sqlDB struct {
+	*sql.DB
+	dialect dialect
+}
+
+func (db *sqlDB) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return db.DB.Exec(db.dialect.rebind(query), args...)
+}
+
+func (db *sqlDB) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return db.DB.Query(db.dialect.rebind(query), args...)
+}
+
+func (db *sqlDB) QueryRow(query string, args ...interface{}) *sql.Row {
+	return db.DB.QueryRow(db.dialect.rebind(query), args...)
+}
+
+func (db *sqlDB) Begin(ctx context.Context) (*sqlTx, error) {
+	tx, err := db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &sqlTx{Tx: tx, ctx: ctx, dialect: db.dialect}, nil
+}
+
+// sqlTx is a transaction that rewrites ? placeholders for its dialect. Its
+// statements run on the context it was begun with; cancelling that context
+// interrupts them and rolls the transaction back.
+type sqlTx struct {
+	*sql.Tx
+	ctx     context.Context
+	dialect dialect
+}
+
+func (tx *sqlTx) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return tx.Tx.ExecContext(tx.ctx, tx.dialect.rebind(query), args...)
+}
+
+func (tx *sqlTx) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return tx.Tx.QueryContext(tx.ctx, tx.dialect.rebind(query), args...)
+}
+
+func (tx *sqlTx) QueryRow(query string, args ...interface{}) *sql.Row {
+	return tx.Tx.QueryRowContext(tx.ctx, tx.dialect.rebind(query), args...)
+}
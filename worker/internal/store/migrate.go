@@ -0,0 +1,462 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+//go:embed migrations
+var migrationFiles embed.FS
+
+const (
+	// migrationLockName is the advisory lock serializing migrations across replicas
+	migrationLockName = "voting_schema_migrations"
+	// migrationLockTimeout is how long to wait for another replica to finish migrating
+	migrationLockTimeout = 60 * time.Second
+)
+
+var migrationFilePattern = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// Migration is one versioned schema change
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// MigrationStatus reports whether a migration has been applied
+type MigrationStatus struct {
+	Version   int
+	Name      string
+	AppliedAt *time.Time
+	// Baseline is set for pending migrations that the next Up records as
+	// already made by the pre-migration schema, without running them
+	Baseline bool
+}
+
+// execer runs statements on the database or inside a transaction
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// Migrator applies the embedded migrations of its database's dialect and
+// records them in schema_migrations
+type Migrator struct {
+	db         *sqlDB
+	logger     *logrus.Logger
+	migrations []Migration
+}
+
+// NewMigrator creates a migrator for the embedded migrations of the database's dialect
+func NewMigrator(db *sqlDB, logger *logrus.Logger) (*Migrator, error) {
+	migrations, err := loadMigrations(migrationFiles, "migrations/"+db.dialect.name())
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{db: db, logger: logger, migrations: migrations}, nil
+}
+
+// loadMigrations reads NNNN_name.up.sql / NNNN_name.down.sql pairs ordered by version
+func loadMigrations(fsys fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read migrations: %w", err)
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, entry := range entries {
+		match := migrationFilePattern.FindStringSubmatch(entry.Name())
+		if match == nil {
+			continue
+		}
+
+		version, _ := strconv.Atoi(match[1])
+		content, err := fs.ReadFile(fsys, dir+"/"+entry.Name())
+		if err != nil {
+			return nil, fmt.Errorf("failed to read migration %s: %w", entry.Name(), err)
+		}
+
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = migration
+		} else if migration.Name != match[2] {
+			return nil, fmt.Errorf("migration %d has conflicting names %q and %q", version, migration.Name, match[2])
+		}
+
+		if match[3] == "up" {
+			migration.Up = string(content)
+		} else {
+			migration.Down = string(content)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if migration.Up == "" {
+			return nil, fmt.Errorf("migration %d_%s has no up script", migration.Version, migration.Name)
+		}
+		migrations = append(migrations, *migration)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// Up applies all pending migrations
+func (m *Migrator) Up() error {
+	return m.withLock(func() error {
+		applied, err := m.prepare()
+		if err != nil {
+			return err
+		}
+
+		for _, migration := range m.migrations {
+			if _, ok := applied[migration.Version]; ok {
+				continue
+			}
+			if err := m.apply(migration); err != nil {
+				return err
+			}
+		}
+
+		m.logger.Info("Database schema is up to date")
+		return nil
+	})
+}
+
+// Down rolls back the given number of most recently applied migrations
+func (m *Migrator) Down(steps int) error {
+	return m.withLock(func() error {
+		applied, err := m.prepare()
+		if err != nil {
+			return err
+		}
+
+		for i := len(m.migrations) - 1; i >= 0 && steps > 0; i-- {
+			migration := m.migrations[i]
+			if _, ok := applied[migration.Version]; !ok {
+				continue
+			}
+			if err := m.revert(migration); err != nil {
+				return err
+			}
+			steps--
+		}
+		return nil
+	})
+}
+
+// Status lists every known migration and when it was applied. It only reads
+// the database, which it leaves untouched even before the first Up.
+func (m *Migrator) Status() ([]MigrationStatus, error) {
+	tracked, err := m.db.dialect.tableExists(m.db, "schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	applied := make(map[int]time.Time)
+	baselined := make(map[int]bool)
+	if tracked {
+		if applied, err = m.applied(); err != nil {
+			return nil, err
+		}
+	}
+	if len(applied) == 0 {
+		if baselined, err = m.baselined(); err != nil {
+			return nil, err
+		}
+	}
+
+	statuses := make([]MigrationStatus, 0, len(m.migrations))
+	for _, migration := range m.migrations {
+		status := MigrationStatus{Version: migration.Version, Name: migration.Name, Baseline: baselined[migration.Version]}
+		if appliedAt, ok := applied[migration.Version]; ok {
+			status.AppliedAt = &appliedAt
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+// withLock runs fn while holding the migration advisory lock. Advisory locks
+// are scoped to a connection, so a dedicated connection holds it throughout.
+func (m *Migrator) withLock(fn func() error) error {
+	ctx := context.Background()
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to reserve migration connection: %w", err)
+	}
+	defer conn.Close()
+
+	acquired, err := m.db.dialect.lock(ctx, conn, migrationLockName, migrationLockTimeout)
+	if err != nil {
+		return fmt.Errorf("failed to acquire migration lock: %w", err)
+	}
+	if !acquired {
+		return fmt.Errorf("timed out waiting for migration lock after %s", migrationLockTimeout)
+	}
+	defer m.db.dialect.unlock(ctx, conn, migrationLockName)
+
+	return fn()
+}
+
+// prepare creates schema_migrations, baselines a legacy database on first
+// run and returns the applied versions. Creating the table and baselining are
+// separate steps, so an empty schema_migrations is baselined again in case an
+// earlier run stopped in between.
+func (m *Migrator) prepare() (map[int]time.Time, error) {
+	tracked, err := m.db.dialect.tableExists(m.db, "schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	if !tracked {
+		_, err := m.db.Exec(`
+			CREATE TABLE schema_migrations (
+				version INT PRIMARY KEY,
+				name VARCHAR(255) NOT NULL,
+				applied_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+			)
+		`)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create schema_migrations table: %w", err)
+		}
+	}
+
+	applied, err := m.applied()
+	if err != nil {
+		return nil, err
+	}
+	if len(applied) == 0 {
+		if err := m.baseline(); err != nil {
+			return nil, err
+		}
+		if applied, err = m.applied(); err != nil {
+			return nil, err
+		}
+	}
+
+	if !m.db.dialect.transactionalDDL() {
+		_, err := m.db.Exec(`
+			CREATE TABLE IF NOT EXISTS schema_migration_progress (
+				version INT NOT NULL,
+				direction VARCHAR(4) NOT NULL,
+				statements INT NOT NULL,
+				PRIMARY KEY (version, direction)
+			)
+		`)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create schema_migration_progress table: %w", err)
+		}
+	}
+
+	return applied, nil
+}
+
+// applied reads the applied versions from schema_migrations
+func (m *Migrator) applied() (map[int]time.Time, error) {
+	rows, err := m.db.Query("SELECT version, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read schema_migrations: %w", err)
+	}
+	defer rows.Close()
+
+	applied := make(map[int]time.Time)
+	for rows.Next() {
+		var version int
+		var appliedAt time.Time
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, fmt.Errorf("failed to read schema_migrations: %w", err)
+		}
+		applied[version] = appliedAt
+	}
+	return applied, rows.Err()
+}
+
+// baseline records migrations whose changes were already made by the old
+// initDB, so they are not re-run against an existing database
+func (m *Migrator) baseline() error {
+	baselined, err := m.baselined()
+	if err != nil {
+		return err
+	}
+
+	for _, migration := range m.migrations {
+		if !baselined[migration.Version] {
+			continue
+		}
+		if err := m.record(m.db, migration); err != nil {
+			return err
+		}
+		m.logger.WithFields(logrus.Fields{
+			"version": migration.Version,
+			"name":    migration.Name,
+		}).Info("Baselined existing schema migration")
+	}
+	return nil
+}
+
+// baselined probes a legacy database for the migrations the old initDB made
+func (m *Migrator) baselined() (map[int]bool, error) {
+	baselined := make(map[int]bool)
+	probes := m.db.dialect.baselineProbes()
+	if probes == nil {
+		return baselined, nil
+	}
+
+	legacy, err := m.db.dialect.tableExists(m.db, "votes")
+	if err != nil || !legacy {
+		return baselined, err
+	}
+
+	for _, migration := range m.migrations {
+		probe, ok := probes[migration.Version]
+		if !ok {
+			continue
+		}
+
+		present, err := probe(m.db)
+		if err != nil {
+			return nil, fmt.Errorf("failed to inspect schema for migration %d: %w", migration.Version, err)
+		}
+		baselined[migration.Version] = present
+	}
+	return baselined, nil
+}
+
+// apply runs a migration's up script and records it
+func (m *Migrator) apply(migration Migration) error {
+	err := m.run(migration, "up", migration.Up, func(db execer) error { return m.record(db, migration) })
+	if err != nil {
+		return fmt.Errorf("migration %d_%s failed: %w", migration.Version, migration.Name, err)
+	}
+
+	m.logger.WithFields(logrus.Fields{
+		"version": migration.Version,
+		"name":    migration.Name,
+	}).Info("Applied schema migration")
+	return nil
+}
+
+// revert runs a migration's down script and removes its record
+func (m *Migrator) revert(migration Migration) error {
+	if migration.Down == "" {
+		return fmt.Errorf("migration %d_%s cannot be rolled back", migration.Version, migration.Name)
+	}
+
+	err := m.run(migration, "down", migration.Down, func(db execer) error {
+		if _, err := db.Exec("DELETE FROM schema_migrations WHERE version = ?", migration.Version); err != nil {
+			return fmt.Errorf("failed to unrecord migration %d: %w", migration.Version, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("rollback of %d_%s failed: %w", migration.Version, migration.Name, err)
+	}
+
+	m.logger.WithFields(logrus.Fields{
+		"version": migration.Version,
+		"name":    migration.Name,
+	}).Info("Rolled back schema migration")
+	return nil
+}
+
+// run executes one direction of a migration, then updates schema_migrations
+// through finish. Where DDL is transactional, both happen in one transaction
+// and a failed script leaves no trace. Elsewhere each statement is recorded
+// in schema_migration_progress once it succeeds, so that a script that
+// failed partway resumes after the statements already made.
+func (m *Migrator) run(migration Migration, direction, script string, finish func(db execer) error) error {
+	statements := splitStatements(script)
+
+	if m.db.dialect.transactionalDDL() {
+		tx, err := m.db.Begin(context.Background())
+		if err != nil {
+			return fmt.Errorf("failed to begin transaction: %w", err)
+		}
+		defer tx.Rollback()
+
+		for _, statement := range statements {
+			if _, err := tx.Exec(statement); err != nil {
+				return err
+			}
+		}
+		if err := finish(tx); err != nil {
+			return err
+		}
+		return tx.Commit()
+	}
+
+	var done int
+	err := m.db.QueryRow("SELECT statements FROM schema_migration_progress WHERE version = ? AND direction = ?",
+		migration.Version, direction).Scan(&done)
+	if err != nil && err != sql.ErrNoRows {
+		return fmt.Errorf("failed to read migration progress: %w", err)
+	}
+	if done > 0 {
+		m.logger.WithFields(logrus.Fields{
+			"version":   migration.Version,
+			"name":      migration.Name,
+			"direction": direction,
+			"completed": done,
+		}).Warn("Resuming partially run schema migration")
+	}
+
+	for i := done; i < len(statements); i++ {
+		if _, err := m.db.Exec(statements[i]); err != nil {
+			return err
+		}
+		_, err := m.db.Exec("INSERT INTO schema_migration_progress (version, direction, statements) VALUES (?, ?, ?)"+
+			m.db.dialect.upsert([]string{"version", "direction"}, "statements = "+m.db.dialect.excluded("statements")),
+			migration.Version, direction, i+1)
+		if err != nil {
+			return fmt.Errorf("failed to record migration progress: %w", err)
+		}
+	}
+
+	if err := finish(m.db); err != nil {
+		return err
+	}
+	if _, err := m.db.Exec("DELETE FROM schema_migration_progress WHERE version = ? AND direction = ?", migration.Version, direction); err != nil {
+		return fmt.Errorf("failed to clear migration progress: %w", err)
+	}
+	return nil
+}
+
+// record marks a migration as applied
+func (m *Migrator) record(db execer, migration Migration) error {
+	_, err := db.Exec("INSERT INTO schema_migrations (version, name) VALUES (?, ?)", migration.Version, migration.Name)
+	if err != nil {
+		return fmt.Errorf("failed to record migration %d: %w", migration.Version, err)
+	}
+	return nil
+}
+
+// splitStatements breaks a migration script into individual statements,
+// since the driver runs one statement per Exec
+func splitStatements(script string) []string {
+	var statements []string
+	for _, part := range strings.Split(script, ";") {
+		var lines []string
+		for _, line := range strings.Split(part, "\n") {
+			if trimmed := strings.TrimSpace(line); trimmed != "" && !strings.HasPrefix(trimmed, "--") {
+				lines = append(lines, line)
+			}
+		}
+		if len(lines) > 0 {
+			statements = append(statements, strings.TrimSpace(strings.Join(lines, "\n")))
+		}
+	}
+	return statements
+}
@@ -0,0 +1,175 @@
+package store
+
+import (
+	"io"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"worker/internal/config"
+)
+
+func TestLoadMigrations(t *testing.T) {
+	fsys := fstest.MapFS{
+		"m/0002_add_index.up.sql":      {Data: []byte("CREATE INDEX i ON t (a);")},
+		"m/0002_add_index.down.sql":    {Data: []byte("DROP INDEX i;")},
+		"m/0001_create_table.up.sql":   {Data: []byte("CREATE TABLE t (a INT);")},
+		"m/0001_create_table.down.sql": {Data: []byte("DROP TABLE t;")},
+		"m/README.md":                  {Data: []byte("not a migration")},
+	}
+
+	migrations, err := loadMigrations(fsys, "m")
+	require.NoError(t, err)
+	require.Len(t, migrations, 2)
+	assert.Equal(t, Migration{Version: 1, Name: "create_table", Up: "CREATE TABLE t (a INT);", Down: "DROP TABLE t;"}, migrations[0])
+	assert.Equal(t, 2, migrations[1].Version)
+
+	fsys["m/0002_other_name.down.sql"] = &fstest.MapFile{Data: []byte("DROP INDEX i;")}
+	_, err = loadMigrations(fsys, "m")
+	assert.ErrorContains(t, err, "conflicting names")
+
+	delete(fsys, "m/0002_other_name.down.sql")
+	fsys["m/0003_no_up.down.sql"] = &fstest.MapFile{Data: []byte("SELECT 1;")}
+	_, err = loadMigrations(fsys, "m")
+	assert.ErrorContains(t, err, "has no up script")
+}
+
+func TestSplitStatements(t *testing.T) {
+	script := `-- Adds the table
+CREATE TABLE t (
+    a INT
+);
+
+-- and its index
+CREATE INDEX i ON t (a);
+`
+	assert.Equal(t, []string{"CREATE TABLE t (\n    a INT\n)", "CREATE INDEX i ON t (a)"}, splitStatements(script))
+	assert.Empty(t, splitStatements("-- nothing to do\n"))
+}
+
+// legacySQLite recognises the votes table the old initDB created as
+// migration 1
+type legacySQLite struct{ sqliteDialect }
+
+func (d legacySQLite) baselineProbes() map[int]func(db *sqlDB) (bool, error) {
+	return map[int]func(db *sqlDB) (bool, error){
+		1: func(db *sqlDB) (bool, error) { return d.tableExists(db, "votes") },
+	}
+}
+
+func TestMigrationsBaselineLegacyDatabase(t *testing.T) {
+	logger, hook := test.NewNullLogger()
+	sink, err := NewSQLiteSink(&config.Config{
+		DBDriver:   DriverSQLite,
+		SQLitePath: filepath.Join(t.TempDir(), "voting.db"),
+	}, logger)
+	require.NoError(t, err)
+	t.Cleanup(func() { sink.Close() })
+
+	// The table as the old initDB left it, with a vote in it
+	_, err = sink.db.Exec(`CREATE TABLE votes (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		vote VARCHAR(10) NOT NULL,
+		voter_id VARCHAR(255) NOT NULL,
+		timestamp DATETIME NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	)`)
+	require.NoError(t, err)
+	_, err = sink.db.Exec("INSERT INTO votes (vote, voter_id, timestamp) VALUES ('cats', 'user1', CURRENT_TIMESTAMP)")
+	require.NoError(t, err)
+
+	migrator, err := NewMigrator(&sqlDB{DB: sink.db.DB, dialect: legacySQLite{}}, logger)
+	require.NoError(t, err)
+
+	statuses, err := migrator.Status()
+	require.NoError(t, err)
+	assert.True(t, statuses[0].Baseline)
+	assert.False(t, statuses[1].Baseline)
+
+	// Migration 1 is recorded without running, the later ones run on top
+	require.NoError(t, migrator.Up())
+	statuses, err = migrator.Status()
+	require.NoError(t, err)
+	for _, status := range statuses {
+		assert.NotNil(t, status.AppliedAt, "migration %d should be applied", status.Version)
+	}
+
+	var baselined []int
+	for _, entry := range hook.AllEntries() {
+		if entry.Message == "Baselined existing schema migration" {
+			baselined = append(baselined, entry.Data["version"].(int))
+		}
+	}
+	assert.Equal(t, []int{1}, baselined)
+
+	var votes int
+	require.NoError(t, sink.db.QueryRow("SELECT COUNT(*) FROM votes").Scan(&votes))
+	assert.Equal(t, 1, votes)
+}
+
+func TestMigrationsBaselineAfterInterruptedFirstRun(t *testing.T) {
+	logger, hook := test.NewNullLogger()
+	sink, err := NewSQLiteSink(&config.Config{
+		DBDriver:   DriverSQLite,
+		SQLitePath: filepath.Join(t.TempDir(), "voting.db"),
+	}, logger)
+	require.NoError(t, err)
+	t.Cleanup(func() { sink.Close() })
+
+	// A first run created schema_migrations on the legacy database and
+	// stopped before baselining it
+	_, err = sink.db.Exec(`CREATE TABLE votes (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		vote VARCHAR(10) NOT NULL,
+		voter_id VARCHAR(255) NOT NULL,
+		timestamp DATETIME NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	)`)
+	require.NoError(t, err)
+	_, err = sink.db.Exec(`CREATE TABLE schema_migrations (
+		version INT PRIMARY KEY,
+		name VARCHAR(255) NOT NULL,
+		applied_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	)`)
+	require.NoError(t, err)
+
+	migrator, err := NewMigrator(&sqlDB{DB: sink.db.DB, dialect: legacySQLite{}}, logger)
+	require.NoError(t, err)
+
+	statuses, err := migrator.Status()
+	require.NoError(t, err)
+	assert.True(t, statuses[0].Baseline)
+
+	require.NoError(t, migrator.Up())
+	var baselined []int
+	for _, entry := range hook.AllEntries() {
+		if entry.Message == "Baselined existing schema migration" {
+			baselined = append(baselined, entry.Data["version"].(int))
+		}
+	}
+	assert.Equal(t, []int{1}, baselined)
+}
+
+func TestMigrationsWithoutLegacySchemaAreNotBaselined(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	sink, err := NewSQLiteSink(&config.Config{
+		DBDriver:   DriverSQLite,
+		SQLitePath: filepath.Join(t.TempDir(), "voting.db"),
+	}, logger)
+	require.NoError(t, err)
+	t.Cleanup(func() { sink.Close() })
+
+	migrator, err := NewMigrator(&sqlDB{DB: sink.db.DB, dialect: legacySQLite{}}, logger)
+	require.NoError(t, err)
+	statuses, err := migrator.Status()
+	require.NoError(t, err)
+	for _, status := range statuses {
+		assert.False(t, status.Baseline)
+	}
+}
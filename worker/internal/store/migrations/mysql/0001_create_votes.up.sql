@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS votes (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    vote VARCHAR(10) NOT NULL,
+    voter_id VARCHAR(255) NOT NULL,
+    timestamp DATETIME NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
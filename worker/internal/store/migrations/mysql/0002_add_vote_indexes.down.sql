@@ -0,0 +1,2 @@
+DROP INDEX idx_timestamp ON votes;
+DROP INDEX idx_vote ON votes;
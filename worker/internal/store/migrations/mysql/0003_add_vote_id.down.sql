@@ -0,0 +1,3 @@
+ALTER TABLE votes
+    DROP INDEX uniq_vote_id,
+    DROP COLUMN vote_id;
@@ -0,0 +1,3 @@
+ALTER TABLE votes
+    ADD COLUMN vote_id VARCHAR(64) NULL AFTER id,
+    ADD UNIQUE KEY uniq_vote_id (vote_id);
@@ -0,0 +1,12 @@
+DROP TABLE IF EXISTS polls;
+
+DELETE FROM voter_ballots WHERE poll_id <> 'default';
+
+ALTER TABLE voter_ballots
+    DROP PRIMARY KEY,
+    DROP COLUMN poll_id,
+    ADD PRIMARY KEY (voter_id);
+
+ALTER TABLE votes
+    DROP INDEX idx_poll_vote,
+    DROP COLUMN poll_id;
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS vote_tallies (
+    poll_id VARCHAR(64) NOT NULL,
+    vote VARCHAR(10) NOT NULL,
+    count BIGINT NOT NULL DEFAULT 0,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    PRIMARY KEY (poll_id, vote)
+);
+
+INSERT INTO vote_tallies (poll_id, vote, count)
+SELECT poll_id, vote, COUNT(*) FROM votes GROUP BY poll_id, vote;
@@ -0,0 +1 @@
+ALTER TABLE vote_tallies DROP COLUMN version;
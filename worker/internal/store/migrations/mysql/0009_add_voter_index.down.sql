@@ -0,0 +1 @@
+DROP INDEX idx_poll_voter ON votes;
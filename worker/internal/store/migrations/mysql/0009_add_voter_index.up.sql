@@ -0,0 +1 @@
+CREATE INDEX idx_poll_voter ON votes (poll_id, voter_id);
@@ -0,0 +1,21 @@
+CREATE TABLE IF NOT EXISTS voter_ballots (
+    voter_id VARCHAR(255) PRIMARY KEY,
+    vote_id VARCHAR(64) NOT NULL,
+    vote VARCHAR(10) NOT NULL,
+    timestamp TIMESTAMP(6) NOT NULL,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Votes stored before vote_id existed get one, so that a ballot can point at
+-- the vote it records and last-wins can delete it when it is replaced
+UPDATE votes SET vote_id = 'legacy-' || id WHERE vote_id IS NULL;
+
+-- Each voter's current ballot is their latest stored vote. Every vote so far
+-- belongs to the default poll, which 0006 assigns to these rows.
+INSERT INTO voter_ballots (voter_id, vote_id, vote, timestamp)
+SELECT voter_id, vote_id, vote, timestamp FROM (
+    SELECT voter_id, vote_id, vote, timestamp,
+        ROW_NUMBER() OVER (PARTITION BY voter_id ORDER BY timestamp DESC, id DESC) AS position
+    FROM votes
+) ranked
+WHERE position = 1;
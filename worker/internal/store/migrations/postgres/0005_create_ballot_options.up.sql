@@ -0,0 +1,5 @@
+CREATE TABLE IF NOT EXISTS ballot_options (
+    poll_id VARCHAR(64) NOT NULL,
+    option_value VARCHAR(10) NOT NULL,
+    PRIMARY KEY (poll_id, option_value)
+);
@@ -0,0 +1,10 @@
+DROP TABLE IF EXISTS polls;
+
+DELETE FROM voter_ballots WHERE poll_id <> 'default';
+
+ALTER TABLE voter_ballots DROP CONSTRAINT voter_ballots_pkey;
+ALTER TABLE voter_ballots DROP COLUMN poll_id;
+ALTER TABLE voter_ballots ADD PRIMARY KEY (voter_id);
+
+DROP INDEX IF EXISTS idx_poll_vote;
+ALTER TABLE votes DROP COLUMN poll_id;
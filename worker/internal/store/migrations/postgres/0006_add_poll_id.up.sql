@@ -0,0 +1,13 @@
+ALTER TABLE votes ADD COLUMN poll_id VARCHAR(64) NOT NULL DEFAULT 'default';
+CREATE INDEX idx_poll_vote ON votes (poll_id, vote);
+
+ALTER TABLE voter_ballots ADD COLUMN poll_id VARCHAR(64) NOT NULL DEFAULT 'default';
+ALTER TABLE voter_ballots DROP CONSTRAINT voter_ballots_pkey;
+ALTER TABLE voter_ballots ADD PRIMARY KEY (poll_id, voter_id);
+
+CREATE TABLE IF NOT EXISTS polls (
+    poll_id VARCHAR(64) PRIMARY KEY,
+    status VARCHAR(10) NOT NULL DEFAULT 'open',
+    opened_at TIMESTAMP NULL,
+    closed_at TIMESTAMP NULL
+);
@@ -0,0 +1 @@
+ALTER TABLE vote_tallies ADD COLUMN version BIGINT NOT NULL DEFAULT 0;
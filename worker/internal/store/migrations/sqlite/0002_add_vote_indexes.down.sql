@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_timestamp;
+DROP INDEX IF EXISTS idx_vote;
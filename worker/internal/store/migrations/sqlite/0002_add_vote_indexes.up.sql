@@ -0,0 +1,2 @@
+CREATE INDEX idx_vote ON votes (vote);
+CREATE INDEX idx_timestamp ON votes (timestamp);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS uniq_vote_id;
+ALTER TABLE votes DROP COLUMN vote_id;
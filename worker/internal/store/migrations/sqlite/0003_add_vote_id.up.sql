@@ -0,0 +1,2 @@
+ALTER TABLE votes ADD COLUMN vote_id VARCHAR(64) NULL;
+CREATE UNIQUE INDEX uniq_vote_id ON votes (vote_id);
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS voter_ballots;
+
+UPDATE votes SET vote_id = NULL WHERE vote_id LIKE 'legacy-%';
@@ -0,0 +1,16 @@
+DROP TABLE IF EXISTS polls;
+
+CREATE TABLE voter_ballots_old (
+    voter_id VARCHAR(255) PRIMARY KEY,
+    vote_id VARCHAR(64) NOT NULL,
+    vote VARCHAR(10) NOT NULL,
+    timestamp DATETIME NOT NULL,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+INSERT INTO voter_ballots_old (voter_id, vote_id, vote, timestamp, updated_at)
+SELECT voter_id, vote_id, vote, timestamp, updated_at FROM voter_ballots WHERE poll_id = 'default';
+DROP TABLE voter_ballots;
+ALTER TABLE voter_ballots_old RENAME TO voter_ballots;
+
+DROP INDEX IF EXISTS idx_poll_vote;
+ALTER TABLE votes DROP COLUMN poll_id;
@@ -0,0 +1,24 @@
+ALTER TABLE votes ADD COLUMN poll_id VARCHAR(64) NOT NULL DEFAULT 'default';
+CREATE INDEX idx_poll_vote ON votes (poll_id, vote);
+
+-- SQLite cannot change a primary key, so voter_ballots is rebuilt
+CREATE TABLE voter_ballots_new (
+    poll_id VARCHAR(64) NOT NULL DEFAULT 'default',
+    voter_id VARCHAR(255) NOT NULL,
+    vote_id VARCHAR(64) NOT NULL,
+    vote VARCHAR(10) NOT NULL,
+    timestamp DATETIME NOT NULL,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (poll_id, voter_id)
+);
+INSERT INTO voter_ballots_new (voter_id, vote_id, vote, timestamp, updated_at)
+SELECT voter_id, vote_id, vote, timestamp, updated_at FROM voter_ballots;
+DROP TABLE voter_ballots;
+ALTER TABLE voter_ballots_new RENAME TO voter_ballots;
+
+CREATE TABLE IF NOT EXISTS polls (
+    poll_id VARCHAR(64) PRIMARY KEY,
+    status VARCHAR(10) NOT NULL DEFAULT 'open',
+    opened_at TIMESTAMP NULL,
+    closed_at TIMESTAMP NULL
+);
@@ -0,0 +1,257 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Voting policies
+const (
+	// PolicyAppend stores every vote
+	PolicyAppend = "append"
+	// PolicyFirstWins ignores votes from a voter who has already voted
+	PolicyFirstWins = "first-wins"
+	// PolicyLastWins replaces a voter's earlier vote with a newer one
+	PolicyLastWins = "last-wins"
+)
+
+// Policy rejection reasons
+const (
+	reasonAlreadyVoted = "already_voted"
+	reasonSuperseded   = "superseded"
+)
+
+// ValidPolicy reports whether a VOTE_POLICY value is supported
+func ValidPolicy(policy string) bool {
+	switch policy {
+	case PolicyAppend, PolicyFirstWins, PolicyLastWins:
+		return true
+	}
+	return false
+}
+
+// applyPolicy decides which votes of a batch are stored under the configured
+// voting policy, recording each voter's current ballot in voter_ballots. It
+// runs inside the batch transaction so the ballot and vote rows stay in step.
+func (s *SQLSink) applyPolicy(tx *sqlTx, votes []Vote, result *BatchResult) ([]Vote, error) {
+	switch s.config.VotePolicy {
+	case PolicyFirstWins:
+		return s.applyFirstWins(tx, votes, result)
+	case PolicyLastWins:
+		return s.applyLastWins(tx, votes, result)
+	default:
+		return votes, nil
+	}
+}
+
+// applyFirstWins keeps only the first vote seen from each voter
+func (s *SQLSink) applyFirstWins(tx *sqlTx, votes []Vote, result *BatchResult) ([]Vote, error) {
+	accepted := make([]Vote, 0, len(votes))
+
+	for _, vote := range votes {
+		res, err := tx.Exec(
+			"INSERT INTO voter_ballots (poll_id, voter_id, vote_id, vote, timestamp) VALUES (?, ?, ?, ?, ?)"+
+				tx.dialect.insertIgnore("voter_id"),
+			vote.PollID, vote.VoterID, vote.VoteID, vote.Choice, vote.Timestamp,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to record ballot: %w", err)
+		}
+
+		affected, err := res.RowsAffected()
+		if err != nil {
+			return nil, fmt.Errorf("failed to record ballot: %w", err)
+		}
+		if affected == 0 {
+			var previousID string
+			err := tx.QueryRow(
+				"SELECT vote_id FROM voter_ballots WHERE poll_id = ? AND voter_id = ?",
+				vote.PollID, vote.VoterID,
+			).Scan(&previousID)
+			if err != nil {
+				return nil, fmt.Errorf("failed to look up ballot: %w", err)
+			}
+			if previousID != vote.VoteID {
+				s.rejectVote(vote, reasonAlreadyVoted, result)
+				continue
+			}
+			// A concurrent batch stored this very vote after the lookup of
+			// existing vote_ids; the insert will skip it
+		}
+		accepted = append(accepted, vote)
+	}
+
+	return accepted, nil
+}
+
+// applyLastWins keeps each voter's most recent vote by payload timestamp,
+// deleting the votes it replaces
+func (s *SQLSink) applyLastWins(tx *sqlTx, votes []Vote, result *BatchResult) ([]Vote, error) {
+	accepted := make([]Vote, 0, len(votes))
+	// Votes accepted earlier in this batch are not in the votes table yet
+	pendingByID := make(map[string]int)
+
+	for _, vote := range votes {
+		var previousID, previousVote string
+		var previousTime time.Time
+		err := tx.QueryRow(
+			"SELECT vote_id, vote, timestamp FROM voter_ballots WHERE poll_id = ? AND voter_id = ?"+tx.dialect.forUpdate(),
+			vote.PollID, vote.VoterID,
+		).Scan(&previousID, &previousVote, &previousTime)
+
+		switch {
+		case err == sql.ErrNoRows:
+			_, err = tx.Exec(
+				"INSERT INTO voter_ballots (poll_id, voter_id, vote_id, vote, timestamp) VALUES (?, ?, ?, ?, ?)",
+				vote.PollID, vote.VoterID, vote.VoteID, vote.Choice, vote.Timestamp,
+			)
+			if err != nil {
+				return nil, fmt.Errorf("failed to record ballot: %w", err)
+			}
+
+		case err != nil:
+			return nil, fmt.Errorf("failed to look up ballot: %w", err)
+
+		case previousID == vote.VoteID:
+			// A concurrent batch stored this very vote after the lookup of
+			// existing vote_ids; the insert will skip it
+			accepted = append(accepted, vote)
+			continue
+
+		case vote.Timestamp.Before(previousTime):
+			s.rejectVote(vote, reasonSuperseded, result)
+			continue
+
+		default:
+			if index, ok := pendingByID[previousID]; ok {
+				// The replaced vote was never written, just drop it from the batch
+				accepted = append(accepted[:index], accepted[index+1:]...)
+				delete(pendingByID, previousID)
+				for id, i := range pendingByID {
+					if i > index {
+						pendingByID[id] = i - 1
+					}
+				}
+			} else if err := deleteVoterVotes(tx, vote.PollID, vote.VoterID, result); err != nil {
+				return nil, err
+			}
+
+			_, err = tx.Exec(
+				"UPDATE voter_ballots SET vote_id = ?, vote = ?, timestamp = ?, updated_at = CURRENT_TIMESTAMP WHERE poll_id = ? AND voter_id = ?",
+				vote.VoteID, vote.Choice, vote.Timestamp, vote.PollID, vote.VoterID,
+			)
+			if err != nil {
+				return nil, fmt.Errorf("failed to update ballot: %w", err)
+			}
+
+			result.Replaced++
+			s.logger.WithFields(logrus.Fields{
+				"poll_id":          vote.PollID,
+				"voter_id":         vote.VoterID,
+				"vote":             vote.Choice,
+				"replaced_vote_id": previousID,
+			}).Info("Vote replaced voter's earlier vote")
+		}
+
+		pendingByID[vote.VoteID] = len(accepted)
+		accepted = append(accepted, vote)
+	}
+
+	return accepted, nil
+}
+
+// deleteVoterVotes deletes every stored vote of a voter in a poll, which is
+// the ballot's vote and any stored while the policy was append, and takes
+// them off the tallies
+func deleteVoterVotes(tx *sqlTx, poll, voterID string, result *BatchResult) error {
+	rows, err := tx.Query("SELECT vote, COUNT(*) FROM votes WHERE poll_id = ? AND voter_id = ? GROUP BY vote", poll, voterID)
+	if err != nil {
+		return fmt.Errorf("failed to read replaced votes: %w", err)
+	}
+	defer rows.Close()
+
+	replaced := make(map[string]int64)
+	for rows.Next() {
+		var choice string
+		var count int64
+		if err := rows.Scan(&choice, &count); err != nil {
+			return fmt.Errorf("failed to read replaced votes: %w", err)
+		}
+		replaced[choice] = count
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to read replaced votes: %w", err)
+	}
+
+	if _, err := tx.Exec("DELETE FROM votes WHERE poll_id = ? AND voter_id = ?", poll, voterID); err != nil {
+		return fmt.Errorf("failed to delete replaced votes: %w", err)
+	}
+	for choice, count := range replaced {
+		result.Tallies.Add(poll, choice, -count)
+	}
+	return nil
+}
+
+// recordBallots keeps voter_ballots current under append, so that switching
+// to first-wins or last-wins starts from each voter's latest stored vote.
+// A ballot only moves to a vote with a timestamp at least as recent.
+func recordBallots(tx *sqlTx, votes []Vote) error {
+	type voter struct{ poll, id string }
+	latest := make(map[voter]Vote)
+	order := make([]voter, 0, len(votes))
+	for _, vote := range votes {
+		key := voter{vote.PollID, vote.VoterID}
+		previous, ok := latest[key]
+		if !ok {
+			order = append(order, key)
+		}
+		if !ok || !vote.Timestamp.Before(previous.Timestamp) {
+			latest[key] = vote
+		}
+	}
+	if len(order) == 0 {
+		return nil
+	}
+
+	placeholders := make([]string, 0, len(order))
+	args := make([]interface{}, 0, len(order)*5)
+	for _, key := range order {
+		vote := latest[key]
+		placeholders = append(placeholders, "(?, ?, ?, ?, ?)")
+		args = append(args, vote.PollID, vote.VoterID, vote.VoteID, vote.Choice, vote.Timestamp)
+	}
+
+	// The timestamp is assigned last, since MySQL evaluates the assignments
+	// in order and the conditions compare against the stored one
+	newer := tx.dialect.excluded("timestamp") + " >= voter_ballots.timestamp"
+	assignments := make([]string, 0, 3)
+	for _, column := range []string{"vote_id", "vote", "timestamp"} {
+		assignments = append(assignments, fmt.Sprintf("%s = CASE WHEN %s THEN %s ELSE voter_ballots.%s END",
+			column, newer, tx.dialect.excluded(column), column))
+	}
+
+	_, err := tx.Exec(
+		"INSERT INTO voter_ballots (poll_id, voter_id, vote_id, vote, timestamp) VALUES "+strings.Join(placeholders, ", ")+
+			tx.dialect.upsert([]string{"poll_id", "voter_id"}, strings.Join(assignments, ", ")),
+		args...,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to record ballots: %w", err)
+	}
+	return nil
+}
+
+// rejectVote records a vote that the policy refused to store
+func (s *SQLSink) rejectVote(vote Vote, reason string, result *BatchResult) {
+	result.Rejected[reason]++
+	s.logger.WithFields(logrus.Fields{
+		"poll_id":  vote.PollID,
+		"voter_id": vote.VoterID,
+		"vote":     vote.Choice,
+		"reason":   reason,
+	}).Info("Vote rejected by voting policy")
+}
@@ -0,0 +1,135 @@
+package store
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFirstWinsRejectsLaterVotes(t *testing.T) {
+	sink, mock := newMockSink(t, PolicyFirstWins)
+
+	// user1 voted in an earlier batch; user2's second vote in this batch loses
+	// to the first
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT vote_id FROM votes`).WillReturnRows(sqlmock.NewRows([]string{"vote_id"}))
+	mock.ExpectExec(`INSERT INTO voter_ballots`).WithArgs("pets", "user1", "v2", "dogs", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(`SELECT vote_id FROM voter_ballots WHERE poll_id = \? AND voter_id = \?`).WithArgs("pets", "user1").
+		WillReturnRows(sqlmock.NewRows([]string{"vote_id"}).AddRow("v1"))
+	mock.ExpectExec(`INSERT INTO voter_ballots`).WithArgs("pets", "user2", "v3", "dogs", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`INSERT INTO voter_ballots`).WithArgs("pets", "user2", "v4", "cats", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(`SELECT vote_id FROM voter_ballots`).WithArgs("pets", "user2").
+		WillReturnRows(sqlmock.NewRows([]string{"vote_id"}).AddRow("v3"))
+	expectSavepoint(mock)
+	mock.ExpectExec(`INSERT INTO votes \(vote_id, poll_id, vote, voter_id, timestamp\) VALUES \(\?, \?, \?, \?, \?\) `).
+		WithArgs("v3", "pets", "dogs", "user2", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(`INSERT INTO vote_tallies`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	result, err := sink.InsertBatch(context.Background(), []Vote{
+		pollVote("v2", "dogs", "user1", time.Second),
+		pollVote("v3", "dogs", "user2", 0),
+		pollVote("v4", "cats", "user2", time.Second),
+	})
+	require.NoError(t, err)
+	require.NoError(t, mock.ExpectationsWereMet())
+	require.Len(t, result.Stored, 1)
+	assert.Equal(t, "v3", result.Stored[0].VoteID)
+	assert.Equal(t, map[string]int{reasonAlreadyVoted: 2}, result.Rejected)
+}
+
+func TestFirstWinsAcceptsVoteStoredConcurrently(t *testing.T) {
+	sink, mock := newMockSink(t, PolicyFirstWins)
+
+	// Another worker stored v1 and its ballot after this batch looked up
+	// existing vote_ids, so it is a duplicate rather than a second vote
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT vote_id FROM votes`).WillReturnRows(sqlmock.NewRows([]string{"vote_id"}))
+	mock.ExpectExec(`INSERT INTO voter_ballots`).WithArgs("pets", "user1", "v1", "cats", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(`SELECT vote_id FROM voter_ballots`).WithArgs("pets", "user1").
+		WillReturnRows(sqlmock.NewRows([]string{"vote_id"}).AddRow("v1"))
+	expectSavepoint(mock)
+	mock.ExpectExec(`INSERT INTO votes \(vote_id, poll_id, vote, voter_id, timestamp\) VALUES \(\?, \?, \?, \?, \?\) `).
+		WithArgs("v1", "pets", "cats", "user1", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`ROLLBACK TO SAVEPOINT insert_votes`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`INSERT INTO votes`).WithArgs("v1", "pets", "cats", "user1", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+
+	result, err := sink.InsertBatch(context.Background(), []Vote{pollVote("v1", "cats", "user1", 0)})
+	require.NoError(t, err)
+	require.NoError(t, mock.ExpectationsWereMet())
+	assert.Empty(t, result.Stored)
+	assert.Empty(t, result.Rejected)
+	assert.Equal(t, 1, result.Deduplicated)
+}
+
+func TestLastWinsReplacesEarlierVotes(t *testing.T) {
+	sink, mock := newMockSink(t, PolicyLastWins)
+	ballot := []string{"vote_id", "vote", "timestamp"}
+	stored := time.Date(2023, 1, 1, 12, 0, 5, 0, time.UTC)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT vote_id FROM votes`).WillReturnRows(sqlmock.NewRows([]string{"vote_id"}))
+
+	// user1's stored vote is newer than v3, which arrived late
+	mock.ExpectQuery(`SELECT vote_id, vote, timestamp FROM voter_ballots WHERE poll_id = \? AND voter_id = \? FOR UPDATE`).
+		WithArgs("pets", "user1").WillReturnRows(sqlmock.NewRows(ballot).AddRow("v2", "dogs", stored))
+
+	// user2 votes twice in the batch; the first vote is replaced before it is written
+	mock.ExpectQuery(`SELECT vote_id, vote, timestamp FROM voter_ballots`).
+		WithArgs("pets", "user2").WillReturnRows(sqlmock.NewRows(ballot))
+	mock.ExpectExec(`INSERT INTO voter_ballots`).WithArgs("pets", "user2", "v4", "cats", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`SELECT vote_id, vote, timestamp FROM voter_ballots`).
+		WithArgs("pets", "user2").WillReturnRows(sqlmock.NewRows(ballot).AddRow("v4", "cats", stored.Add(-5*time.Second)))
+	mock.ExpectExec(`UPDATE voter_ballots SET vote_id = \?, vote = \?, timestamp = \?, updated_at = CURRENT_TIMESTAMP WHERE poll_id = \? AND voter_id = \?`).
+		WithArgs("v5", "birds", sqlmock.AnyArg(), "pets", "user2").WillReturnResult(sqlmock.NewResult(0, 1))
+
+	// user3's stored vote is older, so it is deleted along with a vote
+	// stored while the policy was append
+	mock.ExpectQuery(`SELECT vote_id, vote, timestamp FROM voter_ballots`).
+		WithArgs("pets", "user3").WillReturnRows(sqlmock.NewRows(ballot).AddRow("v1", "cats", stored.Add(-5*time.Second)))
+	mock.ExpectQuery(`SELECT vote, COUNT\(\*\) FROM votes WHERE poll_id = \? AND voter_id = \? GROUP BY vote`).
+		WithArgs("pets", "user3").WillReturnRows(sqlmock.NewRows([]string{"vote", "count"}).AddRow("cats", 1).AddRow("fish", 1))
+	mock.ExpectExec(`DELETE FROM votes WHERE poll_id = \? AND voter_id = \?`).WithArgs("pets", "user3").WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec(`UPDATE voter_ballots`).
+		WithArgs("v6", "dogs", sqlmock.AnyArg(), "pets", "user3").WillReturnResult(sqlmock.NewResult(0, 1))
+
+	expectSavepoint(mock)
+	mock.ExpectExec(`INSERT INTO votes \(vote_id, poll_id, vote, voter_id, timestamp\) VALUES \(\?, \?, \?, \?, \?\), \(\?, \?, \?, \?, \?\) `).
+		WithArgs("v5", "pets", "birds", "user2", sqlmock.AnyArg(), "v6", "pets", "dogs", "user3", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 2))
+	mock.ExpectExec(`INSERT INTO vote_tallies`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	result, err := sink.InsertBatch(context.Background(), []Vote{
+		pollVote("v3", "birds", "user1", 2*time.Second),
+		pollVote("v4", "cats", "user2", 0),
+		pollVote("v5", "birds", "user2", time.Second),
+		pollVote("v6", "dogs", "user3", time.Second),
+	})
+	require.NoError(t, err)
+	require.NoError(t, mock.ExpectationsWereMet())
+	require.Len(t, result.Stored, 2)
+	assert.Equal(t, "v5", result.Stored[0].VoteID)
+	assert.Equal(t, "v6", result.Stored[1].VoteID)
+	assert.Equal(t, map[string]int{reasonSuperseded: 1}, result.Rejected)
+	assert.Equal(t, 2, result.Replaced)
+	assert.Equal(t, TallyDeltas{
+		{Poll: "pets", Vote: "cats"}:  -1,
+		{Poll: "pets", Vote: "fish"}:  -1,
+		{Poll: "pets", Vote: "birds"}: 1,
+		{Poll: "pets", Vote: "dogs"}:  1,
+	}, result.Tallies)
+}
@@ -0,0 +1,83 @@
+package store
+
+import (
+	"fmt"
+	"time"
+)
+
+// Poll states
+const (
+	PollOpen   = "open"
+	PollClosed = "closed"
+)
+
+// PollState is a row of the polls table
+type PollState struct {
+	PollID   string     `json:"poll_id"`
+	Status   string     `json:"status"`
+	OpenedAt *time.Time `json:"opened_at,omitempty"`
+	ClosedAt *time.Time `json:"closed_at,omitempty"`
+}
+
+// PollStates reads every row of the polls table
+func (s *SQLSink) PollStates() ([]PollState, error) {
+	rows, err := s.db.Query("SELECT poll_id, status, opened_at, closed_at FROM polls ORDER BY poll_id")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read polls: %w", err)
+	}
+	defer rows.Close()
+
+	var states []PollState
+	for rows.Next() {
+		var state PollState
+		if err := rows.Scan(&state.PollID, &state.Status, &state.OpenedAt, &state.ClosedAt); err != nil {
+			return nil, fmt.Errorf("failed to read polls: %w", err)
+		}
+		states = append(states, state)
+	}
+	return states, rows.Err()
+}
+
+// SetPollStatus opens or closes a poll
+func (s *SQLSink) SetPollStatus(pollID, status string) error {
+	var err error
+	if status == PollOpen {
+		_, err = s.db.Exec(
+			"INSERT INTO polls (poll_id, status, opened_at) VALUES (?, 'open', CURRENT_TIMESTAMP)"+
+				s.db.dialect.upsert([]string{"poll_id"}, "status = 'open', opened_at = CURRENT_TIMESTAMP, closed_at = NULL"),
+			pollID,
+		)
+	} else {
+		_, err = s.db.Exec(
+			"INSERT INTO polls (poll_id, status, closed_at) VALUES (?, 'closed', CURRENT_TIMESTAMP)"+
+				s.db.dialect.upsert([]string{"poll_id"}, "status = 'closed', closed_at = CURRENT_TIMESTAMP"),
+			pollID,
+		)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update poll %q: %w", pollID, err)
+	}
+	return nil
+}
+
+// BallotOptions reads the options of every poll from the ballot_options table
+func (s *SQLSink) BallotOptions() (map[string][]string, error) {
+	rows, err := s.db.Query("SELECT poll_id, option_value FROM ballot_options ORDER BY poll_id, option_value")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read ballot_options: %w", err)
+	}
+	defer rows.Close()
+
+	options := make(map[string][]string)
+	for rows.Next() {
+		var pollID, option string
+		if err := rows.Scan(&pollID, &option); err != nil {
+			return nil, fmt.Errorf("failed to read ballot_options: %w", err)
+		}
+		options[pollID] = append(options[pollID], option)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read ballot_options: %w", err)
+	}
+	return options, nil
+}
@@ -0,0 +1,123 @@
+// Package store writes votes to MySQL, PostgreSQL or SQLite and owns the
+// schema migrations of each.
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"worker/internal/config"
+)
+
+// VoteSink is where processed votes are stored, along with the tallies, poll
+// states and ballot options that go with them
+type VoteSink interface {
+	// Migrate brings the schema up to date
+	Migrate() error
+	// InsertBatch stores a batch of votes in one transaction, skipping
+	// duplicates and applying the voting policy. Nothing is stored when ctx
+	// is cancelled before the transaction commits.
+	InsertBatch(ctx context.Context, votes []Vote) (*BatchResult, error)
+	// Tallies reads the running tally of every option
+	Tallies() (map[TallyKey]int64, error)
+	// TallyCounts reads the running tally of every option with its version
+	TallyCounts() (map[TallyKey]TallyCount, error)
+	// ReconcileTallies recomputes the running tallies from the stored votes;
+	// it returns nil when another replica is already reconciling
+	ReconcileTallies() (*Reconciliation, error)
+	// PollStates reads the polls that were explicitly opened or closed
+	PollStates() ([]PollState, error)
+	// SetPollStatus opens or closes a poll
+	SetPollStatus(pollID, status string) error
+	// BallotOptions reads the valid options of every poll
+	BallotOptions() (map[string][]string, error)
+	// Ping checks that the database is reachable
+	Ping(ctx context.Context) error
+	// Stats reports the state of the connection pool
+	Stats() sql.DBStats
+	// Close closes the connection pool
+	Close() error
+}
+
+// SQLSink stores votes in a SQL database. Its dialect covers the differences
+// between MySQL, PostgreSQL and SQLite, so the three sinks share one
+// implementation of batching, deduplication and the voting policies.
+type SQLSink struct {
+	db     *sqlDB
+	config *config.Config
+	logger *logrus.Logger
+}
+
+// NewMySQLSink connects to the MySQL database from MYSQL_* settings
+func NewMySQLSink(config *config.Config, logger *logrus.Logger) (*SQLSink, error) {
+	return newSQLSink(mysqlDialect{}, config, logger)
+}
+
+// NewPostgresSink connects to the PostgreSQL database from POSTGRES_* settings
+func NewPostgresSink(config *config.Config, logger *logrus.Logger) (*SQLSink, error) {
+	return newSQLSink(postgresDialect{}, config, logger)
+}
+
+// NewSQLiteSink opens the SQLite database at SQLITE_PATH, creating it if missing
+func NewSQLiteSink(config *config.Config, logger *logrus.Logger) (*SQLSink, error) {
+	return newSQLSink(sqliteDialect{}, config, logger)
+}
+
+// New creates the sink selected by DB_DRIVER
+func New(config *config.Config, logger *logrus.Logger) (*SQLSink, error) {
+	d, err := dialectFor(config.DBDriver)
+	if err != nil {
+		return nil, err
+	}
+	return newSQLSink(d, config, logger)
+}
+
+func newSQLSink(d dialect, config *config.Config, logger *logrus.Logger) (*SQLSink, error) {
+	db, err := d.open(config)
+	if err != nil {
+		return nil, fmt.Errorf("database connection failed: %w", err)
+	}
+
+	db.SetMaxOpenConns(max(10, config.Concurrency+2))
+	db.SetMaxIdleConns(5)
+	db.SetConnMaxLifetime(time.Hour)
+
+	return &SQLSink{
+		db:     &sqlDB{DB: db, dialect: d},
+		config: config,
+		logger: logger,
+	}, nil
+}
+
+// Migrator returns a migrator for the sink's database
+func (s *SQLSink) Migrator() (*Migrator, error) {
+	return NewMigrator(s.db, s.logger)
+}
+
+// Migrate applies pending schema migrations
+func (s *SQLSink) Migrate() error {
+	migrator, err := s.Migrator()
+	if err != nil {
+		return err
+	}
+	return migrator.Up()
+}
+
+// Ping checks that the database is reachable
+func (s *SQLSink) Ping(ctx context.Context) error {
+	return s.db.PingContext(ctx)
+}
+
+// Stats reports the state of the connection pool
+func (s *SQLSink) Stats() sql.DBStats {
+	return s.db.Stats()
+}
+
+// Close closes the connection pool
+func (s *SQLSink) Close() error {
+	return s.db.Close()
+}
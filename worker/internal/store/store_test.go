@@ -0,0 +1,393 @@
+package store
+
+import (
+	"context"
+	"io"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"worker/internal/config"
+)
+
+// newTestSink opens a fresh, migrated SQLite database
+func newTestSink(t *testing.T, policy string) *SQLSink {
+	t.Helper()
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	sink, err := NewSQLiteSink(&config.Config{
+		VotePolicy: policy,
+		DBDriver:   DriverSQLite,
+		SQLitePath: filepath.Join(t.TempDir(), "voting.db"),
+	}, logger)
+	require.NoError(t, err)
+	t.Cleanup(func() { sink.Close() })
+	require.NoError(t, sink.Migrate())
+
+	return sink
+}
+
+func TestSQLiteMigrationsRollBack(t *testing.T) {
+	sink := newTestSink(t, PolicyAppend)
+
+	migrator, err := sink.Migrator()
+	require.NoError(t, err)
+
+	require.NoError(t, migrator.Down(len(migrator.migrations)))
+	exists, err := sink.db.dialect.tableExists(sink.db, "votes")
+	require.NoError(t, err)
+	assert.False(t, exists)
+
+	require.NoError(t, migrator.Up())
+	statuses, err := migrator.Status()
+	require.NoError(t, err)
+	for _, status := range statuses {
+		assert.NotNil(t, status.AppliedAt, "migration %d should be applied", status.Version)
+	}
+}
+
+func TestSQLiteMigrationsBackfillVoterBallots(t *testing.T) {
+	sink := newTestSink(t, PolicyLastWins)
+
+	migrator, err := sink.Migrator()
+	require.NoError(t, err)
+
+	// Go back to before voter_ballots existed and store votes the way the
+	// worker did then, some without a vote_id
+	require.NoError(t, migrator.Down(len(migrator.migrations)-3))
+	for _, row := range []struct {
+		voteID  interface{}
+		vote    string
+		voterID string
+		at      string
+	}{
+		{nil, "cats", "user1", "2023-01-01 12:00:00"},
+		{"v2", "dogs", "user1", "2023-01-01 12:05:00"},
+		{"v3", "cats", "user2", "2023-01-01 12:00:00"},
+		{nil, "dogs", "user2", "2023-01-01 11:00:00"},
+	} {
+		_, err := sink.db.Exec("INSERT INTO votes (vote_id, vote, voter_id, timestamp) VALUES (?, ?, ?, ?)", row.voteID, row.vote, row.voterID, row.at)
+		require.NoError(t, err)
+	}
+	require.NoError(t, migrator.Up())
+
+	rows, err := sink.db.Query("SELECT poll_id, voter_id, vote_id, vote FROM voter_ballots ORDER BY voter_id")
+	require.NoError(t, err)
+	defer rows.Close()
+	var ballots [][4]string
+	for rows.Next() {
+		var ballot [4]string
+		require.NoError(t, rows.Scan(&ballot[0], &ballot[1], &ballot[2], &ballot[3]))
+		ballots = append(ballots, ballot)
+	}
+	require.NoError(t, rows.Err())
+	assert.Equal(t, [][4]string{
+		{"default", "user1", "v2", "dogs"},
+		{"default", "user2", "v3", "cats"},
+	}, ballots)
+
+	var legacyID string
+	require.NoError(t, sink.db.QueryRow("SELECT vote_id FROM votes WHERE id = 1").Scan(&legacyID))
+	assert.Equal(t, "legacy-1", legacyID)
+
+	// The backfilled ballots hold last-wins to each voter's latest vote
+	result, err := sink.InsertBatch(context.Background(), []Vote{
+		{VoteID: "v5", PollID: "default", Choice: "cats", VoterID: "user1", Timestamp: time.Date(2023, 1, 1, 12, 1, 0, 0, time.UTC)},
+	})
+	require.NoError(t, err)
+	assert.Empty(t, result.Stored)
+	assert.Equal(t, map[string]int{reasonSuperseded: 1}, result.Rejected)
+}
+
+func TestSwitchingFromAppendKeepsOneVotePerVoter(t *testing.T) {
+	sink := newTestSink(t, PolicyAppend)
+	at := time.Date(2023, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	// Under append every vote is stored, and the ballot follows the latest
+	_, err := sink.InsertBatch(context.Background(), []Vote{
+		{VoteID: "v1", PollID: "pets", Choice: "cats", VoterID: "user1", Timestamp: at},
+		{VoteID: "v2", PollID: "pets", Choice: "dogs", VoterID: "user1", Timestamp: at.Add(time.Minute)},
+	})
+	require.NoError(t, err)
+	_, err = sink.InsertBatch(context.Background(), []Vote{
+		{VoteID: "v3", PollID: "pets", Choice: "birds", VoterID: "user1", Timestamp: at.Add(time.Second)},
+	})
+	require.NoError(t, err)
+
+	var ballot string
+	require.NoError(t, sink.db.QueryRow("SELECT vote_id FROM voter_ballots WHERE poll_id = 'pets' AND voter_id = 'user1'").Scan(&ballot))
+	assert.Equal(t, "v2", ballot)
+
+	// first-wins refuses another vote from the voter
+	sink.config.VotePolicy = PolicyFirstWins
+	result, err := sink.InsertBatch(context.Background(), []Vote{
+		{VoteID: "v4", PollID: "pets", Choice: "fish", VoterID: "user1", Timestamp: at.Add(2 * time.Minute)},
+	})
+	require.NoError(t, err)
+	assert.Empty(t, result.Stored)
+	assert.Equal(t, map[string]int{reasonAlreadyVoted: 1}, result.Rejected)
+
+	// last-wins replaces every vote the voter cast under append
+	sink.config.VotePolicy = PolicyLastWins
+	result, err = sink.InsertBatch(context.Background(), []Vote{
+		{VoteID: "v5", PollID: "pets", Choice: "fish", VoterID: "user1", Timestamp: at.Add(3 * time.Minute)},
+	})
+	require.NoError(t, err)
+	require.Len(t, result.Stored, 1)
+	assert.Equal(t, 1, result.Replaced)
+
+	var votes int
+	require.NoError(t, sink.db.QueryRow("SELECT COUNT(*) FROM votes WHERE voter_id = 'user1'").Scan(&votes))
+	assert.Equal(t, 1, votes)
+	tallies, err := sink.Tallies()
+	require.NoError(t, err)
+	assert.Equal(t, map[TallyKey]int64{
+		{Poll: "pets", Vote: "birds"}: 0,
+		{Poll: "pets", Vote: "cats"}:  0,
+		{Poll: "pets", Vote: "dogs"}:  0,
+		{Poll: "pets", Vote: "fish"}:  1,
+	}, tallies)
+}
+
+func TestInsertBatchReconcilesTallies(t *testing.T) {
+	sink := newTestSink(t, PolicyAppend)
+	at := time.Date(2023, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	result, err := sink.InsertBatch(context.Background(), []Vote{
+		{VoteID: "v1", PollID: "pets", Choice: "cats", VoterID: "user1", Timestamp: at},
+		{VoteID: "v2", PollID: "pets", Choice: "dogs", VoterID: "user2", Timestamp: at},
+		{VoteID: "v1", PollID: "pets", Choice: "cats", VoterID: "user1", Timestamp: at},
+	})
+	require.NoError(t, err)
+	assert.Len(t, result.Stored, 2)
+	assert.Equal(t, 1, result.Deduplicated)
+
+	// Drift introduced behind the worker's back is found and corrected
+	_, err = sink.db.Exec("UPDATE vote_tallies SET count = 5 WHERE vote = 'cats'")
+	require.NoError(t, err)
+
+	reconciliation, err := sink.ReconcileTallies()
+	require.NoError(t, err)
+	require.NotNil(t, reconciliation)
+	assert.Equal(t, map[string]float64{"pets": 4}, reconciliation.Drift)
+	assert.Equal(t, 1, reconciliation.Corrections)
+
+	tallies, err := sink.Tallies()
+	require.NoError(t, err)
+	assert.Equal(t, map[TallyKey]int64{
+		{Poll: "pets", Vote: "cats"}: 1,
+		{Poll: "pets", Vote: "dogs"}: 1,
+	}, tallies)
+}
+
+func TestInsertBatchVersionsRunningTallies(t *testing.T) {
+	sink := newTestSink(t, PolicyAppend)
+	sink.config.EventsChannel = "votes:events"
+	at := time.Date(2023, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	first, err := sink.InsertBatch(context.Background(), []Vote{
+		{VoteID: "v1", PollID: "pets", Choice: "cats", VoterID: "user1", Timestamp: at},
+	})
+	require.NoError(t, err)
+	second, err := sink.InsertBatch(context.Background(), []Vote{
+		{VoteID: "v2", PollID: "pets", Choice: "cats", VoterID: "user2", Timestamp: at},
+	})
+	require.NoError(t, err)
+
+	key := TallyKey{Poll: "pets", Vote: "cats"}
+	assert.Equal(t, int64(1), first.Counts[key].Count)
+	assert.Equal(t, int64(2), second.Counts[key].Count)
+	assert.Greater(t, second.Counts[key].Version, first.Counts[key].Version)
+}
+
+func TestConcurrentBatchesStoreVoteOnce(t *testing.T) {
+	sink := newTestSink(t, PolicyAppend)
+	at := time.Date(2023, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	results := make(chan *BatchResult, 2)
+	var wg sync.WaitGroup
+	for _, other := range []string{"v2", "v3"} {
+		wg.Add(1)
+		go func(other string) {
+			defer wg.Done()
+			result, err := sink.InsertBatch(context.Background(), []Vote{
+				{VoteID: "v1", PollID: "pets", Choice: "cats", VoterID: "user1", Timestamp: at},
+				{VoteID: other, PollID: "pets", Choice: "dogs", VoterID: other, Timestamp: at},
+			})
+			assert.NoError(t, err)
+			results <- result
+		}(other)
+	}
+	wg.Wait()
+	close(results)
+
+	stored, deduplicated := 0, 0
+	for result := range results {
+		stored += len(result.Stored)
+		deduplicated += result.Deduplicated
+	}
+	assert.Equal(t, 3, stored)
+	assert.Equal(t, 1, deduplicated)
+}
+
+func TestInsertVotesSkipsVotesStoredSinceLookup(t *testing.T) {
+	sink := newTestSink(t, PolicyAppend)
+	at := time.Date(2023, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	// Another replica commits v1 after this batch looked up its vote_ids
+	_, err := sink.db.Exec("INSERT INTO votes (vote_id, poll_id, vote, voter_id, timestamp) VALUES (?, ?, ?, ?, ?)",
+		"v1", "pets", "cats", "user1", at)
+	require.NoError(t, err)
+
+	tx, err := sink.db.Begin(context.Background())
+	require.NoError(t, err)
+	defer tx.Rollback()
+
+	inserted, err := insertVotes(tx, []Vote{
+		{VoteID: "v1", PollID: "pets", Choice: "cats", VoterID: "user1", Timestamp: at},
+		{VoteID: "v2", PollID: "pets", Choice: "dogs", VoterID: "user2", Timestamp: at},
+	})
+	require.NoError(t, err)
+	require.Len(t, inserted, 1)
+	assert.Equal(t, "v2", inserted[0].VoteID)
+}
+
+func TestInsertBatchTalliesOnlyWrittenVotes(t *testing.T) {
+	sink := newTestSink(t, PolicyFirstWins)
+	at := time.Date(2023, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	// Another replica commits v1, and counts it, between this batch's
+	// lookup of existing vote_ids and its insert
+	_, err := sink.db.Exec(`
+		CREATE TRIGGER concurrent_insert AFTER INSERT ON voter_ballots WHEN NEW.vote_id = 'v1'
+		BEGIN
+			INSERT INTO votes (vote_id, poll_id, vote, voter_id, timestamp)
+			VALUES (NEW.vote_id, NEW.poll_id, NEW.vote, NEW.voter_id, NEW.timestamp);
+			INSERT INTO vote_tallies (poll_id, vote, count) VALUES (NEW.poll_id, NEW.vote, 1);
+		END`)
+	require.NoError(t, err)
+
+	result, err := sink.InsertBatch(context.Background(), []Vote{
+		{VoteID: "v1", PollID: "pets", Choice: "cats", VoterID: "user1", Timestamp: at},
+		{VoteID: "v2", PollID: "pets", Choice: "dogs", VoterID: "user2", Timestamp: at},
+	})
+	require.NoError(t, err)
+	require.Len(t, result.Stored, 1)
+	assert.Equal(t, "v2", result.Stored[0].VoteID)
+	assert.Equal(t, 1, result.Deduplicated)
+	assert.Equal(t, TallyDeltas{{Poll: "pets", Vote: "dogs"}: 1}, result.Tallies)
+
+	reconciliation, err := sink.ReconcileTallies()
+	require.NoError(t, err)
+	require.NotNil(t, reconciliation)
+	assert.Zero(t, reconciliation.Corrections, "no drift: %v", reconciliation.Drift)
+}
+
+func TestInsertBatchStoresNothingWhenCancelled(t *testing.T) {
+	sink := newTestSink(t, PolicyAppend)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := sink.InsertBatch(ctx, []Vote{
+		{VoteID: "v1", PollID: "pets", Choice: "cats", VoterID: "user1", Timestamp: time.Now()},
+	})
+	assert.ErrorIs(t, err, context.Canceled)
+
+	tallies, err := sink.Tallies()
+	require.NoError(t, err)
+	assert.Empty(t, tallies)
+}
+
+// nonTransactionalSQLite runs migrations the way MySQL does, statement by
+// statement outside a transaction
+type nonTransactionalSQLite struct{ sqliteDialect }
+
+func (nonTransactionalSQLite) transactionalDDL() bool { return false }
+
+// brokenMigration creates two tables, then fails
+var brokenMigration = Migration{
+	Version: 100,
+	Name:    "create_extra_tables",
+	Up:      "CREATE TABLE extra_a (id INT);\nCREATE TABLE extra_b (id INT);\nINSERT INTO missing_table VALUES (1);",
+	Down:    "DROP TABLE extra_b;\nDROP TABLE extra_a;",
+}
+
+func TestFailedMigrationRollsBack(t *testing.T) {
+	sink := newTestSink(t, PolicyAppend)
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	migrator := &Migrator{db: sink.db, logger: logger, migrations: []Migration{brokenMigration}}
+
+	require.Error(t, migrator.Up())
+	exists, err := sink.db.dialect.tableExists(sink.db, "extra_a")
+	require.NoError(t, err)
+	assert.False(t, exists, "the statements before the failure are rolled back")
+
+	// Once fixed, the migration runs from the start
+	migrator.migrations[0].Up = "CREATE TABLE extra_a (id INT);\nCREATE TABLE extra_b (id INT);"
+	require.NoError(t, migrator.Up())
+	statuses, err := migrator.Status()
+	require.NoError(t, err)
+	assert.NotNil(t, statuses[0].AppliedAt)
+}
+
+func TestFailedMigrationResumesWithoutTransactionalDDL(t *testing.T) {
+	sink := newTestSink(t, PolicyAppend)
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	db := &sqlDB{DB: sink.db.DB, dialect: nonTransactionalSQLite{}}
+	migrator := &Migrator{db: db, logger: logger, migrations: []Migration{brokenMigration}}
+
+	require.Error(t, migrator.Up())
+	exists, err := db.dialect.tableExists(db, "extra_b")
+	require.NoError(t, err)
+	assert.True(t, exists, "the statements before the failure stay applied")
+
+	// Re-creating the tables would fail, so the fixed migration must resume
+	// after them
+	migrator.migrations[0].Up = "CREATE TABLE extra_a (id INT);\nCREATE TABLE extra_b (id INT);\nCREATE TABLE extra_c (id INT);"
+	require.NoError(t, migrator.Up())
+	exists, err = db.dialect.tableExists(db, "extra_c")
+	require.NoError(t, err)
+	assert.True(t, exists)
+
+	var progress int
+	require.NoError(t, db.QueryRow("SELECT COUNT(*) FROM schema_migration_progress").Scan(&progress))
+	assert.Zero(t, progress)
+
+	require.NoError(t, migrator.Down(1))
+	exists, err = db.dialect.tableExists(db, "extra_a")
+	require.NoError(t, err)
+	assert.False(t, exists)
+}
+
+func TestMigrationStatusLeavesDatabaseUntouched(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	sink, err := NewSQLiteSink(&config.Config{
+		DBDriver:   DriverSQLite,
+		SQLitePath: filepath.Join(t.TempDir(), "voting.db"),
+	}, logger)
+	require.NoError(t, err)
+	t.Cleanup(func() { sink.Close() })
+
+	migrator, err := sink.Migrator()
+	require.NoError(t, err)
+	statuses, err := migrator.Status()
+	require.NoError(t, err)
+	require.Len(t, statuses, len(migrator.migrations))
+	for _, status := range statuses {
+		assert.Nil(t, status.AppliedAt)
+	}
+
+	exists, err := sink.db.dialect.tableExists(sink.db, "schema_migrations")
+	require.NoError(t, err)
+	assert.False(t, exists)
+}
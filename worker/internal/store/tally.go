@@ -0,0 +1,213 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// tallyLockName keeps replicas from reconciling at the same time
+const tallyLockName = "voting_tally_reconcile"
+
+// TallyKey identifies one option of one poll
+type TallyKey struct {
+	Poll string
+	Vote string
+}
+
+// TallyCount is the running tally of an option. Version grows with every
+// change and orders counts read by concurrent batches.
+type TallyCount struct {
+	Count   int64
+	Version int64
+}
+
+// TallyDeltas are per-option count changes made by a batch
+type TallyDeltas map[TallyKey]int64
+
+// Add records a count change for an option
+func (d TallyDeltas) Add(poll, vote string, delta int64) {
+	key := TallyKey{Poll: poll, Vote: vote}
+	d[key] += delta
+	if d[key] == 0 {
+		delete(d, key)
+	}
+}
+
+// Reconciliation is the outcome of a tally reconciliation
+type Reconciliation struct {
+	// Drift is the absolute difference found between vote_tallies and the
+	// votes table, per poll
+	Drift map[string]float64
+	// Corrections is the number of options whose tally was corrected
+	Corrections int
+}
+
+// updateTallies applies a batch's count changes to vote_tallies inside the batch transaction
+func updateTallies(tx *sqlTx, deltas TallyDeltas) error {
+	if len(deltas) == 0 {
+		return nil
+	}
+
+	placeholders := make([]string, 0, len(deltas))
+	args := make([]interface{}, 0, len(deltas)*3)
+	for key, delta := range deltas {
+		placeholders = append(placeholders, "(?, ?, ?)")
+		args = append(args, key.Poll, key.Vote, delta)
+	}
+
+	query := "INSERT INTO vote_tallies (poll_id, vote, count) VALUES " + strings.Join(placeholders, ", ") +
+		tx.dialect.upsert([]string{"poll_id", "vote"},
+			"count = vote_tallies.count + "+tx.dialect.excluded("count")+
+				", version = vote_tallies.version + 1, updated_at = CURRENT_TIMESTAMP")
+	if _, err := tx.Exec(query, args...); err != nil {
+		return fmt.Errorf("failed to update tallies: %w", err)
+	}
+	return nil
+}
+
+// Tallies reads the running tally of every option
+func (s *SQLSink) Tallies() (map[TallyKey]int64, error) {
+	rows, err := s.db.Query("SELECT poll_id, vote, count FROM vote_tallies")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tallies: %w", err)
+	}
+	return scanTallies(rows)
+}
+
+// TallyCounts reads the running tally and version of every option
+func (s *SQLSink) TallyCounts() (map[TallyKey]TallyCount, error) {
+	rows, err := s.db.Query("SELECT poll_id, vote, count, version FROM vote_tallies")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tallies: %w", err)
+	}
+	return scanTallyCounts(rows)
+}
+
+// ReconcileTallies compares vote_tallies with counts from the votes table and
+// corrects the drift. It returns nil when another replica is reconciling.
+func (s *SQLSink) ReconcileTallies() (*Reconciliation, error) {
+	ctx := context.Background()
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reserve reconcile connection: %w", err)
+	}
+	defer conn.Close()
+
+	// Only one replica reconciles at a time; the others skip this round
+	acquired, err := s.db.dialect.lock(ctx, conn, tallyLockName, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to acquire reconcile lock: %w", err)
+	}
+	if !acquired {
+		return nil, nil
+	}
+	defer s.db.dialect.unlock(ctx, conn, tallyLockName)
+
+	// Both reads see the same snapshot, so in-flight batches do not show up as drift
+	snapshot, err := conn.BeginTx(ctx, &sql.TxOptions{Isolation: s.db.dialect.snapshotIsolation()})
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin reconcile transaction: %w", err)
+	}
+	tx := &sqlTx{Tx: snapshot, ctx: ctx, dialect: s.db.dialect}
+	defer tx.Rollback()
+
+	expected, err := queryTallies(tx, "SELECT poll_id, vote, COUNT(*) FROM votes GROUP BY poll_id, vote")
+	if err != nil {
+		return nil, err
+	}
+	actual, err := queryTallies(tx, "SELECT poll_id, vote, count FROM vote_tallies")
+	if err != nil {
+		return nil, err
+	}
+
+	corrections := make(TallyDeltas)
+	for key, count := range expected {
+		corrections.Add(key.Poll, key.Vote, count-actual[key])
+	}
+	for key, count := range actual {
+		if _, ok := expected[key]; !ok {
+			corrections.Add(key.Poll, key.Vote, -count)
+		}
+	}
+
+	drift := make(map[string]float64)
+	for key := range expected {
+		drift[key.Poll] = 0
+	}
+	for key, delta := range corrections {
+		drift[key.Poll] += math.Abs(float64(delta))
+	}
+
+	// Corrections are applied as deltas so that batches committed since the
+	// snapshot are preserved
+	if err := updateTallies(tx, corrections); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit tally corrections: %w", err)
+	}
+
+	return &Reconciliation{Drift: drift, Corrections: len(corrections)}, nil
+}
+
+// queryTallyCounts reads the running tallies of the options a batch touched.
+// The batch's update still locks the rows, so versions follow commit order.
+func queryTallyCounts(tx *sqlTx, deltas TallyDeltas) (map[TallyKey]TallyCount, error) {
+	if len(deltas) == 0 {
+		return nil, nil
+	}
+
+	conditions := make([]string, 0, len(deltas))
+	args := make([]interface{}, 0, len(deltas)*2)
+	for key := range deltas {
+		conditions = append(conditions, "(poll_id = ? AND vote = ?)")
+		args = append(args, key.Poll, key.Vote)
+	}
+
+	rows, err := tx.Query("SELECT poll_id, vote, count, version FROM vote_tallies WHERE "+strings.Join(conditions, " OR "), args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read running tallies: %w", err)
+	}
+	return scanTallyCounts(rows)
+}
+
+func scanTallyCounts(rows *sql.Rows) (map[TallyKey]TallyCount, error) {
+	defer rows.Close()
+
+	counts := make(map[TallyKey]TallyCount)
+	for rows.Next() {
+		var key TallyKey
+		var count TallyCount
+		if err := rows.Scan(&key.Poll, &key.Vote, &count.Count, &count.Version); err != nil {
+			return nil, fmt.Errorf("failed to read running tallies: %w", err)
+		}
+		counts[key] = count
+	}
+	return counts, rows.Err()
+}
+
+func queryTallies(tx *sqlTx, query string) (map[TallyKey]int64, error) {
+	rows, err := tx.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tallies: %w", err)
+	}
+	return scanTallies(rows)
+}
+
+func scanTallies(rows *sql.Rows) (map[TallyKey]int64, error) {
+	defer rows.Close()
+
+	tallies := make(map[TallyKey]int64)
+	for rows.Next() {
+		var key TallyKey
+		var count int64
+		if err := rows.Scan(&key.Poll, &key.Vote, &count); err != nil {
+			return nil, fmt.Errorf("failed to read tallies: %w", err)
+		}
+		tallies[key] = count
+	}
+	return tallies, rows.Err()
+}
@@ -0,0 +1,115 @@
+// Package tracing sets up the OpenTelemetry tracer provider the worker
+// exports its spans with.
+package tracing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+
+	"worker/internal/config"
+)
+
+// Exporters selected by TRACING_EXPORTER
+const (
+	ExporterNone   = "none"
+	ExporterOTLP   = "otlp"
+	ExporterStdout = "stdout"
+	ExporterFile   = "file"
+)
+
+// ServiceName is the service.name the spans are reported under, unless
+// OTEL_SERVICE_NAME overrides it
+const ServiceName = "worker"
+
+// Provider is a tracer provider together with the exporter behind it
+type Provider struct {
+	trace.TracerProvider
+	shutdown func(context.Context) error
+}
+
+// Shutdown exports the spans still buffered and releases the exporter
+func (p *Provider) Shutdown(ctx context.Context) error {
+	if p.shutdown == nil {
+		return nil
+	}
+	return p.shutdown(ctx)
+}
+
+// New creates the tracer provider selected by TRACING_EXPORTER. The otlp
+// exporter sends spans over OTLP/HTTP to OTEL_EXPORTER_OTLP_ENDPOINT; stdout
+// and file write them as JSON, one span per line, and need no collector.
+// Sampling follows OTEL_TRACES_SAMPLER, recording every trace by default.
+func New(ctx context.Context, cfg *config.Config) (*Provider, error) {
+	if cfg.TracingExporter == ExporterNone || cfg.TracingExporter == "" {
+		return &Provider{TracerProvider: noop.NewTracerProvider()}, nil
+	}
+
+	// OTEL_SERVICE_NAME and OTEL_RESOURCE_ATTRIBUTES take precedence
+	res, err := resource.New(ctx,
+		resource.WithAttributes(
+			semconv.ServiceName(ServiceName),
+			semconv.ServiceInstanceID(cfg.WorkerID),
+		),
+		resource.WithTelemetrySDK(),
+		resource.WithFromEnv(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe trace resource: %w", err)
+	}
+
+	var (
+		exporter sdktrace.SpanExporter
+		file     *os.File
+	)
+	switch cfg.TracingExporter {
+	case ExporterOTLP:
+		exporter, err = otlptracehttp.New(ctx)
+	case ExporterStdout:
+		exporter, err = newJSONExporter(os.Stdout)
+	case ExporterFile:
+		file, err = os.OpenFile(cfg.TracingFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open trace file: %w", err)
+		}
+		exporter, err = newJSONExporter(file)
+	default:
+		return nil, fmt.Errorf("unknown tracing exporter %q", cfg.TracingExporter)
+	}
+	if err != nil {
+		if file != nil {
+			file.Close()
+		}
+		return nil, fmt.Errorf("failed to create %s trace exporter: %w", cfg.TracingExporter, err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	return &Provider{
+		TracerProvider: provider,
+		shutdown: func(ctx context.Context) error {
+			err := provider.Shutdown(ctx)
+			if file != nil {
+				err = errors.Join(err, file.Close())
+			}
+			return err
+		},
+	}, nil
+}
+
+// newJSONExporter writes spans as compact JSON lines
+func newJSONExporter(w io.Writer) (sdktrace.SpanExporter, error) {
+	return stdouttrace.New(stdouttrace.WithWriter(w))
+}
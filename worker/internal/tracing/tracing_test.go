@@ -0,0 +1,75 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"worker/internal/config"
+)
+
+func TestNoneRecordsNothing(t *testing.T) {
+	provider, err := New(context.Background(), &config.Config{TracingExporter: ExporterNone})
+	require.NoError(t, err)
+
+	_, span := provider.Tracer("test").Start(context.Background(), "noop")
+	assert.False(t, span.SpanContext().IsValid())
+	span.End()
+	assert.NoError(t, provider.Shutdown(context.Background()))
+}
+
+func TestFileExporterWritesSpans(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "traces.json")
+	provider, err := New(context.Background(), &config.Config{
+		TracingExporter: ExporterFile,
+		TracingFile:     path,
+		WorkerID:        "worker-1",
+	})
+	require.NoError(t, err)
+
+	ctx, parent := provider.Tracer("test").Start(context.Background(), "parent")
+	_, child := provider.Tracer("test").Start(ctx, "child")
+	child.End()
+	parent.End()
+
+	// Buffered spans are written on shutdown
+	require.NoError(t, provider.Shutdown(context.Background()))
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	require.Len(t, lines, 2, "one span per line")
+
+	var span struct {
+		Name        string
+		SpanContext struct{ TraceID string }
+		Resource    []struct {
+			Key   string
+			Value struct{ Value any }
+		}
+	}
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &span))
+	assert.Equal(t, "child", span.Name)
+	assert.Equal(t, parent.SpanContext().TraceID().String(), span.SpanContext.TraceID)
+
+	attributes := make(map[string]any)
+	for _, attribute := range span.Resource {
+		attributes[attribute.Key] = attribute.Value.Value
+	}
+	assert.Equal(t, ServiceName, attributes["service.name"])
+	assert.Equal(t, "worker-1", attributes["service.instance.id"])
+}
+
+func TestFileExporterNeedsWritablePath(t *testing.T) {
+	_, err := New(context.Background(), &config.Config{
+		TracingExporter: ExporterFile,
+		TracingFile:     filepath.Join(t.TempDir(), "missing", "traces.json"),
+	})
+	assert.Error(t, err)
+}
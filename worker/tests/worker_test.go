@@ -5,65 +5,32 @@ import (
 	"database/sql"
 	"encoding/json"
 	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
 	"testing"
 	"time"
 
 	"github.com/go-redis/redis/v8"
 	_ "github.com/go-sql-driver/mysql"
+	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"github.com/testcontainers/testcontainers-go"
 	"github.com/testcontainers/testcontainers-go/modules/mysql"
 	redistc "github.com/testcontainers/testcontainers-go/modules/redis"
 	"github.com/testcontainers/testcontainers-go/wait"
-)
-
-// parseTimestamp attempts to parse timestamp in multiple formats
-func parseTimestamp(timestampStr string) (time.Time, error) {
-	// List of formats to try, in order of preference
-	formats := []string{
-		time.RFC3339,                 // "2006-01-02T15:04:05Z07:00"
-		time.RFC3339Nano,             // "2006-01-02T15:04:05.999999999Z07:00"
-		"2006-01-02T15:04:05.999999", // Python isoformat() without timezone
-		"2006-01-02T15:04:05",        // Without microseconds and timezone
-		time.DateTime,                // "2006-01-02 15:04:05"
-	}
-
-	for _, format := range formats {
-		if t, err := time.Parse(format, timestampStr); err == nil {
-			// If parsing succeeds but no timezone info, assume UTC
-			if t.Location() == time.UTC && format != time.RFC3339 && format != time.RFC3339Nano {
-				return t.UTC(), nil
-			}
-			return t, nil
-		}
-	}
-
-	return time.Time{}, fmt.Errorf("unable to parse timestamp: %s", timestampStr)
-}
-
-// Vote represents a vote record for testing
-type Vote struct {
-	Vote      string `json:"vote"`
-	VoterID   string `json:"voter_id"`
-	Timestamp string `json:"timestamp"`
-}
 
-// TestingT represents the common interface between *testing.T and *testing.B
-type TestingT interface {
-	Helper()
-	Errorf(format string, args ...interface{})
-	FailNow()
-}
+	"worker/internal/config"
+	"worker/internal/httpserver"
+	"worker/internal/processor"
+	"worker/internal/queue"
+	"worker/internal/store"
+)
 
-// requireNoError is a helper function that works with both *testing.T and *testing.B
-func requireNoError(t TestingT, err error) {
-	t.Helper()
-	if err != nil {
-		t.Errorf("unexpected error: %v", err)
-		t.FailNow()
-	}
-}
+// eventually is how long a test waits for the worker to process its votes
+const eventually = 10 * time.Second
 
 // WorkerTestSuite contains test utilities
 type WorkerTestSuite struct {
@@ -74,7 +41,21 @@ type WorkerTestSuite struct {
 	ctx            context.Context
 }
 
-func setupTestSuite(t TestingT) *WorkerTestSuite {
+// skipWithoutDocker skips container tests when no Docker daemon is reachable
+func skipWithoutDocker(t testing.TB) {
+	t.Helper()
+
+	provider, err := testcontainers.ProviderDocker.GetProvider()
+	if err == nil {
+		err = provider.Health(context.Background())
+	}
+	if err != nil {
+		t.Skipf("Docker is not available: %v", err)
+	}
+}
+
+func setupTestSuite(t testing.TB) *WorkerTestSuite {
+	skipWithoutDocker(t)
 	ctx := context.Background()
 
 	// Start Redis container
@@ -82,13 +63,13 @@ func setupTestSuite(t TestingT) *WorkerTestSuite {
 		testcontainers.WithImage("redis:7-alpine"),
 		redistc.WithLogLevel(redistc.LogLevelVerbose),
 	)
-	requireNoError(t, err)
+	require.NoError(t, err)
 
 	// Get Redis connection details
 	redisHost, err := redisContainer.Host(ctx)
-	requireNoError(t, err)
+	require.NoError(t, err)
 	redisPort, err := redisContainer.MappedPort(ctx, "6379/tcp")
-	requireNoError(t, err)
+	require.NoError(t, err)
 
 	// Start MySQL container
 	mysqlContainer, err := mysql.RunContainer(ctx,
@@ -101,13 +82,24 @@ func setupTestSuite(t TestingT) *WorkerTestSuite {
 				WithOccurrence(1).
 				WithStartupTimeout(60*time.Second)),
 	)
-	requireNoError(t, err)
+	require.NoError(t, err)
 
 	// Get MySQL connection details
 	mysqlHost, err := mysqlContainer.Host(ctx)
-	requireNoError(t, err)
+	require.NoError(t, err)
 	mysqlPort, err := mysqlContainer.MappedPort(ctx, "3306/tcp")
-	requireNoError(t, err)
+	require.NoError(t, err)
+
+	// The worker reads its configuration from the environment, like in production
+	t.Setenv("REDIS_HOST", redisHost)
+	t.Setenv("REDIS_PORT", redisPort.Port())
+	t.Setenv("MYSQL_HOST", mysqlHost)
+	t.Setenv("MYSQL_PORT", mysqlPort.Port())
+	t.Setenv("MYSQL_USER", "root")
+	t.Setenv("MYSQL_PASSWORD", "testpass")
+	t.Setenv("MYSQL_DATABASE", "voting")
+	t.Setenv("WORKER_ID", "test-worker")
+	t.Setenv("BATCH_FLUSH_INTERVAL", "50ms")
 
 	// Connect to Redis
 	redisClient := redis.NewClient(&redis.Options{
@@ -118,7 +110,7 @@ func setupTestSuite(t TestingT) *WorkerTestSuite {
 	dsn := fmt.Sprintf("root:testpass@tcp(%s:%s)/voting?parseTime=true",
 		mysqlHost, mysqlPort.Port())
 	db, err := sql.Open("mysql", dsn)
-	requireNoError(t, err)
+	require.NoError(t, err)
 
 	// Wait for MySQL to be ready
 	for i := 0; i < 30; i++ {
@@ -127,28 +119,19 @@ func setupTestSuite(t TestingT) *WorkerTestSuite {
 		}
 		time.Sleep(1 * time.Second)
 	}
-	requireNoError(t, db.Ping())
-
-	// Initialize database schema
-	createTableSQL := `
-		CREATE TABLE IF NOT EXISTS votes (
-			id INT AUTO_INCREMENT PRIMARY KEY,
-			vote VARCHAR(10) NOT NULL,
-			voter_id VARCHAR(255) NOT NULL,
-			timestamp DATETIME NOT NULL,
-			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
-		)
-	`
-	_, err = db.Exec(createTableSQL)
-	requireNoError(t, err)
-
-	return &WorkerTestSuite{
+	require.NoError(t, db.Ping())
+
+	suite := &WorkerTestSuite{
 		redisContainer: redisContainer,
 		mysqlContainer: mysqlContainer,
 		redisClient:    redisClient,
 		db:             db,
 		ctx:            ctx,
 	}
+	// Cleanups run last-in first-out, so workers started by the test stop
+	// before their containers go away
+	t.Cleanup(suite.tearDown)
+	return suite
 }
 
 func (suite *WorkerTestSuite) tearDown() {
@@ -166,198 +149,231 @@ func (suite *WorkerTestSuite) tearDown() {
 	}
 }
 
-func TestWorkerVoteProcessing(t *testing.T) {
-	suite := setupTestSuite(t)
-	defer suite.tearDown()
-
-	// Create test vote
-	vote := Vote{
-		Vote:      "cats",
-		VoterID:   "192.168.1.1",
-		Timestamp: time.Now().Format(time.RFC3339),
-	}
+// startWorker starts a worker configured from the environment, with its
+// dependencies created the same way as in production. It returns the
+// handler serving the worker's HTTP endpoints.
+func startWorker(t testing.TB, deps processor.Dependencies) http.Handler {
+	t.Helper()
 
-	voteJSON, err := json.Marshal(vote)
+	cfg, err := config.Load("")
 	require.NoError(t, err)
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
 
-	// Push vote to Redis queue
-	err = suite.redisClient.LPush(suite.ctx, "votes", string(voteJSON)).Err()
-	require.NoError(t, err)
+	worker := processor.New(cfg, logger, deps)
+	require.NoError(t, worker.Start())
+	t.Cleanup(worker.Stop)
 
-	// Simulate worker processing by manually processing the vote
-	result, err := suite.redisClient.BRPop(suite.ctx, 1*time.Second, "votes").Result()
-	require.NoError(t, err)
-	require.Len(t, result, 2)
-
-	var processedVote Vote
-	err = json.Unmarshal([]byte(result[1]), &processedVote)
-	require.NoError(t, err)
+	return httpserver.New(cfg, worker, logger).Handler()
+}
 
-	// Verify vote data
-	assert.Equal(t, "cats", processedVote.Vote)
-	assert.Equal(t, "192.168.1.1", processedVote.VoterID)
+// encodeVote marshals a vote payload
+func encodeVote(t testing.TB, vote processor.Vote) string {
+	t.Helper()
 
-	// Parse timestamp
-	timestamp, err := parseTimestamp(processedVote.Timestamp)
+	payload, err := json.Marshal(vote)
 	require.NoError(t, err)
+	return string(payload)
+}
 
-	// Insert into database (simulating worker behavior)
-	_, err = suite.db.Exec(
-		"INSERT INTO votes (vote, voter_id, timestamp) VALUES (?, ?, ?)",
-		processedVote.Vote, processedVote.VoterID, timestamp,
-	)
-	require.NoError(t, err)
+// countVotes counts the stored votes matching a condition, or all of them
+// when where is empty
+func countVotes(t testing.TB, db *sql.DB, where string, args ...interface{}) int {
+	t.Helper()
+
+	query := "SELECT COUNT(*) FROM votes"
+	if where != "" {
+		query += " WHERE " + where
+	}
 
-	// Verify data was inserted
 	var count int
-	err = suite.db.QueryRow("SELECT COUNT(*) FROM votes WHERE vote = ?", "cats").Scan(&count)
+	require.NoError(t, db.QueryRow(query, args...).Scan(&count))
+	return count
+}
+
+// waitForVotes waits until the worker has stored the given number of votes
+func waitForVotes(t testing.TB, db *sql.DB, expected int) {
+	t.Helper()
+
+	require.Eventually(t, func() bool {
+		var count int
+		err := db.QueryRow("SELECT COUNT(*) FROM votes").Scan(&count)
+		return err == nil && count == expected
+	}, eventually, 20*time.Millisecond)
+}
+
+func TestWorkerVoteProcessing(t *testing.T) {
+	suite := setupTestSuite(t)
+	startWorker(t, processor.Dependencies{})
+
+	// Push vote to Redis queue
+	vote := encodeVote(t, processor.Vote{
+		Vote:      "cats",
+		VoterID:   "192.168.1.1",
+		Timestamp: "2023-01-01T12:00:00.123456",
+	})
+	require.NoError(t, suite.redisClient.LPush(suite.ctx, "votes", vote).Err())
+
+	waitForVotes(t, suite.db, 1)
+
+	// Verify vote data; a timestamp without an offset is stored as UTC
+	var voterID, pollID string
+	var timestamp time.Time
+	err := suite.db.QueryRow("SELECT voter_id, poll_id, timestamp FROM votes WHERE vote = ?", "cats").
+		Scan(&voterID, &pollID, &timestamp)
 	require.NoError(t, err)
-	assert.Equal(t, 1, count)
+	assert.Equal(t, "192.168.1.1", voterID)
+	assert.Equal(t, "default", pollID)
+	assert.Equal(t, time.Date(2023, 1, 1, 12, 0, 0, 0, time.UTC), timestamp.UTC())
+
+	// The vote is acknowledged once stored
+	require.Eventually(t, func() bool {
+		length, err := suite.redisClient.LLen(suite.ctx, "votes:processing:test-worker").Result()
+		return err == nil && length == 0
+	}, eventually, 20*time.Millisecond)
 }
 
 func TestMultipleVoteProcessing(t *testing.T) {
 	suite := setupTestSuite(t)
-	defer suite.tearDown()
+	startWorker(t, processor.Dependencies{})
 
-	// Create multiple test votes
-	votes := []Vote{
-		{
-			Vote:      "cats",
-			VoterID:   "192.168.1.1",
-			Timestamp: time.Now().Format(time.RFC3339),
-		},
-		{
-			Vote:      "dogs",
-			VoterID:   "192.168.1.2",
-			Timestamp: time.Now().Format(time.RFC3339),
-		},
-		{
-			Vote:      "cats",
-			VoterID:   "192.168.1.3",
-			Timestamp: time.Now().Format(time.RFC3339),
-		},
+	votes := []processor.Vote{
+		{Vote: "cats", VoterID: "192.168.1.1", Timestamp: time.Now().Format(time.RFC3339)},
+		{Vote: "dogs", VoterID: "192.168.1.2", Timestamp: time.Now().Format(time.RFC3339)},
+		{Vote: "cats", VoterID: "192.168.1.3", Timestamp: time.Now().Format(time.RFC3339)},
 	}
 
 	// Push all votes to Redis
 	for _, vote := range votes {
-		voteJSON, err := json.Marshal(vote)
-		require.NoError(t, err)
-		err = suite.redisClient.LPush(suite.ctx, "votes", string(voteJSON)).Err()
-		require.NoError(t, err)
+		require.NoError(t, suite.redisClient.LPush(suite.ctx, "votes", encodeVote(t, vote)).Err())
 	}
 
-	// Process all votes
-	for i := 0; i < len(votes); i++ {
-		result, err := suite.redisClient.BRPop(suite.ctx, 1*time.Second, "votes").Result()
-		require.NoError(t, err)
-
-		var vote Vote
-		err = json.Unmarshal([]byte(result[1]), &vote)
-		require.NoError(t, err)
-
-		timestamp, err := parseTimestamp(vote.Timestamp)
-		require.NoError(t, err)
-
-		_, err = suite.db.Exec(
-			"INSERT INTO votes (vote, voter_id, timestamp) VALUES (?, ?, ?)",
-			vote.Vote, vote.VoterID, timestamp,
-		)
-		require.NoError(t, err)
-	}
-
-	// Verify all votes were processed
-	var totalCount int
-	err := suite.db.QueryRow("SELECT COUNT(*) FROM votes").Scan(&totalCount)
-	require.NoError(t, err)
-	assert.Equal(t, 3, totalCount)
+	waitForVotes(t, suite.db, 3)
 
 	// Verify vote distribution
-	var catsCount, dogsCount int
-	err = suite.db.QueryRow("SELECT COUNT(*) FROM votes WHERE vote = ?", "cats").Scan(&catsCount)
-	require.NoError(t, err)
-	err = suite.db.QueryRow("SELECT COUNT(*) FROM votes WHERE vote = ?", "dogs").Scan(&dogsCount)
-	require.NoError(t, err)
-
-	assert.Equal(t, 2, catsCount)
-	assert.Equal(t, 1, dogsCount)
+	assert.Equal(t, 2, countVotes(t, suite.db, "vote = ?", "cats"))
+	assert.Equal(t, 1, countVotes(t, suite.db, "vote = ?", "dogs"))
+
+	// Redelivering a vote does not store it twice
+	require.NoError(t, suite.redisClient.LPush(suite.ctx, "votes", encodeVote(t, votes[0])).Err())
+	require.Eventually(t, func() bool {
+		length, err := suite.redisClient.LLen(suite.ctx, "votes").Result()
+		return err == nil && length == 0
+	}, eventually, 20*time.Millisecond)
+	time.Sleep(200 * time.Millisecond)
+	assert.Equal(t, 2, countVotes(t, suite.db, "vote = ?", "cats"))
 }
 
-func TestRedisConnectionHandling(t *testing.T) {
+func TestStreamVoteProcessing(t *testing.T) {
 	suite := setupTestSuite(t)
-	defer suite.tearDown()
+	t.Setenv("QUEUE_BACKEND", queue.BackendStream)
+	startWorker(t, processor.Dependencies{})
 
-	// Test Redis ping
-	pong, err := suite.redisClient.Ping(suite.ctx).Result()
+	err := suite.redisClient.XAdd(suite.ctx, &redis.XAddArgs{
+		Stream: "votes",
+		Values: map[string]interface{}{
+			"payload": encodeVote(t, processor.Vote{Vote: "dogs", VoterID: "192.168.1.1"}),
+		},
+	}).Err()
 	require.NoError(t, err)
-	assert.Equal(t, "PONG", pong)
 
-	// Test Redis operations
-	err = suite.redisClient.Set(suite.ctx, "test_key", "test_value", 0).Err()
-	require.NoError(t, err)
+	waitForVotes(t, suite.db, 1)
+	assert.Equal(t, 1, countVotes(t, suite.db, "vote = ?", "dogs"))
 
-	val, err := suite.redisClient.Get(suite.ctx, "test_key").Result()
-	require.NoError(t, err)
-	assert.Equal(t, "test_value", val)
+	// Acknowledged entries leave nothing pending on the consumer group
+	require.Eventually(t, func() bool {
+		pending, err := suite.redisClient.XPending(suite.ctx, "votes", "workers").Result()
+		return err == nil && pending.Count == 0
+	}, eventually, 20*time.Millisecond)
 }
 
-func TestDatabaseConnectionHandling(t *testing.T) {
+func TestWorkerHealthCheck(t *testing.T) {
 	suite := setupTestSuite(t)
-	defer suite.tearDown()
-
-	// Test database ping
-	err := suite.db.Ping()
-	require.NoError(t, err)
-
-	// Test database operations
-	_, err = suite.db.Exec("CREATE TABLE IF NOT EXISTS test_table (id INT PRIMARY KEY, name VARCHAR(50))")
-	require.NoError(t, err)
-
-	_, err = suite.db.Exec("INSERT INTO test_table (id, name) VALUES (1, 'test')")
-	require.NoError(t, err)
-
-	var name string
-	err = suite.db.QueryRow("SELECT name FROM test_table WHERE id = 1").Scan(&name)
-	require.NoError(t, err)
-	assert.Equal(t, "test", name)
-
-	_, err = suite.db.Exec("DROP TABLE test_table")
-	require.NoError(t, err)
+	t.Setenv("HEALTH_CHECK_INTERVAL", "50ms")
+	handler := startWorker(t, processor.Dependencies{})
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	require.Equal(t, http.StatusOK, recorder.Code)
+
+	var health map[string]interface{}
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &health))
+	assert.Equal(t, "healthy", health["status"])
+	assert.Equal(t, "connected", health["redis"])
+	assert.Equal(t, "connected", health["database"])
+
+	// The detailed report includes both connection pools
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/health", nil))
+	require.Equal(t, http.StatusOK, recorder.Code)
+
+	var report processor.HealthReport
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &report))
+	assert.True(t, report.Dependencies["redis"].Healthy)
+	assert.NotNil(t, report.DatabasePool)
+	assert.NotNil(t, report.RedisPool)
+
+	// Losing Redis makes the worker report itself not ready once the
+	// health monitor has noticed, while it stays alive
+	require.NoError(t, suite.redisContainer.Stop(suite.ctx, nil))
+
+	require.Eventually(t, func() bool {
+		recorder = httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		return recorder.Code == http.StatusServiceUnavailable
+	}, eventually, 20*time.Millisecond)
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &health))
+	assert.Equal(t, "unhealthy", health["status"])
+	assert.Equal(t, "disconnected", health["redis"])
+
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/livez", nil))
+	assert.Equal(t, http.StatusOK, recorder.Code)
 }
 
 func TestInvalidVoteDataHandling(t *testing.T) {
 	suite := setupTestSuite(t)
-	defer suite.tearDown()
+	startWorker(t, processor.Dependencies{})
 
 	// Push invalid JSON to Redis
-	err := suite.redisClient.LPush(suite.ctx, "votes", "invalid json").Err()
-	require.NoError(t, err)
-
-	// Try to process invalid vote
-	result, err := suite.redisClient.BRPop(suite.ctx, 1*time.Second, "votes").Result()
-	require.NoError(t, err)
-
-	var vote Vote
-	err = json.Unmarshal([]byte(result[1]), &vote)
-	assert.Error(t, err) // Should fail to unmarshal
+	require.NoError(t, suite.redisClient.LPush(suite.ctx, "votes", "invalid json").Err())
+
+	// The worker moves it to the dead-letter queue instead of storing it
+	var entries []string
+	require.Eventually(t, func() bool {
+		var err error
+		entries, err = suite.redisClient.LRange(suite.ctx, "votes:dlq", 0, -1).Result()
+		return err == nil && len(entries) == 1
+	}, eventually, 20*time.Millisecond)
+
+	var entry queue.DeadLetter
+	require.NoError(t, json.Unmarshal([]byte(entries[0]), &entry))
+	assert.Equal(t, "invalid json", entry.Payload)
+	assert.Equal(t, "decode", entry.Reason)
+	assert.Zero(t, countVotes(t, suite.db, ""))
 }
 
 func TestEmptyQueueHandling(t *testing.T) {
 	suite := setupTestSuite(t)
-	defer suite.tearDown()
+	startWorker(t, processor.Dependencies{})
 
-	// Try to pop from empty queue with timeout
-	_, err := suite.redisClient.BRPop(suite.ctx, 100*time.Millisecond, "votes").Result()
-	assert.Equal(t, redis.Nil, err) // Should timeout
+	// An idle worker keeps waiting without storing anything
+	time.Sleep(1500 * time.Millisecond)
+	assert.Zero(t, countVotes(t, suite.db, ""))
+
+	length, err := suite.redisClient.LLen(suite.ctx, "votes:processing:test-worker").Result()
+	require.NoError(t, err)
+	assert.Zero(t, length)
 }
 
 func TestDatabaseSchemaValidation(t *testing.T) {
 	suite := setupTestSuite(t)
-	defer suite.tearDown()
+	startWorker(t, processor.Dependencies{})
 
-	// Check that votes table exists and has correct structure
+	// Check that the migrations created the votes table with the expected structure
 	rows, err := suite.db.Query(`
-		SELECT COLUMN_NAME, DATA_TYPE 
-		FROM INFORMATION_SCHEMA.COLUMNS 
+		SELECT COLUMN_NAME, DATA_TYPE
+		FROM INFORMATION_SCHEMA.COLUMNS
 		WHERE TABLE_SCHEMA = 'voting' AND TABLE_NAME = 'votes'
 		ORDER BY ORDINAL_POSITION
 	`)
@@ -366,6 +382,8 @@ func TestDatabaseSchemaValidation(t *testing.T) {
 
 	expectedColumns := map[string]string{
 		"id":         "int",
+		"vote_id":    "varchar",
+		"poll_id":    "varchar",
 		"vote":       "varchar",
 		"voter_id":   "varchar",
 		"timestamp":  "datetime",
@@ -387,41 +405,102 @@ func TestDatabaseSchemaValidation(t *testing.T) {
 	}
 }
 
-func BenchmarkVoteProcessing(b *testing.B) {
-	suite := setupTestSuite(b)
-	defer suite.tearDown()
+// useInMemoryDependencies configures a worker that needs neither Redis nor
+// MySQL: votes come from an in-memory queue and go to a SQLite file
+func useInMemoryDependencies(t *testing.T) (processor.Dependencies, *queue.Memory, *sql.DB) {
+	t.Helper()
 
-	vote := Vote{
-		Vote:      "cats",
-		VoterID:   "192.168.1.1",
-		Timestamp: time.Now().Format(time.RFC3339),
-	}
+	path := filepath.Join(t.TempDir(), "voting.db")
+	t.Setenv("DB_DRIVER", store.DriverSQLite)
+	t.Setenv("SQLITE_PATH", path)
+	t.Setenv("BATCH_FLUSH_INTERVAL", "10ms")
+	t.Setenv("ADMIN_TOKEN", "test-token")
 
-	voteJSON, err := json.Marshal(vote)
-	require.NoError(b, err)
+	cfg, err := config.Load("")
+	require.NoError(t, err)
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
 
-	b.ResetTimer()
+	sink, err := store.New(cfg, logger)
+	require.NoError(t, err)
+	source := queue.NewMemory()
 
-	for i := 0; i < b.N; i++ {
-		// Push vote to Redis
-		err := suite.redisClient.LPush(suite.ctx, "votes", string(voteJSON)).Err()
-		require.NoError(b, err)
+	db, err := sql.Open("sqlite", path)
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	return processor.Dependencies{Queue: source, Sink: sink}, source, db
+}
+
+func TestInMemoryVoteProcessing(t *testing.T) {
+	deps, source, db := useInMemoryDependencies(t)
+	startWorker(t, deps)
+
+	source.Push(
+		encodeVote(t, processor.Vote{VoteID: "v1", Vote: "cats", VoterID: "user1", Timestamp: "2023-01-01T12:00:00Z"}),
+		encodeVote(t, processor.Vote{VoteID: "v2", Vote: "dogs", VoterID: "user2", Timestamp: "2023-01-01 12:00:01"}),
+		encodeVote(t, processor.Vote{VoteID: "v3", PollID: "pets", Vote: "cats", VoterID: "user3"}),
+		// Redelivery of an earlier vote
+		encodeVote(t, processor.Vote{VoteID: "v1", Vote: "cats", VoterID: "user1", Timestamp: "2023-01-01T12:00:00Z"}),
+	)
+
+	waitForVotes(t, db, 3)
+	require.Eventually(t, func() bool {
+		return source.Len() == 0 && source.InFlight() == 0
+	}, eventually, 10*time.Millisecond)
 
-		// Process vote
-		result, err := suite.redisClient.BRPop(suite.ctx, 1*time.Second, "votes").Result()
-		require.NoError(b, err)
+	assert.Equal(t, 2, countVotes(t, db, "vote = ?", "cats"))
+	assert.Equal(t, 1, countVotes(t, db, "poll_id = ?", "pets"))
 
-		var processedVote Vote
-		err = json.Unmarshal([]byte(result[1]), &processedVote)
-		require.NoError(b, err)
+	var count int64
+	require.NoError(t, db.QueryRow("SELECT count FROM vote_tallies WHERE poll_id = ? AND vote = ?", "default", "cats").Scan(&count))
+	assert.Equal(t, int64(1), count)
+}
+
+func TestInMemoryInvalidVoteHandling(t *testing.T) {
+	deps, source, db := useInMemoryDependencies(t)
+	handler := startWorker(t, deps)
+
+	source.Push("invalid json", encodeVote(t, processor.Vote{Vote: "cats"}))
+
+	require.Eventually(t, func() bool {
+		count, err := source.DeadLetterCount(context.Background())
+		return err == nil && count == 2
+	}, eventually, 10*time.Millisecond)
+	assert.Zero(t, countVotes(t, db, ""))
+
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest(http.MethodGet, "/admin/dlq", nil)
+	request.Header.Set("Authorization", "Bearer test-token")
+	handler.ServeHTTP(recorder, request)
+	require.Equal(t, http.StatusOK, recorder.Code)
+
+	var list struct {
+		Entries []queue.DeadLetter `json:"entries"`
+	}
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &list))
+	require.Len(t, list.Entries, 2)
+	assert.Equal(t, "validation", list.Entries[0].Reason)
+	assert.Equal(t, "decode", list.Entries[1].Reason)
+}
+
+func BenchmarkVoteProcessing(b *testing.B) {
+	suite := setupTestSuite(b)
+	startWorker(b, processor.Dependencies{})
 
-		timestamp, err := parseTimestamp(processedVote.Timestamp)
-		require.NoError(b, err)
+	b.ResetTimer()
 
-		_, err = suite.db.Exec(
-			"INSERT INTO votes (vote, voter_id, timestamp) VALUES (?, ?, ?)",
-			processedVote.Vote, processedVote.VoterID, timestamp,
-		)
-		require.NoError(b, err)
+	for i := 0; i < b.N; i++ {
+		// Push vote to Redis
+		vote := encodeVote(b, processor.Vote{
+			VoteID:    fmt.Sprintf("bench-%d", i),
+			Vote:      "cats",
+			VoterID:   "192.168.1.1",
+			Timestamp: time.Now().Format(time.RFC3339),
+		})
+		require.NoError(b, suite.redisClient.LPush(suite.ctx, "votes", vote).Err())
 	}
+
+	// Wait for the worker to store them all
+	waitForVotes(b, suite.db, b.N)
 }